@@ -4,7 +4,6 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,68 +11,183 @@ import (
 	"syscall"
 	"time"
 	"vhub/pkg/api/v1"
+	"vhub/pkg/audit"
+	"vhub/pkg/certs"
 	"vhub/pkg/checker"
 	"vhub/pkg/data"
+	"vhub/pkg/oidc"
+	"vhub/pkg/rbac"
 
 	"github.com/sirupsen/logrus"
 )
 
-func StartBackupSavingInterval(interval time.Duration) {
+func StartCompactionInterval(store *data.JSONStore, interval time.Duration) {
 	go func() {
 		for {
 			time.Sleep(interval)
 
-			backupFilePath := data.BackupFilePath
-			if err := data.SaveData(backupFilePath); err != nil {
+			if err := store.Compact(); err != nil {
 				data.Log.WithFields(logrus.Fields{
 					"error": err,
-				}).Error("Failed to save backup data at interval")
+				}).Error("Failed to compact journal at interval")
 			} else {
-				data.Log.Debug("Backup data saved successfully at interval")
+				data.Log.Debug("Journal compacted successfully at interval")
 			}
 		}
 	}()
 }
 
+func openJSONStore(dataFilePath string) (*data.JSONStore, error) {
+	// Set the backup file path based on the primary data file path
+	backupFilePath := dataFilePath + ".backup"
+
+	// Create file if not exists
+	if err := data.CreateFileIfNotExists(dataFilePath); err != nil {
+		return nil, err
+	}
+
+	if err := data.CreateFileIfNotExists(backupFilePath); err != nil {
+		return nil, err
+	}
+
+	store := data.NewJSONStore(dataFilePath, backupFilePath)
+	if err := store.Load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
 func main() {
 	// Command-line flags
 	host := flag.String("host", "localhost", "Define host of the server")
 	port := flag.String("port", "8080", "Define port of the server")
 	filePath := flag.String("filePath", "", "Define path of the data file")
+	storeType := flag.String("store", "json", "Storage backend to use: json or sqlite")
+	importFile := flag.String("import", "", "Import regions from a data.json file into the store on startup")
+	tlsCert := flag.String("tls-cert", "", "Serve HTTPS with this certificate file; reloaded when it changes")
+	tlsKey := flag.String("tls-key", "", "Private key file of -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "Verify client certificates against these CAs and accept them as identities")
+	tlsRequireClientCert := flag.Bool("tls-require-client-cert", false, "Refuse connections without a client certificate")
+	auth := flag.Bool("auth", true, "Require bearer tokens on the /api/v1 routes")
+	oidcIssuer := flag.String("oidc-issuer", "", "Accept JWTs from this OpenID Connect issuer")
+	oidcAudience := flag.String("oidc-audience", "vhub", "Audience JWTs must be issued for")
+	oidcJWKS := flag.String("oidc-jwks", "", "Path or URL of the issuer's JSON Web Key Set; discovered from the issuer if empty")
+	oidcGroupsClaim := flag.String("oidc-groups-claim", "groups", "JWT claim listing the caller's groups")
+	rbacConfig := flag.String("rbac-config", "", "JSON file of roles and the bindings of callers to them")
+	watchBuffer := flag.Int("watch-buffer", api.DefaultWatchBufferSize, "Number of change events kept for watch clients to resume from")
+	auditLog := flag.String("audit-log", "", "Path of the audit log; defaults to the data file path with .audit appended")
+	verifyAudit := flag.Bool("verify-audit", false, "Verify the hash chain of the audit log and exit")
 	enableHealthCheck := flag.Bool("checker", false, "Enable health check")
 	checkerConfig := flag.String("checker-config", "config/checker.json", "supply config for checker")
 	flag.Parse()
 
+	var dataFilePath string
 	if *filePath == "" {
-		logrus.Warn("No -filePath flag provided. Defaulting to $(pwd)/data.json")
+		defaultFile := "data.json"
+		if *storeType == "sqlite" {
+			defaultFile = "data.db"
+		}
+		logrus.Warnf("No -filePath flag provided. Defaulting to $(pwd)/%s", defaultFile)
 		executable, err := os.Executable()
 		if err != nil {
 			panic(err)
 		}
 		executableDir := filepath.Dir(executable)
-		data.DataFilePath = filepath.Join(executableDir, "data.json")
+		dataFilePath = filepath.Join(executableDir, defaultFile)
 	} else {
-		data.DataFilePath = *filePath
+		dataFilePath = *filePath
 	}
 
-	// Set the backup file path based on the primary data file path
-	data.BackupFilePath = data.DataFilePath + ".backup"
+	auditPath := *auditLog
+	if auditPath == "" {
+		auditPath = dataFilePath + ".audit"
+	}
+	if *verifyAudit {
+		count, head, err := audit.Verify(auditPath)
+		if err != nil {
+			logrus.Fatalf("Audit log %s failed verification after %d entries: %v", auditPath, count, err)
+		}
+		fmt.Printf("%s: %d entries verified, head %s\n", auditPath, count, head)
+		return
+	}
 
-	// Create file if not exists
-	if err := data.CreateFileIfNotExists(data.DataFilePath); err != nil {
-		log.Fatal(err)
+	var store data.Store
+	switch *storeType {
+	case "json":
+		jsonStore, err := openJSONStore(dataFilePath)
+		if err != nil {
+			logrus.Fatalf("Failed to load data: %v", err)
+		}
+
+		// Start the journal compaction interval
+		StartCompactionInterval(jsonStore, 5*time.Minute)
+		store = jsonStore
+	case "sqlite":
+		sqliteStore, err := data.NewSQLiteStore(dataFilePath)
+		if err != nil {
+			logrus.Fatalf("Failed to open SQLite database: %v", err)
+		}
+		store = sqliteStore
+	default:
+		logrus.Fatalf("Unknown -store %q, expected json or sqlite", *storeType)
 	}
 
-	if err := data.CreateFileIfNotExists(data.BackupFilePath); err != nil {
-		log.Fatal(err)
+	if *importFile != "" {
+		if err := data.ImportJSONFile(store, *importFile); err != nil {
+			logrus.Fatalf("Failed to import %s: %v", *importFile, err)
+		}
+	}
+
+	if *auth {
+		secret, err := api.BootstrapToken(store)
+		if err != nil {
+			logrus.Fatalf("Failed to create bootstrap token: %v", err)
+		}
+		if secret != "" {
+			// The secret goes to the terminal only, not into the log.
+			fmt.Fprintf(os.Stderr, "\nCreated admin token bootstrap-admin. Its secret is not shown again:\n\n    %s\n\n", secret)
+			logrus.Warn("Created admin token bootstrap-admin; set VHUB_BOOTSTRAP_TOKEN to choose its secret")
+		}
+	} else {
+		logrus.Warn("Authentication is disabled; anyone can change the data")
+	}
+	var (
+		verifier *oidc.Verifier
+		policy   *rbac.Policy
+		err      error
+	)
+	if *oidcIssuer != "" {
+		verifier, err = oidc.NewVerifier(oidc.Config{
+			Issuer:      *oidcIssuer,
+			Audience:    *oidcAudience,
+			JWKS:        *oidcJWKS,
+			GroupsClaim: *oidcGroupsClaim,
+			Leeway:      time.Minute,
+		})
+		if err != nil {
+			logrus.Fatalf("Failed to set up OIDC: %v", err)
+		}
+	}
+	if *rbacConfig != "" {
+		policy, err = rbac.Load(*rbacConfig)
+		if err != nil {
+			logrus.Fatalf("Failed to load %s: %v", *rbacConfig, err)
+		}
 	}
 
-	if err := data.LoadData(); err != nil {
-		logrus.Fatalf("Failed to load data: %v", err)
+	auditLogFile, err := audit.Open(auditPath)
+	if err != nil {
+		logrus.Fatalf("Failed to open audit log: %v", err)
 	}
 
 	// Initialize and check the router
-	router, err := api.NewRouter() // Update this according to your new routing setup
+	handlers, err := api.New(store, api.Options{
+		AuthEnabled:     *auth,
+		Policy:          policy,
+		Identities:      verifier,
+		Audit:           auditLogFile,
+		WatchBufferSize: *watchBuffer,
+	})
 	if err != nil {
 		logrus.Fatalf("Failed to initialize router: %v", err)
 	}
@@ -81,15 +195,34 @@ func main() {
 	// Create a new server
 	server := &http.Server{
 		Addr:    fmt.Sprintf("%s:%s", *host, *port),
-		Handler: router,
+		Handler: handlers.Router(),
 	}
+	server.RegisterOnShutdown(handlers.Stop)
 
-	// Start the backup saving interval
-	StartBackupSavingInterval(5 * time.Minute)
+	if (*tlsCert == "") != (*tlsKey == "") {
+		logrus.Fatal("-tls-cert and -tls-key must be given together")
+	}
+	if *tlsCert != "" {
+		reloader, err := certs.NewReloader(*tlsCert, *tlsKey, *tlsClientCA, *tlsRequireClientCert)
+		if err != nil {
+			logrus.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		reloader.Watch(10 * time.Second)
+		server.TLSConfig = reloader.TLSConfig()
+		server.RegisterOnShutdown(reloader.Stop)
+	} else if *tlsClientCA != "" {
+		logrus.Fatal("-tls-client-ca needs -tls-cert and -tls-key")
+	}
 
 	// Start the server in a goroutine
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logrus.Fatalf("Server failed to start: %v", err)
 		}
 	}()
@@ -113,6 +246,14 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		logrus.Errorf("Server shutdown failed: %v", err)
 	}
+	checker.StopHealthChecks()
+
+	if err := store.Close(); err != nil {
+		logrus.Errorf("Failed to close data store: %v", err)
+	}
+	if err := auditLogFile.Close(); err != nil {
+		logrus.Errorf("Failed to close audit log: %v", err)
+	}
 
 	logrus.Println("Server exited properly")
 }
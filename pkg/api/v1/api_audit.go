@@ -0,0 +1,308 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vhub/pkg/audit"
+	"vhub/pkg/data"
+	"vhub/pkg/patch"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// AuditPage is the response body of ListAudit.
+type AuditPage struct {
+	Total   int           `json:"total"`
+	Offset  int           `json:"offset"`
+	Limit   int           `json:"limit"`
+	Entries []audit.Entry `json:"entries"`
+}
+
+// ListAudit handles the GET request to list the audit log, newest first. The
+// actor, method and path (a prefix) query parameters filter the entries, from
+// and to (RFC 3339) restrict the time range and offset and limit select a
+// page.
+func (a *API) ListAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, total, err := a.audit.Query(filter)
+	if err != nil {
+		data.Log.WithField("error", err).Error("Failed to read audit log")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to read audit log")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, AuditPage{
+		Total:   total,
+		Offset:  filter.Offset,
+		Limit:   filter.Limit,
+		Entries: entries,
+	})
+}
+
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	params := r.URL.Query()
+	filter := audit.Filter{
+		Actor:      params.Get("actor"),
+		Method:     params.Get("method"),
+		PathPrefix: params.Get("path"),
+		Limit:      defaultAuditLimit,
+	}
+
+	var err error
+	if v := params.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errBadParam("from")
+		}
+	}
+	if v := params.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errBadParam("to")
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			return filter, errBadParam("offset")
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 {
+			return filter, errBadParam("limit")
+		}
+		if filter.Limit > maxAuditLimit {
+			filter.Limit = maxAuditLimit
+		}
+	}
+	return filter, nil
+}
+
+// auditRecorder passes a response through, keeping its status and body.
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *auditRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *auditRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// auditCapture holds the object a request changes as it was before and after
+// the request's transaction.
+type auditCapture struct {
+	load          func(tx data.Tx) json.RawMessage
+	before, after json.RawMessage
+}
+
+type auditCaptureKey struct{}
+
+// update runs fn in a read-write transaction of the store. For a request
+// recorded in the audit log, the object it changes is loaded inside the same
+// transaction before and after fn, so that no concurrent change can get into
+// the record. If fn fails, the object is recorded as unchanged.
+func (a *API) update(r *http.Request, fn func(tx data.Tx) error) error {
+	capture, _ := r.Context().Value(auditCaptureKey{}).(*auditCapture)
+	if capture == nil || capture.load == nil {
+		return a.store.Update(fn)
+	}
+
+	var before, after json.RawMessage
+	err := a.store.Update(func(tx data.Tx) error {
+		before = capture.load(tx)
+		if err := fn(tx); err != nil {
+			return err
+		}
+		after = capture.load(tx)
+		return nil
+	})
+	capture.before = before
+	capture.after = after
+	if err != nil {
+		capture.after = before
+	}
+	return err
+}
+
+// auditRequests is the middleware recording mutating requests to the audit log, with
+// the object they change as it was before and after. Handlers must make their
+// changes through update for these to be known.
+func (a *API) auditRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		template := ""
+		if route := mux.CurrentRoute(r); route != nil {
+			template, _ = route.GetPathTemplate()
+		}
+		capture := &auditCapture{load: a.auditedObject(r.Method, template, mux.Vars(r))}
+		r = r.WithContext(context.WithValue(r.Context(), auditCaptureKey{}, capture))
+
+		rec := &auditRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		entry := audit.Entry{
+			Timestamp: time.Now().UTC(),
+			Actor:     RequestActor(r),
+			SourceIP:  sourceIP(r),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    rec.status,
+			Body:      redactJSON(body),
+			Before:    capture.before,
+		}
+		switch {
+		case capture.load != nil:
+			entry.After = capture.after
+		case rec.status == http.StatusCreated:
+			entry.After = redactJSON(rec.body.Bytes())
+		}
+		if entry.Before != nil || entry.After != nil {
+			entry.Diff = auditDiff(entry.Before, entry.After)
+		}
+
+		if err := a.audit.Append(entry); err != nil {
+			data.Log.WithField("error", err).WithField("path", entry.Path).Error("Failed to write audit log")
+		}
+	})
+}
+
+// auditedObject returns a function loading the object a request changes, or
+// nil for requests creating one, which is then taken from the response.
+func (a *API) auditedObject(method, template string, vars map[string]string) func(tx data.Tx) json.RawMessage {
+	switch {
+	case strings.HasSuffix(template, "/promote"):
+		return func(tx data.Tx) json.RawMessage {
+			region, err := tx.GetRegion(vars["region"])
+			if err != nil {
+				return nil
+			}
+			target, ok := region.NextStage(vars["environment"])
+			if !ok {
+				return nil
+			}
+			return auditJSON(tx.GetApp(vars["region"], target, vars["app"]))
+		}
+	case method == http.MethodPost && !strings.HasSuffix(template, "/rollback"):
+		return nil
+	case vars["webhook"] != "":
+		return func(tx data.Tx) json.RawMessage {
+			hook, err := tx.GetWebhook(vars["webhook"])
+			return auditJSON(redactSecret(hook), err)
+		}
+	case vars["lock"] != "":
+		return func(tx data.Tx) json.RawMessage { return auditJSON(tx.GetLock(vars["lock"])) }
+	case vars["token"] != "":
+		return func(tx data.Tx) json.RawMessage {
+			token, err := tx.GetToken(vars["token"])
+			token.Hash = ""
+			return auditJSON(token, err)
+		}
+	case vars["app"] != "":
+		return func(tx data.Tx) json.RawMessage {
+			return auditJSON(tx.GetApp(vars["region"], vars["environment"], vars["app"]))
+		}
+	case vars["environment"] != "":
+		return func(tx data.Tx) json.RawMessage {
+			return auditJSON(tx.GetEnvironment(vars["region"], vars["environment"]))
+		}
+	case vars["region"] != "":
+		return func(tx data.Tx) json.RawMessage { return auditJSON(tx.GetRegion(vars["region"])) }
+	}
+	return nil
+}
+
+// auditJSON encodes an object loaded from the store; missing objects are nil.
+func auditJSON(v interface{}, err error) json.RawMessage {
+	if err != nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// redactJSON masks the secret member of a JSON object, as found in webhook
+// and token requests and responses. Bodies that are not JSON are kept as a
+// string.
+func redactJSON(b []byte) json.RawMessage {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(b, &object); err == nil {
+		if _, ok := object["secret"]; ok {
+			object["secret"] = json.RawMessage(`"[redacted]"`)
+		}
+		redacted, _ := json.Marshal(object)
+		return redacted
+	}
+
+	var compacted bytes.Buffer
+	if json.Compact(&compacted, b) == nil {
+		return compacted.Bytes()
+	}
+	quoted, _ := json.Marshal(string(b))
+	return quoted
+}
+
+func auditDiff(before, after json.RawMessage) json.RawMessage {
+	null := json.RawMessage("null")
+	if before == nil {
+		before = null
+	}
+	if after == nil {
+		after = null
+	}
+	ops, err := patch.Diff(before, after)
+	if err != nil {
+		return nil
+	}
+	b, _ := json.Marshal(ops)
+	return b
+}
+
+// sourceIP returns the address a request came from.
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"vhub/pkg/data"
+
+	"github.com/gorilla/mux"
+)
+
+// CanIResponse tells whether the caller may make a request.
+type CanIResponse struct {
+	Allowed    bool            `json:"allowed"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Permission data.Permission `json:"permission"`
+	Subject    string          `json:"subject,omitempty"`
+}
+
+// CanI handles the GET request asking whether the caller may make the request
+// given by the method and path parameters. Paths may leave out /api/v1.
+func (a *API) CanI(w http.ResponseWriter, r *http.Request) {
+	method := strings.ToUpper(r.URL.Query().Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		RespondWithError(w, http.StatusBadRequest, "The path parameter is required")
+		return
+	}
+	if !strings.HasPrefix(path, "/api/v1/") {
+		path = "/api/v1/" + strings.TrimPrefix(path, "/")
+	}
+
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, errBadParam("path").Error())
+		return
+	}
+	var match mux.RouteMatch
+	if !a.router.Match(req, &match) || match.Route == nil {
+		RespondWithError(w, http.StatusNotFound, "No route matches "+method+" "+req.URL.Path)
+		return
+	}
+	template, _ := match.Route.GetPathTemplate()
+
+	perm := permissionFor(method, template, match.Vars)
+	if strings.HasSuffix(template, "/promote") {
+		perm = a.promotePermission(match.Vars)
+	}
+
+	response := CanIResponse{Allowed: true, Method: method, Path: req.URL.Path, Permission: perm}
+	if caller := requestPrincipal(r); caller != nil {
+		response.Allowed = caller.allows(perm)
+		response.Subject = caller.Name
+	}
+	RespondWithJSON(w, http.StatusOK, response)
+}
+
+// promotePermission returns what promoting an app requires: write on the
+// next stage of the pipeline. Without one the promotion fails whoever asks.
+func (a *API) promotePermission(vars map[string]string) data.Permission {
+	perm := data.Permission{Action: data.ActionWrite, Region: vars["region"]}
+	if region, err := a.store.GetRegion(vars["region"]); err == nil {
+		perm.Environment, _ = region.NextStage(vars["environment"])
+	}
+	return perm
+}
@@ -2,81 +2,100 @@ package api
 
 import (
 	"net/http"
-
 	"vhub/pkg/data"
 
 	"github.com/gorilla/mux"
 )
 
-// DeleteRegion handles the DELETE request to delete a region.
-func DeleteRegion(w http.ResponseWriter, r *http.Request) {
+// DeleteRegion handles the DELETE request to delete a region. An If-Match header
+// makes the deletion conditional on the region's current ETag.
+func (a *API) DeleteRegion(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 
-	data.Mutex.Lock()
-	defer data.Mutex.Unlock()
-
-	if _, exists := data.GlobalData.Regions[regionName]; !exists {
-		RespondWithError(w, http.StatusNotFound, "Region not found")
+	err := a.update(r, func(tx data.Tx) error {
+		region, err := tx.GetRegion(regionName)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, region.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, ""); err != nil {
+			return err
+		}
+		if err := tx.DeleteRegion(regionName); err != nil {
+			return err
+		}
+		return recordRegionChanges(tx, r, regionName, region.Environments, nil)
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
 
-	delete(data.GlobalData.Regions, regionName)
-
 	RespondWithJSON(w, http.StatusOK, "Region deleted successfully")
 }
 
 // DeleteEnvironment handles the DELETE request to delete an environment within a region.
-func DeleteEnvironment(w http.ResponseWriter, r *http.Request) {
+// An If-Match header makes the deletion conditional on the environment's current ETag.
+func (a *API) DeleteEnvironment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 	environmentName := vars["environment"]
 
-	data.Mutex.Lock()
-	defer data.Mutex.Unlock()
-
-	region, exists := data.GlobalData.Regions[regionName]
-	if !exists {
-		RespondWithError(w, http.StatusNotFound, "Region not found")
-		return
-	}
-
-	if _, exists := region.Environments[environmentName]; !exists {
-		RespondWithError(w, http.StatusNotFound, "Environment not found")
+	err := a.update(r, func(tx data.Tx) error {
+		environment, err := tx.GetEnvironment(regionName, environmentName)
+		if err != nil {
+			return err
+		}
+		if err := checkEnvironmentIfMatch(tx, r, regionName, environmentName, environment.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, environmentName); err != nil {
+			return err
+		}
+		if err := tx.DeleteEnvironment(regionName, environmentName); err != nil {
+			return err
+		}
+		return recordEnvironmentChanges(tx, r, regionName, environmentName, environment.Apps, nil)
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
 
-	delete(region.Environments, environmentName)
-	data.GlobalData.Regions[regionName] = region
-
 	RespondWithJSON(w, http.StatusOK, "Environment deleted successfully")
 }
 
-// DeleteApp handles the DELETE request to delete an app within an environment.
-func DeleteApp(w http.ResponseWriter, r *http.Request) {
+// DeleteApp handles the DELETE request to delete an app within an environment. An
+// If-Match header makes the deletion conditional on the app's current ETag.
+func (a *API) DeleteApp(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 	environmentName := vars["environment"]
 	appName := vars["app"]
 
-	data.Mutex.Lock()
-	defer data.Mutex.Unlock()
-
-	region, ok1 := data.GlobalData.Regions[regionName]
-	environment, ok2 := region.Environments[environmentName]
-	if !ok1 || !ok2 {
-		RespondWithError(w, http.StatusNotFound, "Region or environment not found")
-		return
-	}
-
-	if _, exists := environment.Apps[appName]; !exists {
-		RespondWithError(w, http.StatusNotFound, "App not found")
+	err := a.update(r, func(tx data.Tx) error {
+		app, err := tx.GetApp(regionName, environmentName, appName)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, app.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, environmentName); err != nil {
+			return err
+		}
+		if err := tx.DeleteApp(regionName, environmentName, appName); err != nil {
+			return err
+		}
+		return recordAppChange(tx, r, regionName, environmentName, appName, data.HistoryActionDelete, changeNote(r), app, data.App{})
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
 
-	delete(environment.Apps, appName)
-	region.Environments[environmentName] = environment
-	data.GlobalData.Regions[regionName] = region
-
 	RespondWithJSON(w, http.StatusOK, "App deleted successfully")
 }
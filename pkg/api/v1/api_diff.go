@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"vhub/pkg/data"
+)
+
+// GetDiff handles the GET request to compare the apps of two environments
+// (from=amer/qa&to=amer/prod) or of two regions (from=amer&to=emea).
+func (a *API) GetDiff(w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if from == "" || to == "" {
+		RespondWithError(w, http.StatusBadRequest, "Both from and to parameters are required")
+		return
+	}
+
+	fromRegion, fromEnv, fromIsEnv := strings.Cut(from, "/")
+	toRegion, toEnv, toIsEnv := strings.Cut(to, "/")
+	if fromIsEnv != toIsEnv {
+		RespondWithError(w, http.StatusBadRequest, "from and to must both be regions or both be region/environment")
+		return
+	}
+
+	var diff data.Diff
+	err := a.store.View(func(tx data.Tx) error {
+		if fromIsEnv {
+			fromEnvironment, err := tx.GetEnvironment(fromRegion, fromEnv)
+			if err != nil {
+				return err
+			}
+			toEnvironment, err := tx.GetEnvironment(toRegion, toEnv)
+			if err != nil {
+				return err
+			}
+			diff = data.DiffEnvironments(from, fromEnvironment, to, toEnvironment)
+			return nil
+		}
+
+		fromR, err := tx.GetRegion(fromRegion)
+		if err != nil {
+			return err
+		}
+		toR, err := tx.GetRegion(toRegion)
+		if err != nil {
+			return err
+		}
+		diff = data.DiffRegions(fromR, toR)
+		return nil
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, diff)
+}
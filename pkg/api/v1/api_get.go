@@ -2,62 +2,71 @@ package api
 
 import (
 	"net/http"
+	"time"
 	"vhub/pkg/data"
 
 	"github.com/gorilla/mux"
 )
 
 // GetRegion handles the GET request to retrieve a specific region.
-func GetRegion(w http.ResponseWriter, r *http.Request) {
+func (a *API) GetRegion(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 
-	data.Mutex.RLock()
-	defer data.Mutex.RUnlock()
-
-	region, exists := data.GlobalData.Regions[regionName]
-	if !exists {
-		RespondWithError(w, http.StatusNotFound, "Region not found")
+	region, err := a.store.GetRegion(regionName)
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+	if notModified(w, r, region.Revision) {
 		return
 	}
 
 	RespondWithJSON(w, http.StatusOK, region)
 }
 
-// GetEnvironment handles the GET request to retrieve a specific environment within a region.
-func GetEnvironment(w http.ResponseWriter, r *http.Request) {
+// GetEnvironment handles the GET request to retrieve a specific environment within a region,
+// along with the locks in force on it. Its ETag changes when they do.
+func (a *API) GetEnvironment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 	environmentName := vars["environment"]
 
-	data.Mutex.RLock()
-	defer data.Mutex.RUnlock()
-
-	region, ok1 := data.GlobalData.Regions[regionName]
-	environment, ok2 := region.Environments[environmentName]
-	if !ok1 || !ok2 {
-		RespondWithError(w, http.StatusNotFound, "Region or environment not found")
+	var environment data.Environment
+	var locks []data.Lock
+	err := a.store.View(func(tx data.Tx) error {
+		var err error
+		if environment, err = tx.GetEnvironment(regionName, environmentName); err != nil {
+			return err
+		}
+		locks, err = tx.ListLocks()
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+	active := activeLocks(locks, regionName, environmentName, time.Now())
+	if notModifiedETag(w, r, environmentETag(environment.Revision, active)) {
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, environment)
+	RespondWithJSON(w, http.StatusOK, LockedEnvironment{Environment: environment, Locked: len(active) > 0, Locks: active})
 }
 
 // GetApp handles the GET request to retrieve a specific app within an environment.
-func GetApp(w http.ResponseWriter, r *http.Request) {
+func (a *API) GetApp(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 	environmentName := vars["environment"]
 	appName := vars["app"]
 
-	data.Mutex.RLock()
-	defer data.Mutex.RUnlock()
-
-	region, ok1 := data.GlobalData.Regions[regionName]
-	environment, ok2 := region.Environments[environmentName]
-	app, ok3 := environment.Apps[appName]
-	if !ok1 || !ok2 || !ok3 {
-		RespondWithError(w, http.StatusNotFound, "Region, environment, or app not found")
+	app, err := a.store.GetApp(regionName, environmentName, appName)
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+	if notModified(w, r, app.Revision) {
 		return
 	}
 
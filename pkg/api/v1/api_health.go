@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"vhub/pkg/checker"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultCheckResults is the number of results ListHealthChecks returns for
+// each check unless limit is given.
+const defaultCheckResults = 20
+
+// ListHealthChecks handles the GET request for the health checks with their
+// uptime and latest results, up to limit of them per check.
+func (a *API) ListHealthChecks(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseResultLimit(r, defaultCheckResults)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := parseMatrixFilter(r)
+	checks := make([]checker.CheckHistory, 0)
+	for _, check := range checker.GetHealthHistory(limit) {
+		if matchesAny(filter.Regions, check.Region) && matchesAny(filter.Environments, check.Environment) {
+			checks = append(checks, check)
+		}
+	}
+	RespondWithJSON(w, http.StatusOK, checks)
+}
+
+// GetHealthCheckHistory handles the GET request for the results kept of a
+// health check, oldest first, and its uptime. limit keeps only the latest
+// results.
+func (a *API) GetHealthCheckHistory(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseResultLimit(r, 0)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	check, ok := checker.GetCheckHistory(mux.Vars(r)["check"], limit)
+	if !ok {
+		RespondWithError(w, http.StatusNotFound, "Health check not found")
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, check)
+}
+
+func parseResultLimit(r *http.Request, limit int) (int, error) {
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return 0, errBadParam("limit")
+		}
+	}
+	return limit, nil
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"vhub/pkg/data"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// HistoryPage is the response body of GetAppHistory.
+type HistoryPage struct {
+	Total   int                 `json:"total"`
+	Offset  int                 `json:"offset"`
+	Limit   int                 `json:"limit"`
+	Entries []data.HistoryEntry `json:"entries"`
+}
+
+// GetAppHistory handles the GET request to list the deployment history of an app.
+// The optional from and to query parameters (RFC 3339) restrict the time range,
+// and offset and limit select a page of the newest-first results. sort=version
+// orders the results by version precedence instead.
+func (a *API) GetAppHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	regionName := vars["region"]
+	environmentName := vars["environment"]
+	appName := vars["app"]
+
+	query, err := parseHistoryQuery(r)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, total, err := a.store.ListHistory(regionName, environmentName, appName, query)
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, HistoryPage{
+		Total:   total,
+		Offset:  query.Offset,
+		Limit:   query.Limit,
+		Entries: entries,
+	})
+}
+
+func parseHistoryQuery(r *http.Request) (data.HistoryQuery, error) {
+	params := r.URL.Query()
+	query := data.HistoryQuery{Limit: defaultHistoryLimit}
+
+	switch params.Get("sort") {
+	case "", "time":
+	case "version":
+		query.SortByVersion = true
+	default:
+		return query, errBadParam("sort")
+	}
+
+	var err error
+	if v := params.Get("from"); v != "" {
+		if query.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, errBadParam("from")
+		}
+	}
+	if v := params.Get("to"); v != "" {
+		if query.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, errBadParam("to")
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		if query.Offset, err = strconv.Atoi(v); err != nil || query.Offset < 0 {
+			return query, errBadParam("offset")
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit < 1 {
+			return query, errBadParam("limit")
+		}
+		if query.Limit > maxHistoryLimit {
+			query.Limit = maxHistoryLimit
+		}
+	}
+	return query, nil
+}
+
+// recordAppChange appends a history entry to tx if the version or route of
+// the app changed between before and after, with the note given for it.
+func recordAppChange(tx data.Tx, r *http.Request, regionName, environmentName, appName, action, note string, before, after data.App) error {
+	if action == data.HistoryActionUpdate && before.Version == after.Version && before.Route == after.Route {
+		return nil
+	}
+
+	return tx.AppendHistory(data.HistoryEntry{
+		Region:      regionName,
+		Environment: environmentName,
+		App:         appName,
+		Action:      action,
+		OldVersion:  before.Version,
+		NewVersion:  after.Version,
+		OldRoute:    before.Route,
+		NewRoute:    after.Route,
+		Timestamp:   time.Now().UTC(),
+		Actor:       RequestActor(r),
+		Note:        note,
+	})
+}
+
+// changeNote returns the note a request gives for the changes it makes.
+func changeNote(r *http.Request) string {
+	return r.Header.Get("X-Change-Note")
+}
+
+// recordEnvironmentChanges appends history entries for every app created,
+// changed or removed between two versions of an environment's apps.
+func recordEnvironmentChanges(tx data.Tx, r *http.Request, regionName, environmentName string, before, after map[string]data.App) error {
+	for name, oldApp := range before {
+		newApp, exists := after[name]
+		action := data.HistoryActionUpdate
+		if !exists {
+			action = data.HistoryActionDelete
+		}
+		if err := recordAppChange(tx, r, regionName, environmentName, name, action, changeNote(r), oldApp, newApp); err != nil {
+			return err
+		}
+	}
+	for name, newApp := range after {
+		if _, exists := before[name]; exists {
+			continue
+		}
+		if err := recordAppChange(tx, r, regionName, environmentName, name, data.HistoryActionCreate, changeNote(r), data.App{}, newApp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRegionChanges appends history entries for every app created, changed
+// or removed between two versions of a region's environments.
+func recordRegionChanges(tx data.Tx, r *http.Request, regionName string, before, after map[string]data.Environment) error {
+	for name, oldEnv := range before {
+		if err := recordEnvironmentChanges(tx, r, regionName, name, oldEnv.Apps, after[name].Apps); err != nil {
+			return err
+		}
+	}
+	for name, newEnv := range after {
+		if _, exists := before[name]; exists {
+			continue
+		}
+		if err := recordEnvironmentChanges(tx, r, regionName, name, nil, newEnv.Apps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,66 +2,85 @@ package api
 
 import (
 	"net/http"
-
 	"vhub/pkg/data"
 
 	"github.com/gorilla/mux"
 )
 
 // ListRegions handles the GET request for listing all regions.
-func ListRegions(w http.ResponseWriter, r *http.Request) {
-	data.Mutex.RLock()
-	defer data.Mutex.RUnlock()
-
-	regions := make([]data.Region, 0, len(data.GlobalData.Regions))
-	for _, region := range data.GlobalData.Regions {
-		regions = append(regions, region)
+func (a *API) ListRegions(w http.ResponseWriter, r *http.Request) {
+	var regions []data.Region
+	var rev uint64
+
+	err := a.store.View(func(tx data.Tx) error {
+		var err error
+		if rev, err = tx.Revision(); err != nil {
+			return err
+		}
+		regions, err = tx.ListRegions()
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+	if notModified(w, r, rev) {
+		return
 	}
 
 	RespondWithJSON(w, http.StatusOK, regions)
 }
 
 // ListEnvironments handles the GET request for listing all environments in a region.
-func ListEnvironments(w http.ResponseWriter, r *http.Request) {
+func (a *API) ListEnvironments(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	regionName, ok := vars["region"]
-
-	data.Mutex.RLock()
-	defer data.Mutex.RUnlock()
-
-	region, ok := data.GlobalData.Regions[regionName]
-	if !ok {
-		RespondWithError(w, http.StatusNotFound, "Region not found")
+	regionName := vars["region"]
+
+	var region data.Region
+	var environments []data.Environment
+
+	err := a.store.View(func(tx data.Tx) error {
+		var err error
+		if region, err = tx.GetRegion(regionName); err != nil {
+			return err
+		}
+		environments, err = tx.ListEnvironments(regionName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
-
-	environments := make([]data.Environment, 0, len(region.Environments))
-	for _, env := range region.Environments {
-		environments = append(environments, env)
+	if notModified(w, r, region.Revision) {
+		return
 	}
 
 	RespondWithJSON(w, http.StatusOK, environments)
 }
 
 // ListApps handles the GET request for listing all apps in an environment.
-func ListApps(w http.ResponseWriter, r *http.Request) {
+func (a *API) ListApps(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	regionName, ok1 := vars["region"]
-	environmentName, ok2 := vars["environment"]
-
-	data.Mutex.RLock()
-	defer data.Mutex.RUnlock()
-
-	region, ok1 := data.GlobalData.Regions[regionName]
-	environment, ok2 := region.Environments[environmentName]
-	if !ok1 || !ok2 {
-		RespondWithError(w, http.StatusNotFound, "Region or environment not found")
+	regionName := vars["region"]
+	environmentName := vars["environment"]
+
+	var environment data.Environment
+	var apps []data.App
+
+	err := a.store.View(func(tx data.Tx) error {
+		var err error
+		if environment, err = tx.GetEnvironment(regionName, environmentName); err != nil {
+			return err
+		}
+		apps, err = tx.ListApps(regionName, environmentName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
-
-	apps := make([]data.App, 0, len(environment.Apps))
-	for _, app := range environment.Apps {
-		apps = append(apps, app)
+	if notModified(w, r, environment.Revision) {
+		return
 	}
 
 	RespondWithJSON(w, http.StatusOK, apps)
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"vhub/pkg/data"
+
+	"github.com/gorilla/mux"
+)
+
+// LockStatus is a lock as returned by the API, with whether it is in force.
+type LockStatus struct {
+	data.Lock
+	Kind   string `json:"kind"`
+	Active bool   `json:"active"`
+}
+
+// LockedEnvironment is the response body of GetEnvironment: the environment
+// and the locks stopping changes to it now.
+type LockedEnvironment struct {
+	data.Environment
+	Locked bool        `json:"locked"`
+	Locks  []data.Lock `json:"locks,omitempty"`
+}
+
+// lockedError is returned from a store transaction when a lock in force stops
+// the change.
+type lockedError struct {
+	lock data.Lock
+}
+
+func (e lockedError) Error() string {
+	target := e.lock.Region
+	if e.lock.Environment != "" {
+		target += "/" + e.lock.Environment
+	}
+	message := target + " is locked"
+	if e.lock.Kind() != data.LockKindManual {
+		message = target + " is in a freeze window"
+	}
+	if e.lock.End != nil {
+		message += " until " + e.lock.End.UTC().Format(time.RFC3339)
+	}
+	if e.lock.Reason != "" {
+		message += ": " + e.lock.Reason
+	}
+	return message
+}
+
+func lockStatus(lock data.Lock, now time.Time) LockStatus {
+	return LockStatus{Lock: lock, Kind: lock.Kind(), Active: lock.Active(now)}
+}
+
+// activeLocks returns the locks in force that cover environment of region, or
+// the whole region if environment is empty.
+func activeLocks(locks []data.Lock, region, environment string, now time.Time) []data.Lock {
+	var active []data.Lock
+	for _, lock := range locks {
+		if lock.Covers(region, environment) && lock.Active(now) {
+			active = append(active, lock)
+		}
+	}
+	return active
+}
+
+// checkUnlocked fails with a lockedError if a lock in force covers
+// environment of region, or any part of the region if environment is empty.
+// Admins may pass override=true to make the change anyway. Without
+// authentication nobody is known to be an admin, so locks cannot be
+// overridden and have to be lifted instead.
+func checkUnlocked(tx data.Tx, r *http.Request, region, environment string) error {
+	locks, err := tx.ListLocks()
+	if err != nil {
+		return err
+	}
+	active := activeLocks(locks, region, environment, time.Now())
+	if len(active) == 0 {
+		return nil
+	}
+	if !queryBool(r, "override") {
+		return lockedError{active[0]}
+	}
+	if caller := requestPrincipal(r); caller == nil || !caller.allows(data.Permission{Action: data.ActionAdmin}) {
+		return forbiddenError{"Only authenticated admins may override a lock"}
+	}
+	data.Log.WithField("actor", RequestActor(r)).WithField("lock", active[0].ID).WithField("path", r.URL.Path).Warn("Lock overridden")
+	return nil
+}
+
+// ListLocks handles the GET request for listing locks: all of them under
+// /locks, those of a region or environment under its path. The active query
+// parameter set to true keeps the locks in force only; lifted locks are left
+// out unless removed is true.
+func (a *API) ListLocks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	regionName := vars["region"]
+	environmentName := vars["environment"]
+
+	var locks []data.Lock
+	err := a.store.View(func(tx data.Tx) error {
+		var err error
+		switch {
+		case environmentName != "":
+			_, err = tx.GetEnvironment(regionName, environmentName)
+		case regionName != "":
+			_, err = tx.GetRegion(regionName)
+		}
+		if err != nil {
+			return err
+		}
+		locks, err = tx.ListLocks()
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	now := time.Now()
+	statuses := []LockStatus{}
+	for _, lock := range locks {
+		if regionName != "" && !lock.Covers(regionName, environmentName) {
+			continue
+		}
+		if lock.RemovedAt != nil && !queryBool(r, "removed") {
+			continue
+		}
+		status := lockStatus(lock, now)
+		if queryBool(r, "active") && !status.Active {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	RespondWithJSON(w, http.StatusOK, statuses)
+}
+
+// CreateLock handles the POST request to lock a region or an environment.
+// The body gives the reason and, for a freeze window, its start and end or
+// its cron schedule and duration.
+func (a *API) CreateLock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var lock data.Lock
+	if err := json.NewDecoder(r.Body).Decode(&lock); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	lock.ID = data.NewID()
+	lock.Region = vars["region"]
+	lock.Environment = vars["environment"]
+	lock.CreatedBy = RequestActor(r)
+	lock.CreatedAt = time.Now().UTC()
+	lock.RemovedBy = ""
+	lock.RemovedAt = nil
+	if lock.Start != nil {
+		start := lock.Start.UTC()
+		lock.Start = &start
+	}
+	if lock.End != nil {
+		end := lock.End.UTC()
+		lock.End = &end
+	}
+
+	if err := a.store.CreateLock(lock); err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	data.Log.WithField("actor", lock.CreatedBy).WithField("region", lock.Region).WithField("environment", lock.Environment).
+		WithField("kind", lock.Kind()).Info("Lock created")
+	RespondWithJSON(w, http.StatusCreated, lockStatus(lock, time.Now()))
+}
+
+// GetLock handles the GET request to retrieve a lock.
+func (a *API) GetLock(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["lock"]
+
+	lock, err := a.store.GetLock(id)
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, lockStatus(lock, time.Now()))
+}
+
+// DeleteLock handles the DELETE request to lift a lock, which is for admins.
+// The lock is kept with who lifted it and when.
+func (a *API) DeleteLock(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["lock"]
+	actor := RequestActor(r)
+
+	var lock data.Lock
+	err := a.update(r, func(tx data.Tx) error {
+		if err := tx.RemoveLock(id, actor, time.Now().UTC()); err != nil {
+			return err
+		}
+		var err error
+		lock, err = tx.GetLock(id)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	data.Log.WithField("actor", actor).WithField("lock", id).WithField("region", lock.Region).
+		WithField("environment", lock.Environment).WithField("kind", lock.Kind()).Info("Lock removed")
+	RespondWithJSON(w, http.StatusOK, lockStatus(lock, time.Now()))
+}
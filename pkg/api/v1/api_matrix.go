@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"vhub/pkg/data"
+)
+
+// GetMatrix handles the GET request for the app × region/environment version matrix.
+// The app, region and environment query parameters, repeated or comma separated,
+// restrict the matrix, and sort=version orders apps by their highest version.
+// It carries the store revision as ETag.
+func (a *API) GetMatrix(w http.ResponseWriter, r *http.Request) {
+	var regions []data.Region
+	var rev uint64
+
+	err := a.store.View(func(tx data.Tx) error {
+		var err error
+		if rev, err = tx.Revision(); err != nil {
+			return err
+		}
+		regions, err = tx.ListRegions()
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+	if notModified(w, r, rev) {
+		return
+	}
+
+	matrix := data.BuildMatrix(regions, parseMatrixFilter(r))
+	if r.URL.Query().Get("sort") == "version" {
+		matrix.SortByVersion()
+	}
+
+	RespondWithJSON(w, http.StatusOK, matrix)
+}
+
+func parseMatrixFilter(r *http.Request) data.MatrixFilter {
+	return data.MatrixFilter{
+		Apps:         queryList(r, "app"),
+		Regions:      queryList(r, "region"),
+		Environments: queryList(r, "environment"),
+	}
+}
+
+// queryList returns every value of a query parameter, splitting comma
+// separated values.
+func queryList(r *http.Request, key string) []string {
+	var values []string
+	for _, v := range r.URL.Query()[key] {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
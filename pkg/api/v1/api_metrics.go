@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"vhub/pkg/checker"
+	"vhub/pkg/data"
+)
+
+// labelEscaper escapes label values in the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Metrics handles the GET request for the metrics of the health and version
+// checks in the Prometheus text format.
+func (a *API) Metrics(w http.ResponseWriter, r *http.Request) {
+	var regions []data.Region
+	err := a.store.View(func(tx data.Tx) error {
+		var err error
+		regions, err = tx.ListRegions()
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	var checks []checker.HealthStatus
+	for _, check := range checker.GetHealthStatus() {
+		if check.Status != "Unknown" {
+			checks = append(checks, check)
+		}
+	}
+	writeMetricHeader(w, "vhub_health_check_up", "Whether the last run of a health check passed.")
+	for _, check := range checks {
+		writeMetric(w, "vhub_health_check_up", boolValue(check.Status == "OK"),
+			"id", check.ID, "region", check.Region, "environment", check.Environment, "url", check.URL)
+	}
+	writeMetricHeader(w, "vhub_health_check_latency_seconds", "How long the last run of a health check took.")
+	for _, check := range checks {
+		writeMetric(w, "vhub_health_check_latency_seconds", check.Latency.Seconds(),
+			"id", check.ID, "region", check.Region, "environment", check.Environment, "url", check.URL)
+	}
+
+	drift := checker.GetVersionDrift(regionMap(regions))
+	writeMetricHeader(w, "vhub_version_check_up", "Whether the last run of a version check could read the version of the app.")
+	for _, d := range drift {
+		if d.Status == "Unknown" {
+			continue
+		}
+		writeMetric(w, "vhub_version_check_up", boolValue(d.Status == "OK"),
+			"region", d.Region, "environment", d.Environment, "app", d.App)
+	}
+	// The versions are left to GET /api/v1/health/versions: as labels they
+	// would start a new series on every deployment.
+	writeMetricHeader(w, "vhub_app_version_drift", "Whether an app reports a version other than the one recorded for it.")
+	for _, d := range drift {
+		if d.Actual == "" {
+			continue
+		}
+		writeMetric(w, "vhub_app_version_drift", boolValue(d.Drift),
+			"region", d.Region, "environment", d.Environment, "app", d.App)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// writeMetric writes a sample of a metric with the given label names and
+// values, in pairs.
+func writeMetric(w io.Writer, name string, value float64, labels ...string) {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
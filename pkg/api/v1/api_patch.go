@@ -0,0 +1,321 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+	"vhub/pkg/data"
+	"vhub/pkg/patch"
+
+	"github.com/gorilla/mux"
+)
+
+const jsonPatchType = "application/json-patch+json"
+
+// patchFunc applies the body of a PATCH request to a JSON document
+type patchFunc func(doc []byte) ([]byte, error)
+
+// readPatch reads the body of a PATCH request. A Content-Type of
+// application/json-patch+json selects an RFC 6902 JSON patch; any other body
+// is treated as an RFC 7396 merge patch. If the body cannot be read an error
+// response is sent and ok is false.
+func readPatch(w http.ResponseWriter, r *http.Request) (apply patchFunc, ok bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return nil, false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == jsonPatchType {
+		return func(doc []byte) ([]byte, error) { return patch.Apply(doc, body) }, true
+	}
+	return func(doc []byte) ([]byte, error) { return patch.MergePatch(doc, body) }, true
+}
+
+// applyPatch applies a patch to current and decodes the result into target.
+// Revisions are set by the store, so a patch may not change them.
+func applyPatch(apply patchFunc, current, target interface{}) error {
+	original, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	doc, err := apply(original)
+	if err != nil {
+		if errors.Is(err, patch.ErrTestFailed) {
+			return conflictError{err.Error()}
+		}
+		return badRequestError{err.Error()}
+	}
+	var before, after interface{}
+	if err := json.Unmarshal(original, &before); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(doc, &after); err != nil {
+		return badRequestError{fmt.Sprintf("Patched document is invalid: %v", err)}
+	}
+	if changesRevision(before, after) {
+		return badRequestError{"Revisions are set by the server and cannot be patched"}
+	}
+	if err := json.Unmarshal(doc, target); err != nil {
+		return badRequestError{fmt.Sprintf("Patched document is invalid: %v", err)}
+	}
+	return nil
+}
+
+// changesRevision reports whether after gives an object of before another
+// revision. Objects added by the patch get theirs from the store.
+func changesRevision(before, after interface{}) bool {
+	object, ok := after.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	original, _ := before.(map[string]interface{})
+	if rev, ok := object["revision"]; ok {
+		if old, existed := original["revision"]; existed && rev != old {
+			return true
+		}
+	}
+	for key, value := range object {
+		if key != "revision" && changesRevision(original[key], value) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameStages(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPatchedApps fills in the names of apps added by a patch, applies the
+// version policy of environment to every app whose version changed and
+// stamps the date of those apps unless the patch set it.
+func checkPatchedApps(r *http.Request, environment data.Environment, before map[string]data.App) error {
+	now := time.Now().Format(time.RFC3339)
+	for name, app := range environment.Apps {
+		if app.Name == "" {
+			app.Name = name
+		}
+		if app.Name != name {
+			return badRequestError{fmt.Sprintf("App %s is listed under the name %s", app.Name, name)}
+		}
+
+		old, exists := before[name]
+		if !exists || old.Version != app.Version {
+			if err := environment.CheckVersion(old.Version, app.Version, queryBool(r, "force")); err != nil {
+				return err
+			}
+			if app.Date == old.Date {
+				app.Date = now
+			}
+		}
+		environment.Apps[name] = app
+	}
+	return nil
+}
+
+// PatchRegion handles the PATCH request to change part of a region. Environments
+// and apps not mentioned in the patch are kept. The pipeline is changed with
+// UpdatePipeline, where it is checked, not by patches; the environments are
+// checked like replacements of them with PUT.
+func (a *API) PatchRegion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	regionName := vars["region"]
+
+	apply, ok := readPatch(w, r)
+	if !ok {
+		return
+	}
+
+	var region data.Region
+	err := a.update(r, func(tx data.Tx) error {
+		oldRegion, err := tx.GetRegion(regionName)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, oldRegion.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, ""); err != nil {
+			return err
+		}
+
+		if err := applyPatch(apply, oldRegion, &region); err != nil {
+			return err
+		}
+		if region.Name != regionName {
+			return badRequestError{"Region name cannot be changed"}
+		}
+		if !sameStages(region.Pipeline, oldRegion.Pipeline) {
+			return badRequestError{fmt.Sprintf("The pipeline cannot be patched; PUT /api/v1/regions/%s/pipeline instead", regionName)}
+		}
+		if region.Environments == nil {
+			region.Environments = make(map[string]data.Environment)
+		}
+		for name, environment := range region.Environments {
+			if environment.Name == "" {
+				environment.Name = name
+			}
+			if environment.Name != name {
+				return badRequestError{fmt.Sprintf("Environment %s is listed under the name %s", environment.Name, name)}
+			}
+			if environment.Apps == nil {
+				environment.Apps = make(map[string]data.App)
+			}
+			if err := checkEnvironmentChange(r, environment, oldRegion.Environments[name]); err != nil {
+				return err
+			}
+			region.Environments[name] = environment
+		}
+
+		if err := tx.UpdateRegion(regionName, region); err != nil {
+			return err
+		}
+		if err := recordRegionChanges(tx, r, regionName, oldRegion.Environments, region.Environments); err != nil {
+			return err
+		}
+		region, err = tx.GetRegion(regionName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(region.Revision))
+	RespondWithJSON(w, http.StatusOK, region)
+}
+
+// PatchEnvironment handles the PATCH request to change part of an environment.
+// Apps not mentioned in the patch are kept. The result is checked like a
+// replacement of the environment with PUT.
+func (a *API) PatchEnvironment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	regionName := vars["region"]
+	environmentName := vars["environment"]
+
+	apply, ok := readPatch(w, r)
+	if !ok {
+		return
+	}
+
+	var environment data.Environment
+	err := a.update(r, func(tx data.Tx) error {
+		oldEnvironment, err := tx.GetEnvironment(regionName, environmentName)
+		if err != nil {
+			return err
+		}
+		if err := checkEnvironmentIfMatch(tx, r, regionName, environmentName, oldEnvironment.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, environmentName); err != nil {
+			return err
+		}
+
+		if err := applyPatch(apply, oldEnvironment, &environment); err != nil {
+			return err
+		}
+		if environment.Name != environmentName {
+			return badRequestError{"Environment name cannot be changed"}
+		}
+		if environment.Apps == nil {
+			environment.Apps = make(map[string]data.App)
+		}
+		if err := checkEnvironmentChange(r, environment, oldEnvironment); err != nil {
+			return err
+		}
+
+		if err := tx.UpdateEnvironment(regionName, environmentName, environment); err != nil {
+			return err
+		}
+		if err := recordEnvironmentChanges(tx, r, regionName, environmentName, oldEnvironment.Apps, environment.Apps); err != nil {
+			return err
+		}
+		environment, err = tx.GetEnvironment(regionName, environmentName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(environment.Revision))
+	RespondWithJSON(w, http.StatusOK, environment)
+}
+
+// PatchApp handles the PATCH request to change part of an app. The date is
+// stamped when the version changes unless the patch sets it.
+func (a *API) PatchApp(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	regionName := vars["region"]
+	environmentName := vars["environment"]
+	appName := vars["app"]
+
+	apply, ok := readPatch(w, r)
+	if !ok {
+		return
+	}
+
+	var app data.App
+	err := a.update(r, func(tx data.Tx) error {
+		oldApp, err := tx.GetApp(regionName, environmentName, appName)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, oldApp.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, environmentName); err != nil {
+			return err
+		}
+
+		if err := applyPatch(apply, oldApp, &app); err != nil {
+			return err
+		}
+		if app.Name != appName {
+			return badRequestError{"App name cannot be changed"}
+		}
+
+		if app.Version != oldApp.Version {
+			environment, err := tx.GetEnvironment(regionName, environmentName)
+			if err != nil {
+				return err
+			}
+			if err := environment.CheckVersion(oldApp.Version, app.Version, queryBool(r, "force")); err != nil {
+				return err
+			}
+			if app.Date == oldApp.Date {
+				app.Date = time.Now().Format(time.RFC3339)
+			}
+		}
+
+		if err := tx.UpdateApp(regionName, environmentName, appName, app); err != nil {
+			return err
+		}
+		if err := recordAppChange(tx, r, regionName, environmentName, appName, data.HistoryActionUpdate, changeNote(r), oldApp, app); err != nil {
+			return err
+		}
+		app, err = tx.GetApp(regionName, environmentName, appName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(app.Revision))
+	RespondWithJSON(w, http.StatusOK, app)
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"vhub/pkg/data"
+
+	"github.com/gorilla/mux"
+)
+
+// Pipeline is the request and response body of the pipeline endpoints.
+type Pipeline struct {
+	Region string   `json:"region"`
+	Stages []string `json:"stages"`
+}
+
+// PromoteRequest is the optional body of PromoteApp. To, when set, must name
+// the next stage of the pipeline.
+type PromoteRequest struct {
+	To   string `json:"to"`
+	Note string `json:"note"`
+}
+
+// GetPipeline handles the GET request to retrieve the promotion pipeline of a region.
+// It carries the ETag of the region.
+func (a *API) GetPipeline(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	regionName := vars["region"]
+
+	region, err := a.store.GetRegion(regionName)
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+	if notModified(w, r, region.Revision) {
+		return
+	}
+
+	stages := region.Pipeline
+	if stages == nil {
+		stages = []string{}
+	}
+	RespondWithJSON(w, http.StatusOK, Pipeline{Region: regionName, Stages: stages})
+}
+
+// UpdatePipeline handles the PUT request to replace the promotion pipeline of a region.
+// An If-Match header makes the update conditional on the region's current ETag.
+func (a *API) UpdatePipeline(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	regionName := vars["region"]
+
+	var pipeline Pipeline
+
+	if err := json.NewDecoder(r.Body).Decode(&pipeline); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	pipeline.Region = regionName
+	if pipeline.Stages == nil {
+		pipeline.Stages = []string{}
+	}
+
+	var region data.Region
+	err := a.update(r, func(tx data.Tx) error {
+		current, err := tx.GetRegion(regionName)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, current.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, ""); err != nil {
+			return err
+		}
+		if err := tx.SetPipeline(regionName, pipeline.Stages); err != nil {
+			return err
+		}
+		region, err = tx.GetRegion(regionName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(region.Revision))
+	RespondWithJSON(w, http.StatusOK, pipeline)
+}
+
+// PromoteApp handles the POST request to copy the version of an app to the next
+// stage of its region's promotion pipeline.
+// If the next stage already runs that version, nothing is written and the app
+// is returned as it is.
+func (a *API) PromoteApp(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	regionName := vars["region"]
+	environmentName := vars["environment"]
+	appName := vars["app"]
+
+	var req PromoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Note == "" {
+		req.Note = fmt.Sprintf("Promoted from %s/%s", regionName, environmentName)
+	}
+
+	var promoted data.App
+	err := a.update(r, func(tx data.Tx) error {
+		region, err := tx.GetRegion(regionName)
+		if err != nil {
+			return err
+		}
+
+		source, err := tx.GetApp(regionName, environmentName, appName)
+		if err != nil {
+			return err
+		}
+
+		target, ok := region.NextStage(environmentName)
+		if !ok {
+			return conflictError{fmt.Sprintf("Environment %s has no next stage in the pipeline of region %s", environmentName, regionName)}
+		}
+		if req.To != "" && req.To != target {
+			return conflictError{fmt.Sprintf("Environment %s is not the next stage after %s; expected %s", req.To, environmentName, target)}
+		}
+		if err := authorize(r, data.Permission{Action: data.ActionWrite, Region: regionName, Environment: target}); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, target); err != nil {
+			return err
+		}
+
+		targetEnvironment, err := tx.GetEnvironment(regionName, target)
+		if err != nil {
+			return err
+		}
+		existing, exists := targetEnvironment.Apps[appName]
+		if exists && existing.Version == source.Version {
+			promoted = existing
+			return nil
+		}
+		current := ""
+		if exists {
+			current = existing.Version
+		}
+		if err := targetEnvironment.CheckVersion(current, source.Version, queryBool(r, "force")); err != nil {
+			return err
+		}
+
+		now := time.Now().Format(time.RFC3339)
+		switch {
+		case !exists:
+			promoted = data.App{Name: appName, Version: source.Version, Route: source.Route, Date: now}
+			if err := tx.CreateApp(regionName, target, promoted); err != nil {
+				return err
+			}
+		default:
+			promoted = existing
+			promoted.Version = source.Version
+			promoted.Date = now
+			if err := tx.UpdateApp(regionName, target, appName, promoted); err != nil {
+				return err
+			}
+		}
+
+		err = tx.AppendHistory(data.HistoryEntry{
+			Region:      regionName,
+			Environment: target,
+			App:         appName,
+			Action:      data.HistoryActionPromote,
+			OldVersion:  existing.Version,
+			NewVersion:  promoted.Version,
+			OldRoute:    existing.Route,
+			NewRoute:    promoted.Route,
+			Timestamp:   time.Now().UTC(),
+			Actor:       RequestActor(r),
+			Note:        req.Note,
+		})
+		if err != nil {
+			return err
+		}
+		promoted, err = tx.GetApp(regionName, target, appName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(promoted.Revision))
+	RespondWithJSON(w, http.StatusOK, promoted)
+}
@@ -9,7 +9,7 @@ import (
 )
 
 // CreateRegion handles the POST request to create a new region.
-func CreateRegion(w http.ResponseWriter, r *http.Request) {
+func (a *API) CreateRegion(w http.ResponseWriter, r *http.Request) {
 	var region data.Region
 
 	if err := json.NewDecoder(r.Body).Decode(&region); err != nil {
@@ -17,29 +17,29 @@ func CreateRegion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data.Mutex.Lock()
-	defer data.Mutex.Unlock()
-
-	if _, exists := data.GlobalData.Regions[region.Name]; exists {
-		RespondWithError(w, http.StatusConflict, "Region already exists")
-		return
-	}
-
-	// Initialize the Apps map to an empty map
+	// Initialize the Environments map to an empty map
 	region.Environments = make(map[string]data.Environment)
-
-	data.GlobalData.Regions[region.Name] = region
-
-	if err := data.SaveData(data.DataFilePath); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to save data")
+	region.Pipeline = nil
+
+	err := a.update(r, func(tx data.Tx) error {
+		if err := tx.CreateRegion(region); err != nil {
+			return err
+		}
+		var err error
+		region, err = tx.GetRegion(region.Name)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", ETag(region.Revision))
 	RespondWithJSON(w, http.StatusCreated, region)
 }
 
 // CreateEnvironment handles the POST request to create a new environment within a region.
-func CreateEnvironment(w http.ResponseWriter, r *http.Request) {
+func (a *API) CreateEnvironment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 
@@ -50,40 +50,31 @@ func CreateEnvironment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data.Mutex.Lock()
-	defer data.Mutex.Unlock()
-
-	region, exists := data.GlobalData.Regions[regionName]
-	if !exists {
-		RespondWithError(w, http.StatusNotFound, "Region not found")
-		return
-	}
-
-	if region.Environments == nil {
-		region.Environments = make(map[string]data.Environment)
-	}
-
-	if _, exists := region.Environments[environment.Name]; exists {
-		RespondWithError(w, http.StatusConflict, "Environment already exists in this region")
-		return
-	}
-
 	// Initialize the Apps map to an empty map
 	environment.Apps = make(map[string]data.App)
 
-	region.Environments[environment.Name] = environment
-	data.GlobalData.Regions[regionName] = region
-
-	if err := data.SaveData(data.DataFilePath); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to save data")
+	err := a.update(r, func(tx data.Tx) error {
+		if err := checkUnlocked(tx, r, regionName, environment.Name); err != nil {
+			return err
+		}
+		if err := tx.CreateEnvironment(regionName, environment); err != nil {
+			return err
+		}
+		var err error
+		environment, err = tx.GetEnvironment(regionName, environment.Name)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", ETag(environment.Revision))
 	RespondWithJSON(w, http.StatusCreated, environment)
 }
 
 // CreateApp handles the POST request to create a new app within an environment.
-func CreateApp(w http.ResponseWriter, r *http.Request) {
+func (a *API) CreateApp(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 	environmentName := vars["environment"]
@@ -95,34 +86,32 @@ func CreateApp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data.Mutex.Lock()
-	defer data.Mutex.Unlock()
-
-	region, ok1 := data.GlobalData.Regions[regionName]
-	environment, ok2 := region.Environments[environmentName]
-	if !ok1 || !ok2 {
-		RespondWithError(w, http.StatusNotFound, "Region or environment not found")
-		return
-	}
-
-	// Initialize the Apps map if it's nil
-	if environment.Apps == nil {
-		environment.Apps = make(map[string]data.App)
-	}
-
-	if _, exists := environment.Apps[app.Name]; exists {
-		RespondWithError(w, http.StatusConflict, "App already exists in this environment")
-		return
-	}
-
-	environment.Apps[app.Name] = app
-	region.Environments[environmentName] = environment
-	data.GlobalData.Regions[regionName] = region
-
-	if err := data.SaveData(data.DataFilePath); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to save data")
+	err := a.update(r, func(tx data.Tx) error {
+		environment, err := tx.GetEnvironment(regionName, environmentName)
+		if err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, environmentName); err != nil {
+			return err
+		}
+		if err := environment.CheckVersion("", app.Version, queryBool(r, "force")); err != nil {
+			return err
+		}
+
+		if err := tx.CreateApp(regionName, environmentName, app); err != nil {
+			return err
+		}
+		if err := recordAppChange(tx, r, regionName, environmentName, app.Name, data.HistoryActionCreate, changeNote(r), data.App{}, app); err != nil {
+			return err
+		}
+		app, err = tx.GetApp(regionName, environmentName, app.Name)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", ETag(app.Revision))
 	RespondWithJSON(w, http.StatusCreated, app)
 }
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 	"vhub/pkg/data"
@@ -9,8 +10,10 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// UpdateRegion handles the PUT request to update an existing region.
-func UpdateRegion(w http.ResponseWriter, r *http.Request) {
+// UpdateRegion handles the PUT request to update an existing region. An If-Match
+// header makes the update conditional on the region's current ETag. The apps
+// of every environment are held to its version policy.
+func (a *API) UpdateRegion(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 
@@ -21,21 +24,48 @@ func UpdateRegion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data.Mutex.Lock()
-	defer data.Mutex.Unlock()
-
-	if _, exists := data.GlobalData.Regions[regionName]; !exists {
-		RespondWithError(w, http.StatusNotFound, "Region not found")
+	err := a.update(r, func(tx data.Tx) error {
+		oldRegion, err := tx.GetRegion(regionName)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, oldRegion.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, ""); err != nil {
+			return err
+		}
+		for name, environment := range region.Environments {
+			if environment.Apps == nil {
+				environment.Apps = make(map[string]data.App)
+			}
+			if err := checkEnvironmentChange(r, environment, oldRegion.Environments[name]); err != nil {
+				return err
+			}
+			region.Environments[name] = environment
+		}
+		if err := tx.UpdateRegion(regionName, region); err != nil {
+			return err
+		}
+		if err := recordRegionChanges(tx, r, regionName, oldRegion.Environments, region.Environments); err != nil {
+			return err
+		}
+		region, err = tx.GetRegion(regionName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
 
-	data.GlobalData.Regions[regionName] = region
-
+	w.Header().Set("ETag", ETag(region.Revision))
 	RespondWithJSON(w, http.StatusOK, region)
 }
 
-// UpdateEnvironment handles the PUT request to update an existing environment.
-func UpdateEnvironment(w http.ResponseWriter, r *http.Request) {
+// UpdateEnvironment handles the PUT request to update an existing environment. An
+// If-Match header makes the update conditional on the environment's current ETag.
+// The apps are held to the version policy of the updated environment.
+func (a *API) UpdateEnvironment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 	environmentName := vars["environment"]
@@ -47,28 +77,44 @@ func UpdateEnvironment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data.Mutex.Lock()
-	defer data.Mutex.Unlock()
-
-	region, exists := data.GlobalData.Regions[regionName]
-	if !exists {
-		RespondWithError(w, http.StatusNotFound, "Region not found")
+	err := a.update(r, func(tx data.Tx) error {
+		oldEnvironment, err := tx.GetEnvironment(regionName, environmentName)
+		if err != nil {
+			return err
+		}
+		if err := checkEnvironmentIfMatch(tx, r, regionName, environmentName, oldEnvironment.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, environmentName); err != nil {
+			return err
+		}
+		if environment.Apps == nil {
+			environment.Apps = make(map[string]data.App)
+		}
+		if err := checkEnvironmentChange(r, environment, oldEnvironment); err != nil {
+			return err
+		}
+		if err := tx.UpdateEnvironment(regionName, environmentName, environment); err != nil {
+			return err
+		}
+		if err := recordEnvironmentChanges(tx, r, regionName, environmentName, oldEnvironment.Apps, environment.Apps); err != nil {
+			return err
+		}
+		environment, err = tx.GetEnvironment(regionName, environmentName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
 
-	if _, exists := region.Environments[environmentName]; !exists {
-		RespondWithError(w, http.StatusNotFound, "Environment not found")
-		return
-	}
-
-	region.Environments[environmentName] = environment
-	data.GlobalData.Regions[regionName] = region
-
+	w.Header().Set("ETag", ETag(environment.Revision))
 	RespondWithJSON(w, http.StatusOK, environment)
 }
 
 // UpdateApp handles the PUT request to update an existing app or just update the version.
-func UpdateApp(w http.ResponseWriter, r *http.Request) {
+// An If-Match header makes the update conditional on the app's current ETag.
+func (a *API) UpdateApp(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	regionName := vars["region"]
 	environmentName := vars["environment"]
@@ -81,27 +127,59 @@ func UpdateApp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data.Mutex.Lock()
-	defer data.Mutex.Unlock()
-
-	region, ok1 := data.GlobalData.Regions[regionName]
-	environment, ok2 := region.Environments[environmentName]
-	oldApp, ok3 := environment.Apps[appName]
-	if !ok1 || !ok2 || !ok3 {
-		RespondWithError(w, http.StatusNotFound, "Region, environment, or app not found")
+	err := a.update(r, func(tx data.Tx) error {
+		oldApp, err := tx.GetApp(regionName, environmentName, appName)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, oldApp.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, environmentName); err != nil {
+			return err
+		}
+
+		// If only the version is updated, retain other fields and update the date
+		if app.Name == "" {
+			app.Name = oldApp.Name
+			app.Route = oldApp.Route
+			app.Date = time.Now().Format(time.RFC3339) // Update date
+		}
+
+		if app.Version != oldApp.Version {
+			environment, err := tx.GetEnvironment(regionName, environmentName)
+			if err != nil {
+				return err
+			}
+			if err := environment.CheckVersion(oldApp.Version, app.Version, queryBool(r, "force")); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.UpdateApp(regionName, environmentName, appName, app); err != nil {
+			return err
+		}
+		if err := recordAppChange(tx, r, regionName, environmentName, appName, data.HistoryActionUpdate, changeNote(r), oldApp, app); err != nil {
+			return err
+		}
+		app, err = tx.GetApp(regionName, environmentName, appName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
 		return
 	}
 
-	// If only the version is updated, retain other fields and update the date
-	if app.Name == "" {
-		app.Name = oldApp.Name
-		app.Route = oldApp.Route
-		app.Date = time.Now().Format(time.RFC3339) // Update date
-	}
-
-	environment.Apps[appName] = app
-	region.Environments[environmentName] = environment
-	data.GlobalData.Regions[regionName] = region
-
+	w.Header().Set("ETag", ETag(app.Revision))
 	RespondWithJSON(w, http.StatusOK, app)
 }
+
+// checkEnvironmentChange applies the version policy to a replacement of the
+// environment old, which is empty for a new one. Turning the policy off takes
+// force=true, like a downgrade, so that it cannot be used to slip one past it.
+func checkEnvironmentChange(r *http.Request, environment, old data.Environment) error {
+	if old.EnforceSemver && !environment.EnforceSemver && !queryBool(r, "force") {
+		return conflictError{fmt.Sprintf("Turning off enforceSemver of environment %s needs force=true", old.Name)}
+	}
+	return checkPatchedApps(r, environment, old.Apps)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"vhub/pkg/data"
+
+	"github.com/gorilla/mux"
+)
+
+// RollbackRequest is the optional body of RollbackApp. An empty Version rolls
+// back to the version deployed before the current one.
+type RollbackRequest struct {
+	Version string `json:"version"`
+	Note    string `json:"note"`
+}
+
+// RollbackApp handles the POST request to restore an earlier version and route of an app.
+// An If-Match header makes the rollback conditional on the app's current ETag.
+func (a *API) RollbackApp(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	regionName := vars["region"]
+	environmentName := vars["environment"]
+	appName := vars["app"]
+
+	var req RollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	note := req.Note
+	if note == "" {
+		note = changeNote(r)
+	}
+
+	var app data.App
+	err := a.update(r, func(tx data.Tx) error {
+		current, err := tx.GetApp(regionName, environmentName, appName)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, current.Revision); err != nil {
+			return err
+		}
+		if err := checkUnlocked(tx, r, regionName, environmentName); err != nil {
+			return err
+		}
+
+		history, _, err := tx.ListHistory(regionName, environmentName, appName, data.HistoryQuery{})
+		if err != nil {
+			return err
+		}
+
+		target, err := rollbackTarget(current, history, req.Version)
+		if err != nil {
+			return err
+		}
+
+		app = current
+		app.Version = target.Version
+		app.Route = target.Route
+		app.Date = time.Now().Format(time.RFC3339)
+
+		if err := tx.UpdateApp(regionName, environmentName, appName, app); err != nil {
+			return err
+		}
+		if err := recordAppChange(tx, r, regionName, environmentName, appName, data.HistoryActionRollback, note, current, app); err != nil {
+			return err
+		}
+		app, err = tx.GetApp(regionName, environmentName, appName)
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(app.Revision))
+	RespondWithJSON(w, http.StatusOK, app)
+}
+
+// rollbackTarget picks the version and route to restore from the app's
+// history, which is ordered newest first. Without a named version it is the
+// version that was replaced when the current version was deployed; rollbacks
+// are skipped so that repeated rollbacks keep walking back.
+func rollbackTarget(current data.App, history []data.HistoryEntry, version string) (data.App, error) {
+	if version != "" {
+		if version == current.Version {
+			return data.App{}, conflictError{fmt.Sprintf("App is already at version %s", version)}
+		}
+		for _, entry := range history {
+			if entry.NewVersion == version {
+				return data.App{Version: entry.NewVersion, Route: entry.NewRoute}, nil
+			}
+		}
+		return data.App{}, conflictError{fmt.Sprintf("Version %s not found in app history", version)}
+	}
+
+	for _, entry := range history {
+		if entry.Action == data.HistoryActionRollback || entry.NewVersion != current.Version || entry.NewRoute != current.Route {
+			continue
+		}
+		if entry.Action == data.HistoryActionCreate || entry.OldVersion == "" {
+			break
+		}
+		return data.App{Version: entry.OldVersion, Route: entry.OldRoute}, nil
+	}
+	return data.App{}, conflictError{"No earlier version to roll back to"}
+}
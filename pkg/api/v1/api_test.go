@@ -0,0 +1,369 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"vhub/pkg/data"
+	"vhub/pkg/rbac"
+)
+
+// Secrets of the tokens created by newTestAPI.
+const (
+	adminSecret  = "vhub_admin"
+	readSecret   = "vhub_read"
+	devSecret    = "vhub_dev"
+	policySecret = "vhub_policy"
+)
+
+// newTestAPI returns an API serving a memory store that holds region amer,
+// whose pipeline runs from dev, where app api is at 1.0.0, to an empty prod.
+// With auth enabled, the store also holds an admin token, a read token, a
+// token that may write to amer/dev, and one that is made an editor of
+// amer/dev by policy.
+func newTestAPI(t *testing.T, opts Options) (*API, data.Store) {
+	t.Helper()
+	store := data.NewMemoryStore()
+	err := store.Update(func(tx data.Tx) error {
+		err := tx.CreateRegion(data.Region{
+			Name: "amer",
+			Environments: map[string]data.Environment{
+				"dev":  {Name: "dev", Apps: map[string]data.App{"api": {Name: "api", Version: "1.0.0", Route: "/api"}}},
+				"prod": {Name: "prod"},
+			},
+			Pipeline: []string{"dev", "prod"},
+		})
+		if err != nil {
+			return err
+		}
+		tokens := []struct{ name, secret, scope string }{
+			{"admin", adminSecret, data.ActionAdmin},
+			{"reader", readSecret, data.ActionRead},
+			{"dev", devSecret, "write:amer/dev"},
+			{"ci", policySecret, data.ActionRead},
+		}
+		for _, token := range tokens {
+			err := tx.CreateToken(data.Token{ID: token.name, Name: token.name, Hash: data.HashToken(token.secret),
+				Scopes: []string{token.scope}, CreatedAt: time.Now().UTC()})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Policy == nil {
+		opts.Policy = &rbac.Policy{Bindings: []rbac.Binding{
+			{Subject: rbac.TokenSubject + "ci", Role: "editor", Region: "amer", Environment: "d*"},
+		}}
+	}
+
+	a, err := New(store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.Stop)
+	return a, store
+}
+
+// serve sends a request to a and returns the response. headers are given as
+// name, value pairs.
+func serve(a *API, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	a.Router().ServeHTTP(w, r)
+	return w
+}
+
+func bearer(secret string) []string {
+	return []string{"Authorization", "Bearer " + secret}
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+// expect fails the test unless w has the given status code.
+func expect(t *testing.T, w *httptest.ResponseRecorder, code int) {
+	t.Helper()
+	if w.Code != code {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, code, w.Body.String())
+	}
+}
+
+const appPath = "/api/v1/regions/amer/environments/dev/apps/api"
+
+func TestCRUD(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+
+	expect(t, serve(a, "POST", "/api/v1/regions", `{"name":"emea"}`), http.StatusCreated)
+	expect(t, serve(a, "POST", "/api/v1/regions", `{"name":"emea"}`), http.StatusConflict)
+	expect(t, serve(a, "POST", "/api/v1/regions/emea/environments", `{"name":"dev"}`), http.StatusCreated)
+	w := serve(a, "POST", "/api/v1/regions/emea/environments/dev/apps", `{"name":"web","version":"2.0.0","route":"/web"}`)
+	expect(t, w, http.StatusCreated)
+
+	w = serve(a, "GET", "/api/v1/regions/emea/environments/dev/apps/web", "")
+	expect(t, w, http.StatusOK)
+	var app data.App
+	decode(t, w, &app)
+	if app.Version != "2.0.0" || app.Route != "/web" {
+		t.Fatalf("GET app = %+v", app)
+	}
+
+	expect(t, serve(a, "PUT", "/api/v1/regions/emea/environments/dev/apps/web", `{"version":"2.1.0"}`), http.StatusOK)
+	decode(t, serve(a, "GET", "/api/v1/regions/emea/environments/dev/apps/web", ""), &app)
+	if app.Version != "2.1.0" || app.Route != "/web" {
+		t.Fatalf("GET app after PUT = %+v", app)
+	}
+
+	var regions []data.Region
+	decode(t, serve(a, "GET", "/api/v1/regions", ""), &regions)
+	if len(regions) != 2 {
+		t.Fatalf("GET regions = %+v", regions)
+	}
+
+	expect(t, serve(a, "DELETE", "/api/v1/regions/emea/environments/dev/apps/web", ""), http.StatusOK)
+	expect(t, serve(a, "GET", "/api/v1/regions/emea/environments/dev/apps/web", ""), http.StatusNotFound)
+	expect(t, serve(a, "DELETE", "/api/v1/regions/emea", ""), http.StatusOK)
+	expect(t, serve(a, "GET", "/api/v1/regions/emea", ""), http.StatusNotFound)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+
+	w := serve(a, "GET", appPath, "")
+	expect(t, w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("GET app has no ETag")
+	}
+
+	expect(t, serve(a, "GET", appPath, "", "If-None-Match", etag), http.StatusNotModified)
+	expect(t, serve(a, "PUT", appPath, `{"version":"1.1.0"}`, "If-Match", etag), http.StatusOK)
+	// The app has moved on, so the tag is stale
+	expect(t, serve(a, "GET", appPath, "", "If-None-Match", etag), http.StatusOK)
+	expect(t, serve(a, "PUT", appPath, `{"version":"1.2.0"}`, "If-Match", etag), http.StatusPreconditionFailed)
+	expect(t, serve(a, "DELETE", appPath, "", "If-Match", etag), http.StatusPreconditionFailed)
+
+	var app data.App
+	decode(t, serve(a, "GET", appPath, ""), &app)
+	if app.Version != "1.1.0" {
+		t.Fatalf("version after a failed precondition = %s, want 1.1.0", app.Version)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+		version     string
+		route       string
+	}{
+		{"merge patch", "application/merge-patch+json", `{"version":"1.1.0"}`, http.StatusOK, "1.1.0", "/api"},
+		{"json patch", jsonPatchType, `[{"op":"replace","path":"/route","value":"/v2"}]`, http.StatusOK, "1.1.0", "/v2"},
+		{"failed test", jsonPatchType, `[{"op":"test","path":"/version","value":"9.9.9"},{"op":"replace","path":"/version","value":"2.0.0"}]`, http.StatusConflict, "1.1.0", "/v2"},
+		{"revision", "application/merge-patch+json", `{"revision":100}`, http.StatusBadRequest, "1.1.0", "/v2"},
+		{"invalid patch", jsonPatchType, `{"op":"replace"}`, http.StatusBadRequest, "1.1.0", "/v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, serve(a, "PATCH", appPath, tt.body, "Content-Type", tt.contentType), tt.code)
+
+			var app data.App
+			decode(t, serve(a, "GET", appPath, ""), &app)
+			if app.Version != tt.version || app.Route != tt.route {
+				t.Fatalf("app = %+v, want version %s and route %s", app, tt.version, tt.route)
+			}
+		})
+	}
+}
+
+func TestLocks(t *testing.T) {
+	a, _ := newTestAPI(t, Options{AuthEnabled: true})
+	admin := bearer(adminSecret)
+
+	w := serve(a, "POST", "/api/v1/regions/amer/environments/dev/locks", `{"reason":"release"}`, admin...)
+	expect(t, w, http.StatusCreated)
+	var lock LockStatus
+	decode(t, w, &lock)
+	if !lock.Active || lock.Kind != data.LockKindManual {
+		t.Fatalf("created lock = %+v", lock)
+	}
+
+	expect(t, serve(a, "PUT", appPath, `{"version":"1.1.0"}`, bearer(devSecret)...), http.StatusLocked)
+	expect(t, serve(a, "PUT", appPath+"?override=true", `{"version":"1.1.0"}`, bearer(devSecret)...), http.StatusForbidden)
+	expect(t, serve(a, "PUT", appPath+"?override=true", `{"version":"1.1.0"}`, admin...), http.StatusOK)
+	// Other environments are not covered by the lock
+	expect(t, serve(a, "POST", "/api/v1/regions/amer/environments/prod/apps", `{"name":"api","version":"1.0.0"}`, admin...), http.StatusCreated)
+
+	// Lifting the lock is for admins
+	expect(t, serve(a, "DELETE", "/api/v1/locks/"+lock.ID, "", bearer(devSecret)...), http.StatusForbidden)
+	expect(t, serve(a, "DELETE", "/api/v1/locks/"+lock.ID, "", admin...), http.StatusOK)
+	expect(t, serve(a, "PUT", appPath, `{"version":"1.2.0"}`, bearer(devSecret)...), http.StatusOK)
+}
+
+func TestLockOverrideNeedsAuth(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+
+	expect(t, serve(a, "POST", "/api/v1/regions/amer/locks", `{}`), http.StatusCreated)
+	expect(t, serve(a, "PUT", appPath, `{"version":"1.1.0"}`), http.StatusLocked)
+	expect(t, serve(a, "PUT", appPath+"?override=true", `{"version":"1.1.0"}`), http.StatusForbidden)
+}
+
+func TestAuthorization(t *testing.T) {
+	a, _ := newTestAPI(t, Options{AuthEnabled: true})
+	prodApps := "/api/v1/regions/amer/environments/prod/apps"
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		headers []string
+		code    int
+	}{
+		{"no token", "GET", "/api/v1/regions", "", nil, http.StatusUnauthorized},
+		{"unknown token", "GET", "/api/v1/regions", "", bearer("vhub_nobody"), http.StatusUnauthorized},
+		{"read", "GET", appPath, "", bearer(readSecret), http.StatusOK},
+		{"read token writing", "PUT", appPath, `{"version":"1.1.0"}`, bearer(readSecret), http.StatusForbidden},
+		{"scoped write", "PUT", appPath, `{"version":"1.1.0"}`, bearer(devSecret), http.StatusOK},
+		{"scoped write elsewhere", "POST", prodApps, `{"name":"web","version":"1.0.0"}`, bearer(devSecret), http.StatusForbidden},
+		{"scoped write to the region", "PUT", "/api/v1/regions/amer/pipeline", `{"stages":["dev"]}`, bearer(devSecret), http.StatusForbidden},
+		{"policy write", "PUT", appPath, `{"version":"1.2.0"}`, bearer(policySecret), http.StatusOK},
+		{"policy write elsewhere", "POST", prodApps, `{"name":"web","version":"1.0.0"}`, bearer(policySecret), http.StatusForbidden},
+		{"tokens need admin", "GET", "/api/v1/tokens", "", bearer(devSecret), http.StatusForbidden},
+		{"webhooks need admin", "GET", "/api/v1/webhooks", "", bearer(policySecret), http.StatusForbidden},
+		{"admin", "GET", "/api/v1/tokens", "", bearer(adminSecret), http.StatusOK},
+		{"can-i for anyone", "GET", "/api/v1/auth/can-i?method=PUT&path=/regions/amer", "", bearer(readSecret), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, serve(a, tt.method, tt.target, tt.body, tt.headers...), tt.code)
+		})
+	}
+}
+
+func TestCanI(t *testing.T) {
+	a, _ := newTestAPI(t, Options{AuthEnabled: true})
+
+	tests := []struct {
+		secret, method, path string
+		allowed              bool
+	}{
+		{devSecret, "PUT", "/regions/amer/environments/dev/apps/api", true},
+		{devSecret, "PUT", "/regions/amer/environments/prod/apps/api", false},
+		{devSecret, "POST", "/regions/amer/environments/dev/apps/api/promote", false},
+		{policySecret, "DELETE", "/regions/amer/environments/dev", true},
+		{readSecret, "GET", "/regions/amer", true},
+		{readSecret, "POST", "/tokens", false},
+		{adminSecret, "POST", "/regions/amer/environments/dev/apps/api/promote", true},
+	}
+	for _, tt := range tests {
+		w := serve(a, "GET", "/api/v1/auth/can-i?method="+tt.method+"&path="+tt.path, "", bearer(tt.secret)...)
+		expect(t, w, http.StatusOK)
+		var got CanIResponse
+		decode(t, w, &got)
+		if got.Allowed != tt.allowed {
+			t.Errorf("can-i %s %s with %s = %v, want %v", tt.method, tt.path, tt.secret, got.Allowed, tt.allowed)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	a, _ := newTestAPI(t, Options{AuthEnabled: true})
+	admin := bearer(adminSecret)
+
+	w := serve(a, "POST", "/api/v1/tokens", `{"name":"deploy","scopes":["write:amer"]}`, admin...)
+	expect(t, w, http.StatusCreated)
+	var issued IssuedToken
+	decode(t, w, &issued)
+	if !strings.HasPrefix(issued.Secret, tokenPrefix) || issued.Hash != "" {
+		t.Fatalf("issued token = %+v", issued)
+	}
+
+	expect(t, serve(a, "POST", "/api/v1/tokens", `{"name":"deploy","scopes":["read"]}`, admin...), http.StatusConflict)
+	expect(t, serve(a, "POST", "/api/v1/tokens", `{"name":"bad","scopes":["root"]}`, admin...), http.StatusBadRequest)
+	expect(t, serve(a, "PUT", "/api/v1/regions/amer/environments/prod", `{"apps":{}}`, bearer(issued.Secret)...), http.StatusOK)
+
+	expect(t, serve(a, "DELETE", "/api/v1/tokens/"+issued.ID, "", admin...), http.StatusOK)
+	expect(t, serve(a, "GET", "/api/v1/regions", "", bearer(issued.Secret)...), http.StatusUnauthorized)
+}
+
+func TestRollback(t *testing.T) {
+	a, _ := newTestAPI(t, Options{})
+	rollback := appPath + "/rollback"
+
+	expect(t, serve(a, "PUT", appPath, `{"version":"1.1.0"}`), http.StatusOK)
+	expect(t, serve(a, "PUT", appPath, `{"name":"api","version":"1.2.0","route":"/v2"}`), http.StatusOK)
+
+	w := serve(a, "POST", rollback, "")
+	expect(t, w, http.StatusOK)
+	var app data.App
+	decode(t, w, &app)
+	if app.Version != "1.1.0" || app.Route != "/api" {
+		t.Fatalf("app after rollback = %+v, want 1.1.0 at /api", app)
+	}
+
+	// A second rollback walks back past the first
+	decode(t, serve(a, "POST", rollback, ""), &app)
+	if app.Version != "1.0.0" {
+		t.Fatalf("app after second rollback = %+v, want 1.0.0", app)
+	}
+	expect(t, serve(a, "POST", rollback, ""), http.StatusConflict)
+
+	decode(t, serve(a, "POST", rollback, `{"version":"1.2.0"}`), &app)
+	if app.Version != "1.2.0" || app.Route != "/v2" {
+		t.Fatalf("app after rollback to 1.2.0 = %+v", app)
+	}
+	expect(t, serve(a, "POST", rollback, `{"version":"3.0.0"}`), http.StatusConflict)
+
+	var history HistoryPage
+	decode(t, serve(a, "GET", appPath+"/history", ""), &history)
+	if len(history.Entries) != 5 || history.Entries[0].Action != data.HistoryActionRollback {
+		t.Fatalf("history = %+v", history)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	a, store := newTestAPI(t, Options{AuthEnabled: true})
+	promote := appPath + "/promote"
+
+	expect(t, serve(a, "POST", promote, "", bearer(devSecret)...), http.StatusForbidden)
+	expect(t, serve(a, "POST", promote, `{"to":"qa"}`, bearer(adminSecret)...), http.StatusConflict)
+
+	w := serve(a, "POST", promote, "", bearer(adminSecret)...)
+	expect(t, w, http.StatusOK)
+	promoted, err := store.GetApp("amer", "prod", "api")
+	if err != nil || promoted.Version != "1.0.0" || promoted.Route != "/api" {
+		t.Fatalf("promoted app = %+v, %v", promoted, err)
+	}
+
+	// Promoting the same version again changes nothing
+	rev, _ := store.Revision()
+	expect(t, serve(a, "POST", promote, "", bearer(adminSecret)...), http.StatusOK)
+	if after, _ := store.Revision(); after != rev {
+		t.Fatalf("revision after a no-op promotion = %d, want %d", after, rev)
+	}
+
+	expect(t, serve(a, "POST", "/api/v1/regions/amer/environments/prod/apps/api/promote", "", bearer(adminSecret)...), http.StatusConflict)
+
+	// A lock on the target stage stops the promotion
+	expect(t, serve(a, "POST", "/api/v1/regions/amer/environments/prod/locks", `{}`, bearer(adminSecret)...), http.StatusCreated)
+	expect(t, serve(a, "PUT", appPath, `{"version":"1.1.0"}`, bearer(devSecret)...), http.StatusOK)
+	expect(t, serve(a, "POST", promote, "", bearer(adminSecret)...), http.StatusLocked)
+}
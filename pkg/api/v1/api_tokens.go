@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"vhub/pkg/data"
+
+	"github.com/gorilla/mux"
+)
+
+// TokenRequest is the body of a request to issue a token.
+type TokenRequest struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// IssuedToken is the response to issuing a token. It is the only place the
+// secret is shown.
+type IssuedToken struct {
+	data.Token
+	Secret string `json:"secret"`
+}
+
+// ListTokens handles the GET request for listing all API tokens.
+func (a *API) ListTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := a.store.ListTokens()
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	for i := range tokens {
+		tokens[i].Hash = ""
+	}
+	RespondWithJSON(w, http.StatusOK, tokens)
+}
+
+// CreateToken handles the POST request to issue an API token.
+func (a *API) CreateToken(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	secret := newTokenSecret()
+	token := data.Token{
+		ID:        data.NewID(),
+		Name:      req.Name,
+		Hash:      data.HashToken(secret),
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := a.store.CreateToken(token); err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	token.Hash = ""
+	RespondWithJSON(w, http.StatusCreated, IssuedToken{Token: token, Secret: secret})
+}
+
+// GetToken handles the GET request to retrieve an API token.
+func (a *API) GetToken(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["token"]
+
+	token, err := a.store.GetToken(id)
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	token.Hash = ""
+	RespondWithJSON(w, http.StatusOK, token)
+}
+
+// DeleteToken handles the DELETE request to revoke an API token.
+func (a *API) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["token"]
+
+	err := a.update(r, func(tx data.Tx) error { return tx.DeleteToken(id) })
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, "Token revoked successfully")
+}
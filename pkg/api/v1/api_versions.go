@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"vhub/pkg/checker"
+	"vhub/pkg/data"
+)
+
+// regionMap indexes regions by name.
+func regionMap(regions []data.Region) map[string]data.Region {
+	m := make(map[string]data.Region, len(regions))
+	for _, region := range regions {
+		m[region.Name] = region
+	}
+	return m
+}
+
+// ListVersionDrift handles the GET request for the versions apps report
+// compared with the recorded ones. The region, environment and app query
+// parameters restrict the list, and drift=true keeps only the apps that
+// drifted.
+func (a *API) ListVersionDrift(w http.ResponseWriter, r *http.Request) {
+	var regions []data.Region
+	err := a.store.View(func(tx data.Tx) error {
+		var err error
+		regions, err = tx.ListRegions()
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	filter := parseMatrixFilter(r)
+	onlyDrift := queryBool(r, "drift")
+	result := make([]checker.VersionDrift, 0)
+	for _, d := range checker.GetVersionDrift(regionMap(regions)) {
+		if !matchesAny(filter.Regions, d.Region) || !matchesAny(filter.Environments, d.Environment) || !matchesAny(filter.Apps, d.App) {
+			continue
+		}
+		if onlyDrift && !d.Drift {
+			continue
+		}
+		result = append(result, d)
+	}
+	RespondWithJSON(w, http.StatusOK, result)
+}
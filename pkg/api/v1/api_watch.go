@@ -0,0 +1,270 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vhub/pkg/data"
+)
+
+// DefaultWatchBufferSize is the number of events kept for clients resuming a
+// watch with Last-Event-ID unless Options set another size.
+const DefaultWatchBufferSize = 1000
+
+const (
+	// watchQueueSize is the number of events buffered per watcher. A watcher
+	// that falls further behind is disconnected and has to resume.
+	watchQueueSize   = 256
+	watchKeepAlive   = 15 * time.Second
+	watchResetEvent  = "reset"
+	watchLastEventID = "Last-Event-ID"
+)
+
+// WatchEvent is one event of the watch stream. Its ID is the epoch of the
+// broker and the sequence number of the event, as <epoch>-<seq>.
+type WatchEvent struct {
+	ID  string `json:"id"`
+	seq uint64
+	data.Change
+}
+
+// broker fans events out to watchers and keeps the most recent ones in a
+// ring buffer. Sequence numbers restart with the process, so event IDs carry
+// the time the broker was created as an epoch: an ID from an earlier run
+// never matches a later event.
+type broker struct {
+	mu       sync.Mutex
+	epoch    string
+	seq      uint64
+	ring     []WatchEvent
+	next     int
+	watchers map[chan WatchEvent]struct{}
+	closed   bool
+}
+
+func newBroker(size int) *broker {
+	if size < 1 {
+		size = 1
+	}
+	return &broker{
+		epoch:    strconv.FormatInt(time.Now().UnixNano(), 36),
+		ring:     make([]WatchEvent, 0, size),
+		watchers: make(map[chan WatchEvent]struct{}),
+	}
+}
+
+// publish is registered with the store as a data.ChangeFunc.
+func (b *broker) publish(changes []data.Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, change := range changes {
+		b.seq++
+		event := WatchEvent{ID: b.epoch + "-" + strconv.FormatUint(b.seq, 10), seq: b.seq, Change: change}
+		if len(b.ring) < cap(b.ring) {
+			b.ring = append(b.ring, event)
+		} else {
+			b.ring[b.next] = event
+			b.next = (b.next + 1) % len(b.ring)
+		}
+
+		for ch := range b.watchers {
+			select {
+			case ch <- event:
+			default:
+				// Too slow; the client resumes from the ring buffer
+				delete(b.watchers, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// parseID returns the sequence number of the event with the given ID. ok is
+// false for IDs of another epoch, or of an older form, which cannot be
+// resumed from.
+func (b *broker) parseID(id string) (seq uint64, ok bool, err error) {
+	epoch, n, found := strings.Cut(id, "-")
+	if !found || epoch != b.epoch {
+		return 0, false, nil
+	}
+	seq, err = strconv.ParseUint(n, 10, 64)
+	return seq, err == nil, err
+}
+
+// subscribe registers a watcher. If resume is set, the buffered events after
+// lastID are returned as backlog; complete is false if some of them have
+// already been dropped from the buffer.
+func (b *broker) subscribe(lastID uint64, resume bool) (ch chan WatchEvent, backlog []WatchEvent, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch = make(chan WatchEvent, watchQueueSize)
+	if b.closed {
+		close(ch)
+		return ch, nil, true
+	}
+	b.watchers[ch] = struct{}{}
+
+	if !resume {
+		return ch, nil, true
+	}
+	if lastID > b.seq {
+		return ch, nil, false
+	}
+
+	complete = lastID == b.seq
+	for i := 0; i < len(b.ring); i++ {
+		event := b.ring[(b.next+i)%len(b.ring)]
+		if event.seq == lastID+1 {
+			complete = true
+		}
+		if event.seq > lastID {
+			backlog = append(backlog, event)
+		}
+	}
+	return ch, backlog, complete
+}
+
+func (b *broker) unsubscribe(ch chan WatchEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.watchers[ch]; ok {
+		delete(b.watchers, ch)
+		close(ch)
+	}
+}
+
+// close disconnects all watchers and refuses new ones.
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.watchers {
+		delete(b.watchers, ch)
+		close(ch)
+	}
+}
+
+// watchFilter selects events by region, environment and app. Events about a
+// region or environment as a whole match filters on anything inside it.
+type watchFilter struct {
+	regions, environments, apps []string
+}
+
+func (f watchFilter) matches(event WatchEvent) bool {
+	return (event.Region == "" || matchesAny(f.regions, event.Region)) &&
+		(event.Environment == "" || matchesAny(f.environments, event.Environment)) &&
+		(event.App == "" || matchesAny(f.apps, event.App))
+}
+
+func matchesAny(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func writeEvent(w http.ResponseWriter, event WatchEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Action, payload)
+	return err
+}
+
+// Watch handles the GET request for a Server-Sent Events stream of created,
+// updated and deleted regions, environments and apps. The region, environment
+// and app query parameters restrict the stream. A client reconnecting with a
+// Last-Event-ID header (or lastEventId parameter) first receives the events it
+// missed; if they are no longer buffered a reset event tells it to reload.
+func (a *API) Watch(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		RespondWithError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	lastEventID := r.Header.Get(watchLastEventID)
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	resume, known := lastEventID != "", false
+	var lastID uint64
+	if resume {
+		var err error
+		if lastID, known, err = a.events.parseID(lastEventID); err != nil {
+			RespondWithError(w, http.StatusBadRequest, errBadParam(watchLastEventID).Error())
+			return
+		}
+	}
+
+	filter := watchFilter{
+		regions:      queryList(r, "region"),
+		environments: queryList(r, "environment"),
+		apps:         queryList(r, "app"),
+	}
+
+	// An ID from an earlier run cannot be resumed from: the client reloads
+	ch, backlog, complete := a.events.subscribe(lastID, known)
+	if resume && !known {
+		complete = false
+	}
+	defer a.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !complete {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", watchResetEvent)
+	}
+	for _, event := range backlog {
+		if filter.matches(event) {
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !filter.matches(event) {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"strconv"
+	"testing"
+	"vhub/pkg/data"
+)
+
+// publishApps publishes n changes, one at a time, to apps a1 to an.
+func publishApps(b *broker, n int) {
+	for i := 1; i <= n; i++ {
+		b.publish([]data.Change{{Action: data.ChangeUpdated, Kind: data.KindApp, Region: "amer", Environment: "dev", App: "a" + strconv.Itoa(i)}})
+	}
+}
+
+func seqs(events []WatchEvent) []uint64 {
+	var list []uint64
+	for _, event := range events {
+		list = append(list, event.seq)
+	}
+	return list
+}
+
+func TestBrokerResume(t *testing.T) {
+	// With room for three, events 3 to 5 are still buffered
+	b := newBroker(3)
+	publishApps(b, 5)
+
+	tests := []struct {
+		name     string
+		lastID   uint64
+		backlog  []uint64
+		complete bool
+	}{
+		{"up to date", 5, nil, true},
+		{"behind", 3, []uint64{4, 5}, true},
+		{"at the oldest buffered", 2, []uint64{3, 4, 5}, true},
+		{"missed a dropped event", 1, []uint64{3, 4, 5}, false},
+		{"from the start", 0, []uint64{3, 4, 5}, false},
+		{"ahead of the broker", 6, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, backlog, complete := b.subscribe(tt.lastID, true)
+			defer b.unsubscribe(ch)
+			if got := seqs(backlog); !equalSeqs(got, tt.backlog) || complete != tt.complete {
+				t.Fatalf("subscribe(%d) = %v, %v, want %v, %v", tt.lastID, got, complete, tt.backlog, tt.complete)
+			}
+		})
+	}
+}
+
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBrokerRingKeepsOrderAcrossWraps(t *testing.T) {
+	b := newBroker(4)
+	publishApps(b, 11)
+
+	_, backlog, complete := b.subscribe(6, true)
+	if got := seqs(backlog); !equalSeqs(got, []uint64{8, 9, 10, 11}) || complete {
+		t.Fatalf("backlog = %v, complete %v, want 8 to 11, incomplete", got, complete)
+	}
+	if backlog[3].ID != b.epoch+"-11" || backlog[3].App != "a11" {
+		t.Fatalf("last event = %+v", backlog[3])
+	}
+}
+
+func TestBrokerParseID(t *testing.T) {
+	b := newBroker(1)
+
+	tests := []struct {
+		id      string
+		seq     uint64
+		ok      bool
+		invalid bool
+	}{
+		{b.epoch + "-42", 42, true, false},
+		{"0" + b.epoch + "-42", 0, false, false},
+		{"42", 0, false, false},
+		{b.epoch + "-x", 0, false, true},
+	}
+	for _, tt := range tests {
+		seq, ok, err := b.parseID(tt.id)
+		if seq != tt.seq || ok != tt.ok || (err != nil) != tt.invalid {
+			t.Errorf("parseID(%q) = %d, %v, %v", tt.id, seq, ok, err)
+		}
+	}
+}
+
+func TestBrokerDropsSlowWatcher(t *testing.T) {
+	b := newBroker(DefaultWatchBufferSize)
+	slow, _, _ := b.subscribe(0, false)
+	fast, _, _ := b.subscribe(0, false)
+
+	received := 0
+	for i := 0; i <= watchQueueSize; i++ {
+		publishApps(b, 1)
+		<-fast
+		received++
+	}
+
+	queued := 0
+	for range slow {
+		queued++
+	}
+	if queued != watchQueueSize {
+		t.Fatalf("slow watcher got %d events before being dropped, want %d", queued, watchQueueSize)
+	}
+	if _, ok := b.watchers[fast]; !ok || received != watchQueueSize+1 {
+		t.Fatalf("fast watcher was dropped after %d events", received)
+	}
+	// Unsubscribing a dropped watcher must not close its channel again
+	b.unsubscribe(slow)
+	b.unsubscribe(fast)
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := newBroker(DefaultWatchBufferSize)
+	ch, _, _ := b.subscribe(0, false)
+
+	b.close()
+	if _, open := <-ch; open {
+		t.Fatal("watcher channel open after close")
+	}
+	b.unsubscribe(ch)
+
+	late, backlog, _ := b.subscribe(0, true)
+	if _, open := <-late; open || backlog != nil {
+		t.Fatal("subscribe after close returned an open channel")
+	}
+	publishApps(b, 1)
+}
+
+func TestWatchFilter(t *testing.T) {
+	filter := watchFilter{regions: []string{"amer"}, apps: []string{"api"}}
+
+	tests := []struct {
+		change data.Change
+		want   bool
+	}{
+		{data.Change{Kind: data.KindApp, Region: "amer", Environment: "dev", App: "api"}, true},
+		{data.Change{Kind: data.KindApp, Region: "amer", Environment: "dev", App: "web"}, false},
+		{data.Change{Kind: data.KindApp, Region: "emea", Environment: "dev", App: "api"}, false},
+		{data.Change{Kind: data.KindEnvironment, Region: "amer", Environment: "dev"}, true},
+		{data.Change{Kind: data.KindRegion, Region: "emea"}, false},
+	}
+	for _, tt := range tests {
+		if got := filter.matches(WatchEvent{Change: tt.change}); got != tt.want {
+			t.Errorf("matches(%+v) = %v, want %v", tt.change, got, tt.want)
+		}
+	}
+}
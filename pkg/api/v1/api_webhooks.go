@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"vhub/pkg/data"
+
+	"github.com/gorilla/mux"
+)
+
+// redactSecret hides the signing secret of a webhook, which is only shown
+// when the webhook is created.
+func redactSecret(hook data.Webhook) data.Webhook {
+	hook.Secret = ""
+	return hook
+}
+
+// ListWebhooks handles the GET request for listing all webhooks.
+func (a *API) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := a.store.ListWebhooks()
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	for i := range hooks {
+		hooks[i] = redactSecret(hooks[i])
+	}
+	RespondWithJSON(w, http.StatusOK, hooks)
+}
+
+// CreateWebhook handles the POST request to register a webhook. A secret is
+// generated if none is given; the response is the only place it is shown.
+func (a *API) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var hook data.Webhook
+
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	hook.ID = data.NewID()
+	hook.CreatedAt = time.Now().UTC()
+	if hook.Secret == "" {
+		hook.Secret = data.NewID()
+	}
+
+	if err := a.store.CreateWebhook(hook); err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusCreated, hook)
+}
+
+// GetWebhook handles the GET request to retrieve a webhook.
+func (a *API) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["webhook"]
+
+	hook, err := a.store.GetWebhook(id)
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, redactSecret(hook))
+}
+
+// UpdateWebhook handles the PUT request to replace a webhook. The secret is
+// kept unless a new one is given.
+func (a *API) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["webhook"]
+
+	var hook data.Webhook
+
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err := a.update(r, func(tx data.Tx) error {
+		current, err := tx.GetWebhook(id)
+		if err != nil {
+			return err
+		}
+		hook.ID = id
+		hook.CreatedAt = current.CreatedAt
+		if hook.Secret == "" {
+			hook.Secret = current.Secret
+		}
+		return tx.UpdateWebhook(id, hook)
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, redactSecret(hook))
+}
+
+// DeleteWebhook handles the DELETE request to remove a webhook.
+func (a *API) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["webhook"]
+
+	err := a.update(r, func(tx data.Tx) error { return tx.DeleteWebhook(id) })
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+	a.webhooks.Forget(id)
+
+	RespondWithJSON(w, http.StatusOK, "Webhook deleted successfully")
+}
+
+// ListWebhookDeliveries handles the GET request for the recent delivery
+// attempts of a webhook, newest first.
+func (a *API) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["webhook"]
+
+	if _, err := a.store.GetWebhook(id); err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, a.webhooks.Deliveries(id))
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"vhub/pkg/certs"
+	"vhub/pkg/data"
+	"vhub/pkg/oidc"
+	"vhub/pkg/rbac"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	bootstrapTokenName = "bootstrap-admin"
+	bootstrapTokenEnv  = "VHUB_BOOTSTRAP_TOKEN"
+	tokenPrefix        = "vhub_"
+)
+
+var (
+	errMissingToken = errors.New("Missing bearer token")
+	errUnknownToken = errors.New("Invalid or expired bearer token")
+	errUnknownCert  = errors.New("Client certificate names no subject")
+)
+
+// principal is whoever a request was authenticated as: an API token, the
+// subject of a JWT or the holder of a client certificate, and their groups.
+// Name is prefixed by where it comes from (token:, oidc: or cert:), so that
+// a name taken in one source cannot be claimed through another.
+type principal struct {
+	Name   string
+	Scopes []string
+	Groups []string
+
+	policy *rbac.Policy
+}
+
+type principalKey struct{}
+
+// subjects returns the names role bindings may refer to the principal by: its
+// name and group:<name> for each of its groups.
+func (p *principal) subjects() []string {
+	subjects := []string{p.Name}
+	for _, group := range p.Groups {
+		subjects = append(subjects, rbac.GroupSubject+group)
+	}
+	return subjects
+}
+
+// allows reports whether the principal's scopes or roles grant perm. An empty
+// action is granted to anyone authenticated.
+func (p *principal) allows(perm data.Permission) bool {
+	return perm.Action == "" || data.ScopesAllow(p.Scopes, perm) || p.policy.Allows(p.subjects(), perm)
+}
+
+// requestPrincipal returns the principal a request was authenticated as, or
+// nil if authentication is disabled.
+func requestPrincipal(r *http.Request) *principal {
+	p, _ := r.Context().Value(principalKey{}).(*principal)
+	return p
+}
+
+// forbiddenError is returned from a store transaction when the caller may not
+// make a change that only becomes known inside it, such as the target of a
+// promotion.
+type forbiddenError struct {
+	message string
+}
+
+func (e forbiddenError) Error() string {
+	return e.message
+}
+
+// authorize checks that the caller of r holds p.
+func authorize(r *http.Request, p data.Permission) error {
+	caller := requestPrincipal(r)
+	if caller == nil || caller.allows(p) {
+		return nil
+	}
+	return forbiddenError{describeDenial(caller, p)}
+}
+
+func describeDenial(caller *principal, p data.Permission) string {
+	target := ""
+	switch {
+	case p.Environment != "":
+		target = fmt.Sprintf(" on %s/%s", p.Region, p.Environment)
+	case p.Region != "":
+		target = " on " + p.Region
+	}
+	return fmt.Sprintf("%s lacks %s permission%s", caller.Name, p.Action, target)
+}
+
+// bearerToken returns the token of the Authorization header. GET requests may
+// pass it as the access_token parameter instead, since browsers cannot set
+// headers on an EventSource.
+func bearerToken(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	if r.Method == http.MethodGet {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// routePermission returns what the route matched by r requires.
+func routePermission(r *http.Request) data.Permission {
+	template := ""
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ = route.GetPathTemplate()
+	}
+	return permissionFor(r.Method, template, mux.Vars(r))
+}
+
+// permissionFor returns what a request to a route requires. The token, webhook
+// and audit APIs are for admins and the auth API for anyone; other routes need
+// read to look and write on the region or environment they change.
+func permissionFor(method, template string, vars map[string]string) data.Permission {
+	switch {
+	case strings.HasPrefix(template, "/api/v1/auth/"):
+		return data.Permission{}
+	case strings.HasPrefix(template, "/api/v1/tokens"), strings.HasPrefix(template, "/api/v1/webhooks"), strings.HasPrefix(template, "/api/v1/audit"):
+		return data.Permission{Action: data.ActionAdmin}
+	case method == http.MethodGet || method == http.MethodHead:
+		return data.Permission{Action: data.ActionRead}
+	case strings.HasSuffix(template, "/promote"):
+		// The target stage is only known to PromoteApp, which checks it
+		return data.Permission{Action: data.ActionRead}
+	case strings.HasPrefix(template, "/api/v1/locks/"):
+		// Lifting a lock, unlike overriding it for one change, is for admins
+		return data.Permission{Action: data.ActionAdmin}
+	}
+	return data.Permission{Action: data.ActionWrite, Region: vars["region"], Environment: vars["environment"]}
+}
+
+// authenticate is the middleware of the /api/v1 a.routes. It answers 401 to
+// requests without a valid token or client certificate and 403 to those the
+// caller is not allowed to make.
+func (a *API) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := bearerToken(r)
+		if secret == "" && !hasClientCert(r) {
+			unauthorized(w, errMissingToken)
+			return
+		}
+
+		caller, err := a.identify(r, secret)
+		if errors.Is(err, errUnknownToken) || errors.Is(err, errUnknownCert) || errors.Is(err, oidc.ErrInvalidToken) {
+			data.Log.WithField("remote", r.RemoteAddr).WithField("error", err).Warn("Authentication failed")
+			unauthorized(w, err)
+			return
+		}
+		if err != nil {
+			RespondWithStoreError(w, err)
+			return
+		}
+
+		log := data.Log.WithField("subject", caller.Name).WithField("method", r.Method).WithField("path", r.URL.Path)
+		if p := routePermission(r); !caller.allows(p) {
+			log.Warn("Request denied")
+			RespondWithError(w, http.StatusForbidden, describeDenial(caller, p))
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			log.Info("Request")
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, caller)))
+	})
+}
+
+// hasClientCert reports whether r came with a client certificate verified
+// against the client CA.
+func hasClientCert(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// identify returns whom a request was made by. A bearer token takes precedence
+// over the client certificate. Tokens shaped like a JWT are verified with
+// the OIDC verifier when there is one; anything else must be an API token.
+func (a *API) identify(r *http.Request, secret string) (*principal, error) {
+	if secret == "" {
+		name, groups := certs.Identity(r.TLS.VerifiedChains[0][0])
+		if name == "" {
+			return nil, errUnknownCert
+		}
+		return &principal{Name: rbac.CertSubject + name, Groups: groups, policy: a.policy}, nil
+	}
+	if a.identities != nil && strings.Count(secret, ".") == 2 {
+		identity, err := a.identities.Verify(secret)
+		if err != nil {
+			return nil, err
+		}
+		return &principal{Name: rbac.OIDCSubject + identity.Subject, Groups: identity.Groups, policy: a.policy}, nil
+	}
+
+	token, err := a.store.FindToken(data.HashToken(secret))
+	if errors.Is(err, data.ErrTokenNotFound) {
+		return nil, errUnknownToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.Expired(time.Now()) {
+		return nil, errUnknownToken
+	}
+	return &principal{Name: rbac.TokenSubject + token.Name, Scopes: token.Scopes, policy: a.policy}, nil
+}
+
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="vhub"`)
+	RespondWithError(w, http.StatusUnauthorized, err.Error())
+}
+
+// newTokenSecret returns a random token secret.
+func newTokenSecret() string {
+	return tokenPrefix + data.NewID() + data.NewID()
+}
+
+// BootstrapToken creates an admin token if the store has none, so that the
+// first tokens can be issued. Its secret is taken from VHUB_BOOTSTRAP_TOKEN
+// or generated; a generated secret is returned so that it can be shown once.
+func BootstrapToken(s data.Store) (generated string, err error) {
+	err = s.Update(func(tx data.Tx) error {
+		tokens, err := tx.ListTokens()
+		if err != nil || len(tokens) > 0 {
+			return err
+		}
+
+		secret := os.Getenv(bootstrapTokenEnv)
+		if secret == "" {
+			secret = newTokenSecret()
+			generated = secret
+		}
+		return tx.CreateToken(data.Token{
+			ID:        data.NewID(),
+			Name:      bootstrapTokenName,
+			Hash:      data.HashToken(secret),
+			Scopes:    []string{data.ActionAdmin},
+			CreatedAt: time.Now().UTC(),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return generated, nil
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"time"
 	"vhub/pkg/data"
 
 	"vhub/pkg/checker"
@@ -9,18 +10,57 @@ import (
 	"vhub/pkg/ui"
 )
 
-func HealthCheck(w http.ResponseWriter, r *http.Request) {
+// timelineLength is the number of results of each health check shown in
+// the UI.
+const timelineLength = 30
+
+func (a *API) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	RespondWithJSON(w, http.StatusOK, map[string]string{"status": "OK"})
 }
 
-func ServeHTML(w http.ResponseWriter, r *http.Request) {
-	// Get region data and health data
-	data.Mutex.RLock()
-	defer data.Mutex.RUnlock()
+func (a *API) ServeHTML(w http.ResponseWriter, r *http.Request) {
+	// Get region data and the locks in force, as of one revision
+	var regions []data.Region
+	var allLocks []data.Lock
+	err := a.store.View(func(tx data.Tx) error {
+		var err error
+		if regions, err = tx.ListRegions(); err != nil {
+			return err
+		}
+		allLocks, err = tx.ListLocks()
+		return err
+	})
+	if err != nil {
+		RespondWithStoreError(w, err)
+		return
+	}
+
+	matrix := data.BuildMatrix(regions, parseMatrixFilter(r))
+	if r.URL.Query().Get("sort") == "version" {
+		matrix.SortByVersion()
+	}
+
+	healthData := checker.GetHealthHistory(timelineLength)
+
+	locks := make(map[string][]data.Lock)
+	now := time.Now()
+	for _, lock := range allLocks {
+		if !lock.Active(now) {
+			continue
+		}
+		key := lock.Region
+		if lock.Environment != "" {
+			key += "/" + lock.Environment
+		}
+		locks[key] = append(locks[key], lock)
+	}
 
-	regionData := data.GlobalData.Regions
-	healthData := checker.GetHealthStatus()
+	byName := regionMap(regions)
+	versions := make(map[string]checker.VersionDrift)
+	for _, d := range checker.GetVersionDrift(byName) {
+		versions[data.HistoryKey(d.Region, d.Environment, d.App)] = d
+	}
 
 	// Render the template
-	ui.RenderTemplate(w, regionData, healthData)
+	ui.RenderTemplate(w, byName, matrix, healthData, locks, versions)
 }
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"vhub/pkg/data"
+)
+
+// errPreconditionFailed is returned from a store transaction when the
+// If-Match header of a request does not match the current revision
+var errPreconditionFailed = errors.New("precondition failed")
+
+// ETag formats a store revision as a strong entity tag
+func ETag(rev uint64) string {
+	return `"` + strconv.FormatUint(rev, 10) + `"`
+}
+
+// environmentETag is the entity tag of an environment as returned by
+// GetEnvironment, which also shows the locks in force on it. Locks coming
+// into or out of force do not change the revision, so their IDs are hashed
+// into the tag.
+func environmentETag(rev uint64, active []data.Lock) string {
+	if len(active) == 0 {
+		return ETag(rev)
+	}
+	ids := make([]string, 0, len(active))
+	for _, lock := range active {
+		ids = append(ids, lock.ID)
+	}
+	sort.Strings(ids)
+	sum := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	return `"` + strconv.FormatUint(rev, 10) + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagListed reports whether etag is one of the entity tags in an If-Match or
+// If-None-Match header. Weak tags only match when weak is set.
+func etagListed(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch returns errPreconditionFailed if the request carries an
+// If-Match header that does not match rev
+func checkIfMatch(r *http.Request, rev uint64) error {
+	header := r.Header.Get("If-Match")
+	if header == "" || etagListed(header, ETag(rev), false) {
+		return nil
+	}
+	return errPreconditionFailed
+}
+
+// checkEnvironmentIfMatch is checkIfMatch for an environment, which also
+// accepts the ETag returned by GetEnvironment while it is locked
+func checkEnvironmentIfMatch(tx data.Tx, r *http.Request, region, environment string, rev uint64) error {
+	if err := checkIfMatch(r, rev); err == nil {
+		return nil
+	}
+	locks, err := tx.ListLocks()
+	if err != nil {
+		return err
+	}
+	active := activeLocks(locks, region, environment, time.Now())
+	if len(active) > 0 && etagListed(r.Header.Get("If-Match"), environmentETag(rev, active), false) {
+		return nil
+	}
+	return errPreconditionFailed
+}
+
+// notModified sets the ETag header for rev and, if the If-None-Match header
+// of the request matches it, responds with 304 Not Modified and returns true
+func notModified(w http.ResponseWriter, r *http.Request, rev uint64) bool {
+	return notModifiedETag(w, r, ETag(rev))
+}
+
+// notModifiedETag is notModified for an entity tag other than a revision
+func notModifiedETag(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagListed(header, etag, true) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
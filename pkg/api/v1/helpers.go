@@ -2,7 +2,11 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"vhub/pkg/data"
 
 	"github.com/gorilla/mux"
 )
@@ -21,6 +25,107 @@ func RespondWithError(w http.ResponseWriter, code int, message string) {
 	RespondWithJSON(w, code, map[string]string{"error": message})
 }
 
+// conflictError is returned from a store transaction when a request cannot be
+// applied to the current state of the data
+type conflictError struct {
+	message string
+}
+
+func (e conflictError) Error() string {
+	return e.message
+}
+
+// badRequestError is returned from a store transaction when a request turns
+// out to be invalid once applied to the current data
+type badRequestError struct {
+	message string
+}
+
+func (e badRequestError) Error() string {
+	return e.message
+}
+
+// RespondWithStoreError sends an error response for an error returned by the store
+func RespondWithStoreError(w http.ResponseWriter, err error) {
+	var conflict conflictError
+	var badRequest badRequestError
+	var forbidden forbiddenError
+	var locked lockedError
+	switch {
+	case errors.As(err, &forbidden):
+		RespondWithError(w, http.StatusForbidden, forbidden.message)
+	case errors.As(err, &locked):
+		RespondWithJSON(w, http.StatusLocked, map[string]interface{}{
+			"error": locked.Error() + "; admins may pass override=true",
+			"lock":  locked.lock,
+		})
+	case errors.As(err, &conflict):
+		RespondWithError(w, http.StatusConflict, conflict.message)
+	case errors.As(err, &badRequest):
+		RespondWithError(w, http.StatusBadRequest, badRequest.message)
+	case errors.Is(err, errPreconditionFailed):
+		RespondWithError(w, http.StatusPreconditionFailed, "Resource has changed since it was fetched")
+	case errors.Is(err, data.ErrRegionNotFound):
+		RespondWithError(w, http.StatusNotFound, "Region not found")
+	case errors.Is(err, data.ErrEnvironmentNotFound):
+		RespondWithError(w, http.StatusNotFound, "Environment not found")
+	case errors.Is(err, data.ErrAppNotFound):
+		RespondWithError(w, http.StatusNotFound, "App not found")
+	case errors.Is(err, data.ErrRegionExists):
+		RespondWithError(w, http.StatusConflict, "Region already exists")
+	case errors.Is(err, data.ErrEnvironmentExists):
+		RespondWithError(w, http.StatusConflict, "Environment already exists in this region")
+	case errors.Is(err, data.ErrAppExists):
+		RespondWithError(w, http.StatusConflict, "App already exists in this environment")
+	case errors.Is(err, data.ErrWebhookNotFound):
+		RespondWithError(w, http.StatusNotFound, "Webhook not found")
+	case errors.Is(err, data.ErrWebhookExists):
+		RespondWithError(w, http.StatusConflict, "Webhook already exists")
+	case errors.Is(err, data.ErrTokenNotFound):
+		RespondWithError(w, http.StatusNotFound, "Token not found")
+	case errors.Is(err, data.ErrTokenExists):
+		RespondWithError(w, http.StatusConflict, "Token already exists")
+	case errors.Is(err, data.ErrTokenNameUsed):
+		RespondWithError(w, http.StatusConflict, "Token name already in use")
+	case errors.Is(err, data.ErrLockNotFound):
+		RespondWithError(w, http.StatusNotFound, "Lock not found")
+	case errors.Is(err, data.ErrLockExists):
+		RespondWithError(w, http.StatusConflict, "Lock already exists")
+	case errors.Is(err, data.ErrLockRemoved):
+		RespondWithError(w, http.StatusConflict, "Lock already removed")
+	case errors.Is(err, data.ErrInvalidPipeline), errors.Is(err, data.ErrInvalidVersion), errors.Is(err, data.ErrInvalidWebhook), errors.Is(err, data.ErrInvalidToken), errors.Is(err, data.ErrInvalidLock):
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, data.ErrVersionDowngrade):
+		RespondWithError(w, http.StatusConflict, err.Error()+"; pass force=true to downgrade")
+	default:
+		data.Log.WithField("error", err).Error("Data store operation failed")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to save data")
+	}
+}
+
+// RequestActor returns the name of whoever made the request: the name of its
+// token, or the X-Actor header when authentication is disabled
+func RequestActor(r *http.Request) string {
+	if caller := requestPrincipal(r); caller != nil {
+		return caller.Name
+	}
+	if actor := r.Header.Get("X-Actor"); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// queryBool reports whether a boolean query parameter is set to true
+func queryBool(r *http.Request, key string) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get(key))
+	return v
+}
+
+// errBadParam is returned when a query parameter cannot be parsed
+func errBadParam(name string) error {
+	return fmt.Errorf("Invalid %s parameter", name)
+}
+
 // ParseJSONRequest parses JSON from the request body and decodes it into the given struct
 func ParseJSONRequest(r *http.Request, v interface{}) error {
 	decoder := json.NewDecoder(r.Body)
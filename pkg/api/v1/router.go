@@ -4,24 +4,96 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"vhub/pkg/data" // Update with the actual import path to the data package
+	"vhub/pkg/audit"
+	"vhub/pkg/data"
+	"vhub/pkg/oidc"
+	"vhub/pkg/rbac"
+	"vhub/pkg/webhook"
 
 	"github.com/gorilla/mux"
 )
 
-func NewRouter() (*mux.Router, error) {
-	// Load data
-	if err := data.LoadData(); err != nil {
-		return nil, err
+// API serves the version hub from a data store. Its handlers are methods, so
+// that they share the store and the state built around it instead of package
+// variables.
+type API struct {
+	store      data.Store
+	events     *broker
+	webhooks   *webhook.Dispatcher
+	audit      *audit.Log
+	policy     *rbac.Policy
+	identities *oidc.Verifier
+	router     *mux.Router
+}
+
+// Options configure an API.
+type Options struct {
+	// AuthEnabled makes every /api/v1 request present a bearer token.
+	AuthEnabled bool
+	// Policy binds roles to callers on top of the scopes of their tokens.
+	Policy *rbac.Policy
+	// Identities verifies the JWTs of an OpenID Connect identity provider.
+	// Unless it is set, only API tokens are accepted.
+	Identities *oidc.Verifier
+	// Audit records every mutating request. The audit middleware and route
+	// are only installed if it is set.
+	Audit *audit.Log
+	// WatchBufferSize is the number of events kept for clients resuming a
+	// watch with Last-Event-ID. Zero means DefaultWatchBufferSize.
+	WatchBufferSize int
+}
+
+// New returns an API serving s, with its routes set up and its webhook
+// deliveries started.
+func New(s data.Store, opts Options) (*API, error) {
+	if s == nil {
+		return nil, fmt.Errorf("no data store configured")
+	}
+	if opts.WatchBufferSize == 0 {
+		opts.WatchBufferSize = DefaultWatchBufferSize
 	}
+	a := &API{
+		store:      s,
+		events:     newBroker(opts.WatchBufferSize),
+		webhooks:   webhook.NewDispatcher(s),
+		audit:      opts.Audit,
+		policy:     opts.Policy,
+		identities: opts.Identities,
+	}
+	s.OnChange(a.events.publish)
+	a.webhooks.Start()
+	a.router = a.newRouter(opts.AuthEnabled)
+	return a, nil
+}
+
+// Router returns the router of the API's routes.
+func (a *API) Router() *mux.Router {
+	return a.router
+}
 
+// Stop ends all watch streams and abandons pending webhook deliveries, so
+// that the server can shut down.
+func (a *API) Stop() {
+	a.events.close()
+	a.webhooks.Stop()
+}
+
+func (a *API) newRouter(authEnabled bool) *mux.Router {
 	router := mux.NewRouter()
 
 	// Handle the root path separately
-	router.HandleFunc("/", ServeHTML).Methods("GET")
-	router.HandleFunc("/healthcheck", HealthCheck).Methods("GET")
+	router.HandleFunc("/", a.ServeHTML).Methods("GET")
+	router.HandleFunc("/healthcheck", a.HealthCheck).Methods("GET")
+	router.HandleFunc("/metrics", a.Metrics).Methods("GET")
 
 	apiRouter := router.PathPrefix("/api/v1").Subrouter()
+	if authEnabled {
+		apiRouter.Use(a.authenticate)
+	}
+	if a.audit != nil {
+		apiRouter.Use(a.auditRequests)
+		apiRouter.HandleFunc("/audit", a.ListAudit).Methods("GET")
+	}
 
 	ListRoutes := func(w http.ResponseWriter, r *http.Request) {
 		router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
@@ -37,26 +109,65 @@ func NewRouter() (*mux.Router, error) {
 
 	apiRouter.HandleFunc("/", ListRoutes).Methods("GET")
 
+	apiRouter.HandleFunc("/matrix", a.GetMatrix).Methods("GET")
+	apiRouter.HandleFunc("/diff", a.GetDiff).Methods("GET")
+	apiRouter.HandleFunc("/watch", a.Watch).Methods("GET")
+	apiRouter.HandleFunc("/auth/can-i", a.CanI).Methods("GET")
+	apiRouter.HandleFunc("/health/checks", a.ListHealthChecks).Methods("GET")
+	apiRouter.HandleFunc("/health/checks/{check}/history", a.GetHealthCheckHistory).Methods("GET")
+	apiRouter.HandleFunc("/health/versions", a.ListVersionDrift).Methods("GET")
+
 	// Regions
-	apiRouter.HandleFunc("/regions", ListRegions).Methods("GET")
-	apiRouter.HandleFunc("/regions", CreateRegion).Methods("POST")
-	apiRouter.HandleFunc("/regions/{region}", GetRegion).Methods("GET")
-	apiRouter.HandleFunc("/regions/{region}", UpdateRegion).Methods("PUT")
-	apiRouter.HandleFunc("/regions/{region}", DeleteRegion).Methods("DELETE")
+	apiRouter.HandleFunc("/regions", a.ListRegions).Methods("GET")
+	apiRouter.HandleFunc("/regions", a.CreateRegion).Methods("POST")
+	apiRouter.HandleFunc("/regions/{region}", a.GetRegion).Methods("GET")
+	apiRouter.HandleFunc("/regions/{region}", a.UpdateRegion).Methods("PUT")
+	apiRouter.HandleFunc("/regions/{region}", a.PatchRegion).Methods("PATCH")
+	apiRouter.HandleFunc("/regions/{region}", a.DeleteRegion).Methods("DELETE")
+	apiRouter.HandleFunc("/regions/{region}/pipeline", a.GetPipeline).Methods("GET")
+	apiRouter.HandleFunc("/regions/{region}/pipeline", a.UpdatePipeline).Methods("PUT")
+	apiRouter.HandleFunc("/regions/{region}/locks", a.ListLocks).Methods("GET")
+	apiRouter.HandleFunc("/regions/{region}/locks", a.CreateLock).Methods("POST")
 
 	// Environments
-	apiRouter.HandleFunc("/regions/{region}/environments", ListEnvironments).Methods("GET")
-	apiRouter.HandleFunc("/regions/{region}/environments", CreateEnvironment).Methods("POST")
-	apiRouter.HandleFunc("/regions/{region}/environments/{environment}", GetEnvironment).Methods("GET")
-	apiRouter.HandleFunc("/regions/{region}/environments/{environment}", UpdateEnvironment).Methods("PUT")
-	apiRouter.HandleFunc("/regions/{region}/environments/{environment}", DeleteEnvironment).Methods("DELETE")
+	apiRouter.HandleFunc("/regions/{region}/environments", a.ListEnvironments).Methods("GET")
+	apiRouter.HandleFunc("/regions/{region}/environments", a.CreateEnvironment).Methods("POST")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}", a.GetEnvironment).Methods("GET")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}", a.UpdateEnvironment).Methods("PUT")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}", a.PatchEnvironment).Methods("PATCH")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}", a.DeleteEnvironment).Methods("DELETE")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/locks", a.ListLocks).Methods("GET")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/locks", a.CreateLock).Methods("POST")
 
 	// Apps
-	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps", ListApps).Methods("GET")
-	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps", CreateApp).Methods("POST")
-	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps/{app}", GetApp).Methods("GET")
-	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps/{app}", UpdateApp).Methods("PUT")
-	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps/{app}", DeleteApp).Methods("DELETE")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps", a.ListApps).Methods("GET")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps", a.CreateApp).Methods("POST")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps/{app}", a.GetApp).Methods("GET")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps/{app}", a.UpdateApp).Methods("PUT")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps/{app}", a.PatchApp).Methods("PATCH")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps/{app}", a.DeleteApp).Methods("DELETE")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps/{app}/history", a.GetAppHistory).Methods("GET")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps/{app}/rollback", a.RollbackApp).Methods("POST")
+	apiRouter.HandleFunc("/regions/{region}/environments/{environment}/apps/{app}/promote", a.PromoteApp).Methods("POST")
+
+	// Locks
+	apiRouter.HandleFunc("/locks", a.ListLocks).Methods("GET")
+	apiRouter.HandleFunc("/locks/{lock}", a.GetLock).Methods("GET")
+	apiRouter.HandleFunc("/locks/{lock}", a.DeleteLock).Methods("DELETE")
+
+	// Webhooks
+	apiRouter.HandleFunc("/webhooks", a.ListWebhooks).Methods("GET")
+	apiRouter.HandleFunc("/webhooks", a.CreateWebhook).Methods("POST")
+	apiRouter.HandleFunc("/webhooks/{webhook}", a.GetWebhook).Methods("GET")
+	apiRouter.HandleFunc("/webhooks/{webhook}", a.UpdateWebhook).Methods("PUT")
+	apiRouter.HandleFunc("/webhooks/{webhook}", a.DeleteWebhook).Methods("DELETE")
+	apiRouter.HandleFunc("/webhooks/{webhook}/deliveries", a.ListWebhookDeliveries).Methods("GET")
+
+	// Tokens
+	apiRouter.HandleFunc("/tokens", a.ListTokens).Methods("GET")
+	apiRouter.HandleFunc("/tokens", a.CreateToken).Methods("POST")
+	apiRouter.HandleFunc("/tokens/{token}", a.GetToken).Methods("GET")
+	apiRouter.HandleFunc("/tokens/{token}", a.DeleteToken).Methods("DELETE")
 
-	return router, nil
+	return router
 }
@@ -0,0 +1,296 @@
+// Package audit keeps a tamper-evident log of the changes made through the
+// API. Entries are appended to a file as JSON lines, each carrying the hash of
+// the one before it, so that editing, removing or reordering entries breaks
+// the chain.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTampered is returned by Verify when the chain of hashes is broken.
+var ErrTampered = errors.New("audit log has been tampered with")
+
+// maxLine bounds the size of one entry when reading the log back.
+const maxLine = 16 << 20
+
+// Entry records one mutating request.
+type Entry struct {
+	Seq       uint64    `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	SourceIP  string    `json:"sourceIp"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	// Body is the request body, if any.
+	Body json.RawMessage `json:"body,omitempty"`
+	// Before and After are the object the request changed, as they were
+	// before and after it; Diff is a JSON patch from one to the other.
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	Diff   json.RawMessage `json:"diff,omitempty"`
+	// PrevHash is the Hash of the previous entry, empty for the first one.
+	PrevHash string `json:"prevHash"`
+	// Hash is the SHA-256 of the entry encoded without it.
+	Hash string `json:"hash"`
+}
+
+// computeHash returns the hash of e with its Hash field ignored.
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Filter selects entries. Zero fields match everything.
+type Filter struct {
+	Actor  string
+	Method string
+	// PathPrefix matches the request path, e.g. /api/v1/regions/eu.
+	PathPrefix string
+	From, To   time.Time
+	Offset     int
+	Limit      int
+}
+
+func (f Filter) matches(e Entry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Method == "" || strings.EqualFold(e.Method, f.Method)) &&
+		strings.HasPrefix(e.Path, f.PathPrefix) &&
+		(f.From.IsZero() || !e.Timestamp.Before(f.From)) &&
+		(f.To.IsZero() || !e.Timestamp.After(f.To))
+}
+
+// logFile is the file an audit log is appended to.
+type logFile interface {
+	io.WriteCloser
+	Sync() error
+	Truncate(size int64) error
+}
+
+// Log appends entries to an audit file.
+type Log struct {
+	path string
+
+	mu   sync.Mutex
+	file logFile
+	// size is the length of the file up to the end of its last entry. If an
+	// append fails part way, the file is cut back to it.
+	size     int64
+	torn     bool
+	seq      uint64
+	lastHash string
+}
+
+// Open opens the audit log at path, creating it if needed, and continues the
+// chain from its last entry. A partial entry at the end of the file, left by
+// a crash during an append, is cut off.
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+	size, err := scan(path, func(e Entry) error {
+		l.seq = e.Seq
+		l.lastHash = e.Hash
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() > size {
+		if err := file.Truncate(size); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("cutting off the partial entry at the end: %w", err)
+		}
+	}
+	l.file = file
+	l.size = size
+	return l, nil
+}
+
+// Append chains e to the log and writes it to disk. Seq, PrevHash and Hash
+// are filled in. If writing fails, the file is truncated back to its last
+// entry, so that the chain never continues after a partial one.
+func (l *Log) Append(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.torn {
+		if err := l.file.Truncate(l.size); err != nil {
+			return fmt.Errorf("audit log ends in a partial entry: %w", err)
+		}
+		l.torn = false
+	}
+
+	e.Seq = l.seq + 1
+	e.PrevHash = l.lastHash
+	hash, err := e.computeHash()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err = l.file.Write(line); err == nil {
+		if err = l.file.Sync(); err == nil {
+			l.size += int64(len(line))
+			l.seq = e.Seq
+			l.lastHash = e.Hash
+			return nil
+		}
+	}
+	if terr := l.file.Truncate(l.size); terr != nil {
+		l.torn = true
+		return fmt.Errorf("%v; truncating the audit log failed: %v", err, terr)
+	}
+	l.file.Sync()
+	return err
+}
+
+// Query returns a page of the entries matching f, newest first, and the
+// number of entries that match.
+func (l *Log) Query(f Filter) ([]Entry, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var matched []Entry
+	_, err := scan(l.path, func(e Entry) error {
+		if f.matches(e) {
+			matched = append(matched, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(matched)
+	entries := []Entry{}
+	for i := total - 1 - f.Offset; i >= 0 && (f.Limit <= 0 || len(entries) < f.Limit); i-- {
+		entries = append(entries, matched[i])
+	}
+	return entries, total, nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// scan calls fn with every entry of the file at path, in order, and returns
+// the offset of the end of the last one. A last line that is incomplete or
+// cannot be decoded is left out, as expected when the process died part way
+// through an append, but an undecodable line followed by more is an error.
+func scan(path string, fn func(Entry) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var end int64
+	reader := bufio.NewReaderSize(file, 64<<10)
+	for n := 1; ; n++ {
+		line, err := readLine(reader)
+		if err == io.EOF {
+			return end, nil
+		}
+		if err != nil {
+			return end, fmt.Errorf("line %d: %w", n, err)
+		}
+		if !bytes.HasSuffix(line, []byte("\n")) {
+			return end, nil
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			end += int64(len(line))
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			if _, perr := reader.Peek(1); perr == io.EOF {
+				return end, nil
+			}
+			return end, fmt.Errorf("line %d: %w", n, err)
+		}
+		if err := fn(e); err != nil {
+			return end, fmt.Errorf("line %d: %w", n, err)
+		}
+		end += int64(len(line))
+	}
+}
+
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxLine {
+			return nil, fmt.Errorf("entry longer than %d bytes", maxLine)
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(line) > 0:
+			return line, nil
+		default:
+			return line, err
+		}
+	}
+}
+
+// Verify checks the chain of the audit log at path and returns the number of
+// entries in it and the hash of the last one. It fails with ErrTampered at the
+// first entry whose sequence number, link to the previous entry or hash does
+// not match. Entries cut off the end can only be noticed by comparing the
+// head with one noted earlier; a partial last entry is ignored like by Open.
+func Verify(path string) (count int, head string, err error) {
+	prevHash := ""
+	_, err = scan(path, func(e Entry) error {
+		count++
+		hash, err := e.computeHash()
+		switch {
+		case err != nil:
+			return err
+		case e.Seq != uint64(count):
+			return fmt.Errorf("%w: entry %d has sequence number %d", ErrTampered, count, e.Seq)
+		case e.PrevHash != prevHash:
+			return fmt.Errorf("%w: entry %d does not follow the entry before it", ErrTampered, e.Seq)
+		case e.Hash != hash:
+			return fmt.Errorf("%w: entry %d does not match its hash", ErrTampered, e.Seq)
+		}
+		prevHash = e.Hash
+		return nil
+	})
+	return count, prevHash, err
+}
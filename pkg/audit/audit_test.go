@@ -0,0 +1,238 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingFile writes only part of what it is given and then fails, like a
+// disk filling up. Truncate fails too if truncateErr is set.
+type failingFile struct {
+	*os.File
+	truncateErr error
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.truncateErr != nil {
+		return f.truncateErr
+	}
+	return f.File.Truncate(size)
+}
+
+// writeLog appends an entry for each actor to a new audit log and returns
+// its path.
+func writeLog(t *testing.T, actors ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.json.audit")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for _, actor := range actors {
+		if err := l.Append(Entry{Actor: actor, Method: "PUT", Path: "/api/v1/regions/amer"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func readLines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(raw, []byte("\n"))
+	return lines[:len(lines)-1]
+}
+
+func writeLines(t *testing.T, path string, lines [][]byte) {
+	t.Helper()
+	if err := os.WriteFile(path, bytes.Join(lines, nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	path := writeLog(t, "alice", "bob", "carol")
+
+	count, head, err := Verify(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := readLines(t, path)
+	var last Entry
+	if err := json.Unmarshal(lines[len(lines)-1], &last); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || head != last.Hash {
+		t.Fatalf("Verify = %d, %s, want 3, %s", count, head, last.Hash)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, lines [][]byte) [][]byte
+	}{
+		{"edited", func(t *testing.T, lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"bob"`), []byte(`"mallory"`), 1)
+			return lines
+		}},
+		{"edited with its hash recomputed", func(t *testing.T, lines [][]byte) [][]byte {
+			var e Entry
+			if err := json.Unmarshal(lines[1], &e); err != nil {
+				t.Fatal(err)
+			}
+			e.Actor = "mallory"
+			hash, err := e.computeHash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.Hash = hash
+			line, err := json.Marshal(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines[1] = append(line, '\n')
+			return lines
+		}},
+		{"deleted", func(t *testing.T, lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}},
+		{"first deleted", func(t *testing.T, lines [][]byte) [][]byte {
+			return lines[1:]
+		}},
+		{"reordered", func(t *testing.T, lines [][]byte) [][]byte {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeLog(t, "alice", "bob", "carol")
+			writeLines(t, path, tt.tamper(t, readLines(t, path)))
+
+			if _, _, err := Verify(path); !errors.Is(err, ErrTampered) {
+				t.Fatalf("Verify = %v, want ErrTampered", err)
+			}
+		})
+	}
+}
+
+func TestVerifyCorruptLine(t *testing.T) {
+	path := writeLog(t, "alice", "bob", "carol")
+	lines := readLines(t, path)
+	lines[1] = []byte("{\n")
+	writeLines(t, path, lines)
+
+	if _, _, err := Verify(path); err == nil {
+		t.Fatal("Verify of a log with a corrupt line succeeded")
+	}
+}
+
+func TestOpenCutsOffPartialEntry(t *testing.T) {
+	path := writeLog(t, "alice", "bob")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":3,"timestamp":"2026-10-18T`)
+	f.Close()
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Entry{Actor: "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	if count, _, err := Verify(path); err != nil || count != 3 {
+		t.Fatalf("Verify = %d, %v, want 3 entries", count, err)
+	}
+}
+
+func TestAppendUndoesFailedWrite(t *testing.T) {
+	path := writeLog(t, "alice")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	file := l.file.(*os.File)
+
+	l.file = &failingFile{File: file}
+	if err := l.Append(Entry{Actor: "bob"}); err == nil {
+		t.Fatal("failed append returned no error")
+	}
+	l.file = file
+	if err := l.Append(Entry{Actor: "carol"}); err != nil {
+		t.Fatal(err)
+	}
+
+	count, _, err := Verify(path)
+	if err != nil || count != 2 {
+		t.Fatalf("Verify = %d, %v, want 2 entries", count, err)
+	}
+}
+
+func TestAppendAfterFailedTruncate(t *testing.T) {
+	path := writeLog(t, "alice")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	file := l.file.(*os.File)
+
+	l.file = &failingFile{File: file, truncateErr: errors.New("read-only file system")}
+	if err := l.Append(Entry{Actor: "bob"}); err == nil {
+		t.Fatal("failed append returned no error")
+	}
+	l.file = &failingFile{File: file, truncateErr: errors.New("read-only file system")}
+	if err := l.Append(Entry{Actor: "carol"}); err == nil {
+		t.Fatal("append after a partial entry returned no error")
+	}
+
+	// Once the partial entry can be cut off, the chain continues
+	l.file = file
+	if err := l.Append(Entry{Actor: "dave"}); err != nil {
+		t.Fatal(err)
+	}
+	count, _, err := Verify(path)
+	if err != nil || count != 2 {
+		t.Fatalf("Verify = %d, %v, want 2 entries", count, err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	path := writeLog(t, "alice", "bob", "alice")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	entries, total, err := l.Query(Filter{Actor: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(entries) != 2 || entries[0].Seq != 3 || entries[1].Seq != 1 {
+		t.Fatalf("Query = %+v, %d, want entries 3 and 1", entries, total)
+	}
+	if entries, _, _ = l.Query(Filter{Offset: 1, Limit: 1}); len(entries) != 1 || entries[0].Seq != 2 {
+		t.Fatalf("page = %+v, want entry 2", entries)
+	}
+}
@@ -0,0 +1,183 @@
+// Package certs serves TLS with a certificate, and optionally a client CA,
+// that are reloaded when their files change.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"vhub/pkg/data"
+)
+
+// Reloader holds the certificate and client CA pool loaded from files and
+// reloads them when the files change on disk.
+type Reloader struct {
+	certFile, keyFile, caFile string
+	clientAuth                tls.ClientAuthType
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes map[string]time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewReloader loads a certificate and key, and the client CAs if caFile is
+// set. Client certificates are then verified when given, or always if
+// requireClientCert is set.
+func NewReloader(certFile, keyFile, caFile string, requireClientCert bool) (*Reloader, error) {
+	r := &Reloader{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		caFile:     caFile,
+		clientAuth: tls.NoClientCert,
+		modTimes:   make(map[string]time.Time),
+		stop:       make(chan struct{}),
+	}
+	switch {
+	case caFile != "" && requireClientCert:
+		r.clientAuth = tls.RequireAndVerifyClientCert
+	case caFile != "":
+		r.clientAuth = tls.VerifyClientCertIfGiven
+	case requireClientCert:
+		return nil, fmt.Errorf("requiring client certificates needs a client CA")
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+func (r *Reloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.pool = pool
+	r.modTimes = modTimes
+	return nil
+}
+
+// changed reports whether any of the files was modified since it was loaded.
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch checks the files for changes every interval until Stop is called. A
+// change that fails to load is logged and the previous certificate kept.
+func (r *Reloader) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				if !r.changed() {
+					continue
+				}
+				if err := r.load(); err != nil {
+					data.Log.WithField("error", err).Error("Failed to reload TLS certificate")
+					continue
+				}
+				data.Log.WithField("cert", r.certFile).Info("Reloaded TLS certificate")
+			}
+		}
+	}()
+}
+
+// Stop ends Watch.
+func (r *Reloader) Stop() {
+	r.once.Do(func() { close(r.stop) })
+}
+
+// TLSConfig returns a server configuration that uses the certificate and
+// client CAs loaded last for each new connection.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientCAs:    r.pool,
+				ClientAuth:   r.clientAuth,
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}
+}
+
+// Identity returns whom a verified client certificate identifies: its first
+// URI, email or DNS SAN, else its subject's common name. Its organizational
+// units are returned as groups.
+func Identity(cert *x509.Certificate) (name string, groups []string) {
+	switch {
+	case len(cert.URIs) > 0:
+		name = cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		name = cert.EmailAddresses[0]
+	case len(cert.DNSNames) > 0:
+		name = cert.DNSNames[0]
+	default:
+		name = cert.Subject.CommonName
+	}
+	return name, cert.Subject.OrganizationalUnit
+}
@@ -1,30 +1,94 @@
 package checker
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"sync"
 	"time"
+	"vhub/pkg/data"
+)
+
+const (
+	defaultInterval = 5 * time.Minute
+	defaultTimeout  = 10 * time.Second
+	defaultWorkers  = 4
+	// retryDelay is the pause between the attempts of a failing check.
+	retryDelay = time.Second
 )
 
 type HealthCheckConfig struct {
 	EnableHealthCheck bool           `json:"enableHealthCheck"`
 	HealthChecks      []HealthStatus `json:"healthChecks"`
+	// VersionChecks read the versions apps report about themselves.
+	VersionChecks []VersionStatus `json:"versionChecks"`
+	// Workers bounds the number of checks running at once.
+	Workers int `json:"workers"`
+	// HistorySize is the number of results kept for each health check.
+	HistorySize int `json:"historySize"`
 }
 
 type HealthStatus struct {
+	// ID names the check in the API. It defaults to region-environment,
+	// numbered from -2 if several checks share it.
+	ID          string `json:"id"`
 	Region      string
 	Environment string
 	URL         string
 	Status      string
 	LastChecked time.Time
+	// Latency and Error are those of the last run.
+	Latency Duration `json:"latency"`
+	Error   string   `json:"error,omitempty"`
+
+	// Type is the kind of probe: http (the default) checks the response to
+	// a request to URL; tcp connects to URL as host:port; dns resolves URL
+	// as a name, through the name server Resolver if set; grpc calls the
+	// standard gRPC health service at grpc://host:port, or grpcs:// for
+	// TLS, for Service or the whole server if empty.
+	Type     string `json:"type,omitempty"`
+	Resolver string `json:"resolver,omitempty"`
+	Service  string `json:"service,omitempty"`
+
+	Schedule
+
+	// Method, Path, Headers and Body make the request of the check, a GET
+	// of /healthcheck by default. Header values may refer to environment
+	// variables as ${NAME}, to keep secrets out of the file. The check
+	// passes if the response status is one of ExpectStatus (200 by
+	// default), the body matches the regular expression ExpectBody and the
+	// values at the JSONPaths of ExpectJSON are as given. TLS also applies
+	// to grpcs checks.
+	Method       string            `json:"method,omitempty"`
+	Path         string            `json:"path,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Body         string            `json:"body,omitempty"`
+	ExpectStatus []int             `json:"expectStatus,omitempty"`
+	ExpectBody   string            `json:"expectBody,omitempty"`
+	ExpectJSON   map[string]string `json:"expectJSON,omitempty"`
+	TLS          *TLSOptions       `json:"tls,omitempty"`
+}
+
+// Schedule sets when a check runs. Interval is the time between the runs of
+// the check, Timeout bounds each attempt and Jitter adds up to that much
+// random delay to every run. A failing check is tried up to Retries more
+// times before it is marked as failed.
+type Schedule struct {
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+	Jitter   Duration `json:"jitter"`
+	Retries  int      `json:"retries,omitempty"`
 }
 
 var (
-	statusData []HealthStatus
-	mu         sync.RWMutex
+	statusData  []HealthStatus
+	histories   []*history
+	versionData []VersionStatus
+	mu          sync.RWMutex
+
+	running *scheduler
 )
 
 func StartHealthChecks(configFile string) {
@@ -38,21 +102,105 @@ func StartHealthChecks(configFile string) {
 		return
 	}
 
-	statusData = make([]HealthStatus, len(config.HealthChecks))
-	copy(statusData, config.HealthChecks)
+	var tasks []task
+	checks := make([]HealthStatus, len(config.HealthChecks))
+	ids := make(map[string]bool, len(config.HealthChecks))
+	for i, check := range config.HealthChecks {
+		i, check := i, check
+		check.Schedule = check.Schedule.withDefaults()
+		check.ID = uniqueID(ids, check)
+		checks[i] = check
+		probe, err := newProbe(check)
+		if err != nil {
+			log.Fatalf("Error in health check %d (%s/%s): %v", i, check.Region, check.Environment, err)
+		}
+		tasks = append(tasks, task{
+			interval: check.Interval.Duration,
+			jitter:   check.Jitter.Duration,
+			run:      func(ctx context.Context) { runHealthCheck(ctx, i, check, probe) },
+		})
+	}
 
+	versions := make([]VersionStatus, len(config.VersionChecks))
+	for i, check := range config.VersionChecks {
+		i, check := i, check
+		check.Schedule = check.Schedule.withDefaults()
+		versions[i] = check
+		probe, err := newVersionProbe(check)
+		if err != nil {
+			log.Fatalf("Error in version check %d (%s/%s/%s): %v", i, check.Region, check.Environment, check.App, err)
+		}
+		tasks = append(tasks, task{
+			interval: check.Interval.Duration,
+			jitter:   check.Jitter.Duration,
+			run:      func(ctx context.Context) { runVersionCheck(ctx, i, check, probe) },
+		})
+	}
+
+	mu.Lock()
+	historySize := config.HistorySize
+	if historySize <= 0 {
+		historySize = defaultHistorySize
+	}
+	statusData = make([]HealthStatus, len(checks))
+	copy(statusData, checks)
+	histories = make([]*history, len(checks))
 	for i := range statusData {
 		statusData[i].Status = "Unknown"
+		histories[i] = newHistory(historySize)
+	}
+	versionData = make([]VersionStatus, len(versions))
+	copy(versionData, versions)
+	for i := range versionData {
+		versionData[i].Status = "Unknown"
 	}
+	mu.Unlock()
 
-	go func() {
-		for {
-			for i := range statusData {
-				checkService(i)
-			}
-			time.Sleep(5 * time.Minute)
-		}
-	}()
+	workers := config.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	running = newScheduler(tasks, workers)
+	running.start()
+}
+
+// StopHealthChecks stops scheduling checks and waits for those running to
+// finish.
+func StopHealthChecks() {
+	if running != nil {
+		running.stop()
+	}
+}
+
+// uniqueID returns the ID of check, or a default one, that is not yet in
+// ids, and adds it.
+func uniqueID(ids map[string]bool, check HealthStatus) string {
+	id := check.ID
+	if id == "" {
+		id = check.Region + "-" + check.Environment
+	}
+	unique := id
+	for n := 2; ids[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", id, n)
+	}
+	ids[unique] = true
+	return unique
+}
+
+func (s Schedule) withDefaults() Schedule {
+	if s.Interval.Duration <= 0 {
+		s.Interval.Duration = defaultInterval
+	}
+	if s.Timeout.Duration <= 0 {
+		s.Timeout.Duration = defaultTimeout
+	}
+	if s.Jitter.Duration < 0 {
+		s.Jitter.Duration = 0
+	}
+	if s.Retries < 0 {
+		s.Retries = 0
+	}
+	return s
 }
 
 func loadConfig(file string) (HealthCheckConfig, error) {
@@ -69,20 +217,49 @@ func loadConfig(file string) (HealthCheckConfig, error) {
 	return config, err
 }
 
-func checkService(i int) {
-	healthURL := statusData[i].URL + "/healthcheck"
-
-	resp, err := http.Get(healthURL)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		statusData[i].Status = "Fail"
-		statusData[i].LastChecked = time.Now()
+// runHealthCheck performs health check i, retrying a failure up to its
+// retry count, and records the result.
+func runHealthCheck(ctx context.Context, i int, check HealthStatus, probe prober) {
+	var latency time.Duration
+	err := retry(ctx, check.Retries, check.Timeout.Duration, func(ctx context.Context) error {
+		start := time.Now()
+		err := probe.check(ctx)
+		latency = time.Since(start)
+		return err
+	})
+	if ctx.Err() != nil {
 		return
 	}
 
-	defer resp.Body.Close()
+	result := Result{Time: time.Now(), Status: "OK", Latency: Duration{latency.Round(time.Microsecond)}}
+	if err != nil {
+		result.Status = "Fail"
+		result.Error = err.Error()
+	}
+	previous := record(i, result)
+
+	log := data.Log.WithField("region", check.Region).WithField("environment", check.Environment).WithField("url", check.URL)
+	switch {
+	case err != nil && previous != "Fail":
+		log.WithField("error", err).Warn("Health check failed")
+	case err == nil && previous == "Fail":
+		log.Info("Health check recovered")
+	}
+}
+
+// record adds the result of a run of check i to its status and history and
+// returns the status it had before.
+func record(i int, result Result) string {
+	mu.Lock()
+	defer mu.Unlock()
 
-	statusData[i].Status = "OK"
-	statusData[i].LastChecked = time.Now()
+	previous := statusData[i].Status
+	statusData[i].Status = result.Status
+	statusData[i].LastChecked = result.Time
+	statusData[i].Latency = result.Latency
+	statusData[i].Error = result.Error
+	histories[i].add(result)
+	return previous
 }
 
 func GetHealthStatus() []HealthStatus {
@@ -91,3 +268,28 @@ func GetHealthStatus() []HealthStatus {
 
 	return append([]HealthStatus(nil), statusData...)
 }
+
+// Duration is a time.Duration read from JSON as a string such as "30s", or
+// a number of seconds.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err == nil {
+		d.Duration = time.Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
@@ -0,0 +1,175 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/http2"
+)
+
+// grpcHealthPath is the method of the standard gRPC health checking
+// protocol, grpc.health.v1.Health/Check.
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+// Serving statuses of grpc.health.v1.HealthCheckResponse.
+var grpcServingStatus = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+// grpcProbe calls the gRPC health service of a server, over HTTP/2 in plain
+// text for grpc://host:port or host:port, and over TLS for grpcs://.
+type grpcProbe struct {
+	client  *http.Client
+	url     string
+	service string
+}
+
+func newGRPCProbe(check HealthStatus) (*grpcProbe, error) {
+	address, scheme := target(check.URL)
+	u := url.URL{Scheme: "http", Host: address, Path: grpcHealthPath}
+
+	transport := &http2.Transport{}
+	switch scheme {
+	case "", "grpc":
+		// Plain text HTTP/2 (h2c), with prior knowledge
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		}
+	case "grpcs":
+		u.Scheme = "https"
+		config, err := tlsConfig(check.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	default:
+		return nil, fmt.Errorf("grpc check needs a grpc:// or grpcs:// URL, not %s://", scheme)
+	}
+
+	return &grpcProbe{
+		client:  &http.Client{Transport: transport},
+		url:     u.String(),
+		service: check.Service,
+	}, nil
+}
+
+func (p *grpcProbe) check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(grpcHealthRequest(p.service)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return err
+	}
+
+	// A call that fails at once sends its status in the headers instead of
+	// the trailers.
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if message, err := url.PathUnescape(message); err == nil && message != "" {
+			return fmt.Errorf("gRPC status %s: %s", status, message)
+		}
+		return fmt.Errorf("gRPC status %s", status)
+	}
+
+	serving, err := grpcHealthResponse(body)
+	if err != nil {
+		return err
+	}
+	if serving != 1 {
+		name, ok := grpcServingStatus[serving]
+		if !ok {
+			name = strconv.FormatUint(serving, 10)
+		}
+		return fmt.Errorf("service is %s", name)
+	}
+	return nil
+}
+
+// grpcHealthRequest returns a HealthCheckRequest for service, framed as a
+// gRPC message.
+func grpcHealthRequest(service string) []byte {
+	var message []byte
+	if service != "" {
+		message = append(message, 0x0a) // field 1, length-delimited
+		message = binary.AppendUvarint(message, uint64(len(service)))
+		message = append(message, service...)
+	}
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// grpcHealthResponse returns the status field of the HealthCheckResponse
+// framed in body.
+func grpcHealthResponse(body []byte) (uint64, error) {
+	if len(body) < 5 {
+		return 0, fmt.Errorf("gRPC response is too short")
+	}
+	if body[0] != 0 {
+		return 0, fmt.Errorf("gRPC response is compressed")
+	}
+	length := binary.BigEndian.Uint32(body[1:5])
+	if uint32(len(body)-5) < length {
+		return 0, fmt.Errorf("gRPC response is truncated")
+	}
+	message := body[5 : 5+length]
+
+	var status uint64
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid HealthCheckResponse")
+		}
+		message = message[n:]
+		switch key & 7 {
+		case 0: // varint
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return 0, fmt.Errorf("invalid HealthCheckResponse")
+			}
+			message = message[n:]
+			if key>>3 == 1 {
+				status = value
+			}
+		case 2: // length-delimited
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return 0, fmt.Errorf("invalid HealthCheckResponse")
+			}
+			message = message[n+int(length):]
+		default:
+			return 0, fmt.Errorf("invalid HealthCheckResponse")
+		}
+	}
+	return status, nil
+}
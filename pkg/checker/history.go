@@ -0,0 +1,204 @@
+package checker
+
+import "time"
+
+const defaultHistorySize = 500
+
+// Result is one run of a health check.
+type Result struct {
+	Time    time.Time `json:"time"`
+	Status  string    `json:"status"`
+	Latency Duration  `json:"latency"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Uptime summarises the runs of a check over a window: the share of them
+// that passed, as a percentage, and their latency.
+type Uptime struct {
+	Window     string   `json:"window"`
+	Runs       int      `json:"runs"`
+	Percent    float64  `json:"percent"`
+	AvgLatency Duration `json:"avgLatency"`
+	MaxLatency Duration `json:"maxLatency"`
+}
+
+// uptimeWindows are the windows Uptime is computed over. The last hour is
+// counted by the minute and longer windows by the hour, so they cover the
+// window to within one bucket.
+var uptimeWindows = []struct {
+	name   string
+	window time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+// history keeps the latest results of a check in a ring, and counts of all
+// its runs by minute for the last hour and by hour for the last week.
+type history struct {
+	results []Result
+	next    int
+	full    bool
+
+	minutes [60]bucket
+	hours   [7 * 24]bucket
+}
+
+// bucket counts the runs of a check that started in the minute or hour
+// beginning at start.
+type bucket struct {
+	start      time.Time
+	runs       int
+	passed     int
+	latency    time.Duration
+	maxLatency time.Duration
+}
+
+func newHistory(size int) *history {
+	return &history{results: make([]Result, size)}
+}
+
+func (h *history) add(result Result) {
+	h.results[h.next] = result
+	h.next = (h.next + 1) % len(h.results)
+	if h.next == 0 {
+		h.full = true
+	}
+
+	minute := result.Time.Truncate(time.Minute)
+	h.minutes[minute.Unix()/60%int64(len(h.minutes))].add(minute, result)
+	hour := result.Time.Truncate(time.Hour)
+	h.hours[hour.Unix()/3600%int64(len(h.hours))].add(hour, result)
+}
+
+func (b *bucket) add(start time.Time, result Result) {
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	b.runs++
+	if result.Status == "OK" {
+		b.passed++
+	}
+	b.latency += result.Latency.Duration
+	if result.Latency.Duration > b.maxLatency {
+		b.maxLatency = result.Latency.Duration
+	}
+}
+
+// latest returns up to limit of the latest results, oldest first, or all of
+// those kept if limit is 0.
+func (h *history) latest(limit int) []Result {
+	count := h.next
+	if h.full {
+		count = len(h.results)
+	}
+	if limit > 0 && limit < count {
+		count = limit
+	}
+	results := make([]Result, 0, count)
+	for i := h.next - count; i < h.next; i++ {
+		results = append(results, h.results[(i+len(h.results))%len(h.results)])
+	}
+	return results
+}
+
+// uptime returns the uptime over each of uptimeWindows up to now.
+func (h *history) uptime(now time.Time) []Uptime {
+	uptimes := make([]Uptime, 0, len(uptimeWindows))
+	for _, w := range uptimeWindows {
+		buckets := h.hours[:]
+		if w.window <= time.Hour {
+			buckets = h.minutes[:]
+		}
+
+		var total bucket
+		since := now.Add(-w.window)
+		for _, b := range buckets {
+			if b.runs == 0 || !b.start.After(since) || b.start.After(now) {
+				continue
+			}
+			total.runs += b.runs
+			total.passed += b.passed
+			total.latency += b.latency
+			if b.maxLatency > total.maxLatency {
+				total.maxLatency = b.maxLatency
+			}
+		}
+
+		u := Uptime{Window: w.name, Runs: total.runs}
+		if total.runs > 0 {
+			u.Percent = float64(total.passed) * 100 / float64(total.runs)
+			u.AvgLatency.Duration = (total.latency / time.Duration(total.runs)).Round(time.Microsecond)
+			u.MaxLatency.Duration = total.maxLatency
+		}
+		uptimes = append(uptimes, u)
+	}
+	return uptimes
+}
+
+// CheckHistory is a health check with its uptime and latest results.
+type CheckHistory struct {
+	ID          string    `json:"id"`
+	Region      string    `json:"region"`
+	Environment string    `json:"environment"`
+	Type        string    `json:"type"`
+	URL         string    `json:"url"`
+	Status      string    `json:"status"`
+	LastChecked time.Time `json:"lastChecked"`
+	Latency     Duration  `json:"latency"`
+	Error       string    `json:"error,omitempty"`
+	Uptime      []Uptime  `json:"uptime"`
+	Results     []Result  `json:"results"`
+}
+
+// GetCheckHistory returns the check with the given ID with up to limit of
+// its latest results, all of those kept if limit is 0, and whether there is
+// such a check.
+func GetCheckHistory(id string, limit int) (CheckHistory, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for i, check := range statusData {
+		if check.ID == id {
+			return checkHistory(i, limit, time.Now()), true
+		}
+	}
+	return CheckHistory{}, false
+}
+
+// GetHealthHistory returns every check with up to limit of its latest
+// results.
+func GetHealthHistory(limit int) []CheckHistory {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	now := time.Now()
+	checks := make([]CheckHistory, len(statusData))
+	for i := range statusData {
+		checks[i] = checkHistory(i, limit, now)
+	}
+	return checks
+}
+
+// checkHistory returns check i; the caller must hold mu.
+func checkHistory(i, limit int, now time.Time) CheckHistory {
+	check := statusData[i]
+	probe := check.Type
+	if probe == "" {
+		probe = ProbeHTTP
+	}
+	return CheckHistory{
+		ID:          check.ID,
+		Region:      check.Region,
+		Environment: check.Environment,
+		Type:        probe,
+		URL:         check.URL,
+		Status:      check.Status,
+		LastChecked: check.LastChecked,
+		Latency:     check.Latency,
+		Error:       check.Error,
+		Uptime:      histories[i].uptime(now),
+		Results:     histories[i].latest(limit),
+	}
+}
@@ -0,0 +1,173 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"vhub/pkg/jsonpath"
+)
+
+const (
+	defaultPath = "/healthcheck"
+	// maxBody bounds how much of a response is read to check its body.
+	maxBody = 1 << 20
+)
+
+// TLSOptions sets how the certificate of an HTTPS endpoint is verified.
+type TLSOptions struct {
+	// InsecureSkipVerify accepts any certificate.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// CAFile is a PEM file of the CAs to trust instead of the system ones.
+	CAFile string `json:"caFile,omitempty"`
+	// ServerName is the name to verify the certificate against, if not the
+	// host of the URL.
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// httpProbe checks an HTTP endpoint as configured by a HealthStatus.
+type httpProbe struct {
+	client     *http.Client
+	method     string
+	url        string
+	body       string
+	headers    http.Header
+	statuses   []int
+	bodyRegex  *regexp.Regexp
+	jsonChecks []jsonCheck
+}
+
+// jsonCheck asserts the value a JSONPath selects in the response.
+type jsonCheck struct {
+	path jsonpath.Path
+	want string
+}
+
+func newHTTPProbe(check HealthStatus) (*httpProbe, error) {
+	p := &httpProbe{
+		method:   strings.ToUpper(check.Method),
+		url:      check.URL + check.Path,
+		body:     check.Body,
+		headers:  make(http.Header),
+		statuses: check.ExpectStatus,
+	}
+	if p.method == "" {
+		p.method = http.MethodGet
+	}
+	if check.Path == "" {
+		p.url = check.URL + defaultPath
+	}
+	if len(p.statuses) == 0 {
+		p.statuses = []int{http.StatusOK}
+	}
+	for name, value := range check.Headers {
+		p.headers.Set(name, os.ExpandEnv(value))
+	}
+
+	if check.ExpectBody != "" {
+		var err error
+		if p.bodyRegex, err = regexp.Compile(check.ExpectBody); err != nil {
+			return nil, fmt.Errorf("expectBody: %w", err)
+		}
+	}
+	for expr, want := range check.ExpectJSON {
+		path, err := jsonpath.Parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("expectJSON: %w", err)
+		}
+		p.jsonChecks = append(p.jsonChecks, jsonCheck{path: path, want: want})
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if check.TLS != nil {
+		config, err := tlsConfig(check.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+	p.client = &http.Client{Transport: transport}
+	return p, nil
+}
+
+// tlsConfig returns the client configuration for options, which may be nil.
+func tlsConfig(options *TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{}
+	if options == nil {
+		return config, nil
+	}
+	config.InsecureSkipVerify = options.InsecureSkipVerify
+	config.ServerName = options.ServerName
+	if options.CAFile != "" {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
+		}
+	}
+	return config, nil
+}
+
+// check makes the request and returns why the response is not healthy, or
+// nil if it is.
+func (p *httpProbe) check(ctx context.Context) error {
+	var body io.Reader
+	if p.body != "" {
+		body = strings.NewReader(p.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, p.method, p.url, body)
+	if err != nil {
+		return err
+	}
+	for name, values := range p.headers {
+		req.Header[name] = values
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !containsStatus(p.statuses, resp.StatusCode) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if p.bodyRegex == nil && len(p.jsonChecks) == 0 {
+		return nil
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return err
+	}
+	if p.bodyRegex != nil && !p.bodyRegex.Match(b) {
+		return fmt.Errorf("body does not match %s", p.bodyRegex)
+	}
+	for _, c := range p.jsonChecks {
+		got, err := c.path.Lookup(b)
+		if err != nil {
+			return err
+		}
+		if got != c.want {
+			return fmt.Errorf("%s is %q, expected %q", c.path, got, c.want)
+		}
+	}
+	return nil
+}
+
+func containsStatus(statuses []int, code int) bool {
+	for _, status := range statuses {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+	ProbeDNS  = "dns"
+	ProbeGRPC = "grpc"
+)
+
+// prober checks an endpoint once, returning why it is unhealthy or nil.
+type prober interface {
+	check(ctx context.Context) error
+}
+
+// newProbe returns the prober of the type a check names.
+func newProbe(check HealthStatus) (prober, error) {
+	switch strings.ToLower(check.Type) {
+	case "", ProbeHTTP:
+		return newHTTPProbe(check)
+	case ProbeTCP:
+		return newTCPProbe(check)
+	case ProbeDNS:
+		return newDNSProbe(check)
+	case ProbeGRPC:
+		return newGRPCProbe(check)
+	}
+	return nil, fmt.Errorf("unknown check type %q", check.Type)
+}
+
+// target returns the URL of a check without any scheme://, and the scheme.
+func target(url string) (address, scheme string) {
+	if scheme, address, ok := strings.Cut(url, "://"); ok {
+		return strings.TrimSuffix(address, "/"), strings.ToLower(scheme)
+	}
+	return url, ""
+}
+
+// tcpProbe checks that a TCP connection to host:port can be opened.
+type tcpProbe struct {
+	address string
+}
+
+func newTCPProbe(check HealthStatus) (*tcpProbe, error) {
+	address, _ := target(check.URL)
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("tcp check needs host:port: %w", err)
+	}
+	return &tcpProbe{address: address}, nil
+}
+
+func (p *tcpProbe) check(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// dnsProbe checks that a name resolves, through the system resolver or the
+// name server given as Resolver.
+type dnsProbe struct {
+	host     string
+	resolver *net.Resolver
+}
+
+func newDNSProbe(check HealthStatus) (*dnsProbe, error) {
+	host, _ := target(check.URL)
+	if host == "" {
+		return nil, fmt.Errorf("dns check needs a name to resolve")
+	}
+	p := &dnsProbe{host: host, resolver: net.DefaultResolver}
+	if check.Resolver != "" {
+		server := check.Resolver
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		p.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return p, nil
+}
+
+func (p *dnsProbe) check(ctx context.Context) error {
+	addresses, err := p.resolver.LookupHost(ctx, p.host)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		return fmt.Errorf("%s has no addresses", p.host)
+	}
+	return nil
+}
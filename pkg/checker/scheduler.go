@@ -0,0 +1,136 @@
+package checker
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// scheduler runs every task on its own timer, handing the runs to a fixed
+// number of workers so that slow checks cannot hold up the others beyond
+// the pool.
+type scheduler struct {
+	tasks []task
+	jobs  chan job
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// task is a check the scheduler runs every interval plus up to jitter.
+type task struct {
+	interval time.Duration
+	jitter   time.Duration
+	run      func(ctx context.Context)
+}
+
+// job is one run of a task; done is closed when it has finished.
+type job struct {
+	index int
+	done  chan struct{}
+}
+
+func newScheduler(tasks []task, workers int) *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &scheduler{
+		tasks:  tasks,
+		jobs:   make(chan job),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
+	return s
+}
+
+func (s *scheduler) start() {
+	for i := range s.tasks {
+		s.wg.Add(1)
+		go s.schedule(i)
+	}
+}
+
+func (s *scheduler) stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// schedule queues task i after its jitter, then every interval plus jitter
+// after the previous run has finished, so a task never overlaps itself.
+func (s *scheduler) schedule(i int) {
+	defer s.wg.Done()
+
+	t := s.tasks[i]
+	timer := time.NewTimer(jitter(t.jitter))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		run := job{index: i, done: make(chan struct{})}
+		select {
+		case <-s.ctx.Done():
+			return
+		case s.jobs <- run:
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-run.done:
+		}
+
+		timer.Reset(t.interval + jitter(t.jitter))
+	}
+}
+
+func (s *scheduler) work() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case run := <-s.jobs:
+			s.tasks[run.index].run(s.ctx)
+			close(run.done)
+		}
+	}
+}
+
+// retry calls attempt, within timeout, until it succeeds or has been tried
+// retries more times, and returns the last error.
+func retry(ctx context.Context, retries int, timeout time.Duration, attempt func(ctx context.Context) error) error {
+	var err error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryDelay):
+			}
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = attempt(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// jitter returns a random delay up to max.
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,214 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"vhub/pkg/data"
+	"vhub/pkg/jsonpath"
+)
+
+const defaultVersionPath = "/version"
+
+// VersionStatus is a check of the version an app reports about itself,
+// which is compared with the version recorded for the app.
+type VersionStatus struct {
+	Region      string
+	Environment string
+	App         string
+	URL         string
+	// Version is the version the app last reported, Status whether it could
+	// be read: Unknown before the first run, then OK or Fail with Error.
+	Version     string
+	Status      string
+	Error       string `json:"error,omitempty"`
+	LastChecked time.Time
+
+	// Path is requested with GET from URL, /version by default. JSONPath
+	// selects the version in a JSON response, such as $.build.version;
+	// without one the whole body, trimmed, is the version. Headers and TLS
+	// are as for health checks.
+	Path     string            `json:"path,omitempty"`
+	JSONPath string            `json:"jsonPath,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	TLS      *TLSOptions       `json:"tls,omitempty"`
+
+	Schedule
+}
+
+// versionProbe reads the version an app reports.
+type versionProbe struct {
+	client  *http.Client
+	url     string
+	headers http.Header
+	path    *jsonpath.Path
+}
+
+func newVersionProbe(check VersionStatus) (*versionProbe, error) {
+	if check.App == "" {
+		return nil, fmt.Errorf("version check needs an App")
+	}
+	p := &versionProbe{
+		url:     check.URL + check.Path,
+		headers: make(http.Header),
+	}
+	if check.Path == "" {
+		p.url = check.URL + defaultVersionPath
+	}
+	for name, value := range check.Headers {
+		p.headers.Set(name, os.ExpandEnv(value))
+	}
+	if check.JSONPath != "" {
+		path, err := jsonpath.Parse(check.JSONPath)
+		if err != nil {
+			return nil, fmt.Errorf("jsonPath: %w", err)
+		}
+		p.path = &path
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if check.TLS != nil {
+		config, err := tlsConfig(check.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+	p.client = &http.Client{Transport: transport}
+	return p, nil
+}
+
+// version requests the version endpoint and returns the version in the
+// response.
+func (p *versionProbe) version(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, values := range p.headers {
+		req.Header[name] = values
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return "", err
+	}
+
+	version := strings.TrimSpace(string(b))
+	if p.path != nil {
+		if version, err = p.path.Lookup(b); err != nil {
+			return "", err
+		}
+	}
+	if version == "" {
+		return "", fmt.Errorf("response has no version")
+	}
+	return version, nil
+}
+
+// runVersionCheck reads the version of the app of version check i and
+// records it.
+func runVersionCheck(ctx context.Context, i int, check VersionStatus, p *versionProbe) {
+	var version string
+	err := retry(ctx, check.Retries, check.Timeout.Duration, func(ctx context.Context) error {
+		var err error
+		version, err = p.version(ctx)
+		return err
+	})
+	if ctx.Err() != nil {
+		return
+	}
+
+	mu.Lock()
+	previous := versionData[i]
+	versionData[i].LastChecked = time.Now()
+	if err != nil {
+		versionData[i].Status = "Fail"
+		versionData[i].Error = err.Error()
+	} else {
+		versionData[i].Status = "OK"
+		versionData[i].Error = ""
+		versionData[i].Version = version
+	}
+	mu.Unlock()
+
+	log := data.Log.WithField("region", check.Region).WithField("environment", check.Environment).WithField("app", check.App)
+	switch {
+	case err != nil && previous.Status != "Fail":
+		log.WithField("error", err).Warn("Version check failed")
+	case err == nil && previous.Status == "Fail":
+		log.Info("Version check recovered")
+	}
+	if err == nil && previous.Version != "" && previous.Version != version {
+		log.WithField("previous", previous.Version).WithField("version", version).Info("App reports a new version")
+	}
+}
+
+// GetVersionStatus returns the results of the version checks.
+func GetVersionStatus() []VersionStatus {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return append([]VersionStatus(nil), versionData...)
+}
+
+// VersionDrift compares the version an app reports about itself with the
+// version recorded for it.
+type VersionDrift struct {
+	Region      string `json:"region"`
+	Environment string `json:"environment"`
+	App         string `json:"app"`
+	// Expected is the recorded version, empty if the app is not recorded.
+	Expected string `json:"expected"`
+	// Actual is the version the app last reported.
+	Actual string `json:"actual"`
+	// Drift is set when the app reported a version other than Expected.
+	Drift bool `json:"drift"`
+	// Status is that of the version check: Unknown, OK or Fail.
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	LastChecked time.Time `json:"lastChecked"`
+}
+
+// GetVersionDrift compares the results of the version checks with the apps
+// recorded in regions, keyed by name.
+func GetVersionDrift(regions map[string]data.Region) []VersionDrift {
+	checks := GetVersionStatus()
+	drift := make([]VersionDrift, 0, len(checks))
+	for _, check := range checks {
+		d := VersionDrift{
+			Region:      check.Region,
+			Environment: check.Environment,
+			App:         check.App,
+			Actual:      check.Version,
+			Status:      check.Status,
+			Error:       check.Error,
+			LastChecked: check.LastChecked,
+		}
+		if app, ok := regions[check.Region].Environments[check.Environment].Apps[check.App]; ok {
+			d.Expected = app.Version
+		}
+		d.Drift = check.Version != "" && !sameVersion(d.Expected, check.Version)
+		drift = append(drift, d)
+	}
+	return drift
+}
+
+// sameVersion reports whether two versions are equal, ignoring a leading v
+// on either.
+func sameVersion(a, b string) bool {
+	return strings.TrimPrefix(a, "v") == strings.TrimPrefix(b, "v")
+}
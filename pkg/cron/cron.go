@@ -0,0 +1,149 @@
+// Package cron parses the five-field cron expressions used to schedule
+// recurring freeze windows.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression: minute, hour, day of month, month and
+// day of week.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// A day of month or week given as * does not restrict the day when the
+	// other one is given.
+	domAny, dowAny bool
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// Parse parses a cron expression such as "0 18 * * FRI" or a macro such as
+// @daily. Fields may be *, a value, a range a-b, a list of those, and take a
+// /step. Months and days of week may be given by their three letter names;
+// Sunday is 0 or 7.
+func Parse(expr string) (Schedule, error) {
+	if macro, ok := macros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	var s Schedule
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return Schedule{}, err
+	}
+	if s.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return Schedule{}, err
+	}
+	if s.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return Schedule{}, err
+	}
+	if s.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return Schedule{}, err
+	}
+	if s.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return Schedule{}, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return s, nil
+}
+
+// parseField returns the set of values a field matches as a bit set.
+func parseField(field string, min, max int, names []string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in cron field %q", field)
+			}
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseValue(from, min, max, names); err != nil {
+				return 0, fmt.Errorf("cron field %q: %w", field, err)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseValue(to, min, max, names); err != nil {
+					return 0, fmt.Errorf("cron field %q: %w", field, err)
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("cron field %q: range %d-%d is reversed", field, lo, hi)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func parseValue(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("%q is not between %d and %d", s, min, max)
+	}
+	return v, nil
+}
+
+// Matches reports whether s fires in the minute of t.
+func (s Schedule) Matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Covers reports whether t falls in a window of length d that starts at a
+// time s fires at.
+func (s Schedule) Covers(t time.Time, d time.Duration) bool {
+	start := t.Truncate(time.Minute)
+	for earliest := t.Add(-d); start.After(earliest); start = start.Add(-time.Minute) {
+		if s.Matches(start) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"10-5 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * FOO *",
+		"1,,2 * * * *",
+		"@sometimes",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Fatalf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		expr string
+		time string
+		want bool
+	}{
+		{"* * * * *", "2026-10-16 13:37", true},
+		{"30 18 * * *", "2026-10-16 18:30", true},
+		{"30 18 * * *", "2026-10-16 18:31", false},
+		{"*/15 * * * *", "2026-10-16 10:45", true},
+		{"*/15 * * * *", "2026-10-16 10:50", false},
+		{"5/20 * * * *", "2026-10-16 10:45", true},
+		{"5/20 * * * *", "2026-10-16 10:55", false},
+		{"1-10/3 * * * *", "2026-10-16 10:07", true},
+		{"1-10/3 * * * *", "2026-10-16 10:10", true},
+		{"1-10/3 * * * *", "2026-10-16 10:09", false},
+		{"0 9-17 * * *", "2026-10-16 17:00", true},
+		{"0 9-17 * * *", "2026-10-16 18:00", false},
+		{"0 0,12 * * *", "2026-10-16 12:00", true},
+		{"0 0 * JAN-MAR *", "2026-02-13 00:00", true},
+		{"0 0 * JAN-MAR *", "2026-10-16 00:00", false},
+		{"0 0 31 * *", "2026-10-31 00:00", true},
+		{"0 0 1 * *", "2026-10-16 00:00", false},
+		// Days of week by name or number; Sunday is 0 or 7
+		{"0 0 * * fri", "2026-10-16 00:00", true},
+		{"0 0 * * 5", "2026-10-16 00:00", true},
+		{"0 0 * * 0", "2026-10-18 00:00", true},
+		{"0 0 * * 7", "2026-10-18 00:00", true},
+		{"0 0 * * 5-7", "2026-10-18 00:00", true},
+		{"0 0 * * MON-FRI", "2026-10-18 00:00", false},
+		// With both days restricted, either one matches
+		{"0 0 13 * FRI", "2026-11-13 00:00", true},
+		{"0 0 13 * FRI", "2026-10-16 00:00", true},
+		{"0 0 13 * FRI", "2026-10-13 00:00", true},
+		{"0 0 13 * FRI", "2026-10-14 00:00", false},
+		// With one of them *, only the other one restricts the day
+		{"0 0 13 * *", "2026-10-16 00:00", false},
+		{"0 0 * * FRI", "2026-10-13 00:00", false},
+		// A stepped * still restricts the day
+		{"0 0 */10 * FRI", "2026-10-16 00:00", true},
+		{"0 0 */10 * FRI", "2026-10-21 00:00", true},
+		{"0 0 */10 * FRI", "2026-10-20 00:00", false},
+		{"@daily", "2026-10-16 00:00", true},
+		{"@weekly", "2026-10-18 00:00", true},
+		{"@weekly", "2026-10-16 00:00", false},
+		{"@yearly", "2026-01-01 00:00", true},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		if got := s.Matches(date(tt.time)); got != tt.want {
+			t.Fatalf("%q Matches(%s) = %v, want %v", tt.expr, tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	// A weekend freeze from Friday 18:00 to Monday 09:00
+	weekend, err := Parse("0 18 * * FRI")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		time string
+		want bool
+	}{
+		{"2026-10-16 17:59", false},
+		{"2026-10-16 18:00", true},
+		{"2026-10-18 12:00", true},
+		{"2026-10-19 08:59", true},
+		{"2026-10-19 09:00", false},
+		{"2026-10-21 12:00", false},
+	}
+	for _, tt := range tests {
+		if got := weekend.Covers(date(tt.time), 63*time.Hour); got != tt.want {
+			t.Fatalf("Covers(%s) = %v, want %v", tt.time, got, tt.want)
+		}
+	}
+
+	// The seconds of t do not move the start of a window
+	if !weekend.Covers(date("2026-10-16 18:00").Add(59*time.Second), time.Minute) {
+		t.Fatal("window of a minute does not cover its last second")
+	}
+	if weekend.Covers(date("2026-10-16 18:01"), time.Minute) {
+		t.Fatal("window of a minute covers the next minute")
+	}
+	if weekend.Covers(date("2026-10-16 18:00"), 0) {
+		t.Fatal("empty window covers its start")
+	}
+}
@@ -0,0 +1,76 @@
+package data
+
+import "errors"
+
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+const (
+	KindRegion      = "region"
+	KindEnvironment = "environment"
+	KindApp         = "app"
+)
+
+// Change describes a region, environment or app created, updated or deleted
+// by a committed update.
+type Change struct {
+	Revision    uint64 `json:"revision"`
+	Action      string `json:"action"`
+	Kind        string `json:"kind"`
+	Region      string `json:"region"`
+	Environment string `json:"environment,omitempty"`
+	App         string `json:"app,omitempty"`
+	// Object is the Region, Environment or App as committed. It is nil for
+	// deletions.
+	Object interface{} `json:"object,omitempty"`
+}
+
+// ChangeFunc is called with the changes of every committed update, in commit
+// order. It is called while the store is locked for writing and must not
+// block or use the store.
+type ChangeFunc func(changes []Change)
+
+// changeLog collects the changes made in a transaction.
+type changeLog struct {
+	changes []Change
+}
+
+func (l *changeLog) changed(action, kind, region, environment, app string) {
+	l.changes = append(l.changes, Change{Action: action, Kind: kind, Region: region, Environment: environment, App: app})
+}
+
+// resolve stamps the collected changes with rev and loads the committed
+// objects from tx. Changes to objects that were deleted later in the same
+// transaction are dropped.
+func (l *changeLog) resolve(tx Tx, rev uint64) ([]Change, error) {
+	changes := make([]Change, 0, len(l.changes))
+	for _, c := range l.changes {
+		c.Revision = rev
+		if c.Action != ChangeDeleted {
+			var err error
+			switch c.Kind {
+			case KindRegion:
+				c.Object, err = tx.GetRegion(c.Region)
+			case KindEnvironment:
+				c.Object, err = tx.GetEnvironment(c.Region, c.Environment)
+			case KindApp:
+				c.Object, err = tx.GetApp(c.Region, c.Environment, c.App)
+			}
+			if isNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrRegionNotFound) || errors.Is(err, ErrEnvironmentNotFound) || errors.Is(err, ErrAppNotFound)
+}
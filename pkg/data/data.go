@@ -2,33 +2,185 @@ package data
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
-	"sync"
 
 	"github.com/sirupsen/logrus"
 )
 
-var GlobalData = Data{Regions: make(map[string]Region)}
-var Mutex = &sync.RWMutex{}
 var Log = logrus.New()
-var DataFilePath string
-var BackupFilePath string
 
-func LoadData() error {
-	if err := loadDataFromFile(DataFilePath); err != nil {
-		Log.WithField("filePath", DataFilePath).Warn("Failed to load data from primary file. Attempting to load from backup.")
+// DefaultCompactEvery is the number of journal records after which a
+// JSONStore folds its journal into a new snapshot.
+const DefaultCompactEvery = 1000
+
+// JSONStore is a Store that persists all data as a JSON snapshot file, with a
+// second copy kept in a backup file. Every committed update is appended to a
+// journal file next to the snapshot, and the journal is periodically
+// compacted into a new snapshot.
+type JSONStore struct {
+	*MemoryStore
+	FilePath        string
+	BackupFilePath  string
+	JournalFilePath string
+	CompactEvery    int
+
+	journal     journalFile
+	seq         uint64 // sequence number of the last journal record written
+	snapshotSeq uint64 // sequence number included in the last snapshot
+}
 
-		// If the primary load fails, try loading from the backup file
-		if err := loadDataFromFile(BackupFilePath); err != nil {
-			Log.WithField("filePath", BackupFilePath).Error("Failed to load data from backup file")
-			return err
+func NewJSONStore(filePath, backupFilePath string) *JSONStore {
+	s := &JSONStore{
+		MemoryStore:     NewMemoryStore(),
+		FilePath:        filePath,
+		BackupFilePath:  backupFilePath,
+		JournalFilePath: filePath + ".journal",
+		CompactEvery:    DefaultCompactEvery,
+	}
+	s.MemoryStore.commit = s.append
+	return s
+}
+
+// Load reads the newest valid copy of the snapshot from the primary and
+// backup files, then replays any journal records written since that
+// snapshot.
+func (s *JSONStore) Load() error {
+	snap, err := loadNewestSnapshot(s.FilePath, s.BackupFilePath)
+	if err != nil {
+		return err
+	}
+
+	records, err := readJournal(s.JournalFilePath)
+	if err != nil {
+		Log.WithField("filePath", s.JournalFilePath).Error("Failed to read journal")
+		return err
+	}
+
+	d := snap.Data
+	if d.Regions == nil {
+		d.Regions = make(map[string]Region)
+	}
+	seq := snap.JournalSeq
+	replayed := 0
+	for _, rec := range records {
+		if rec.Seq <= seq {
+			continue
+		}
+		tx := &memTx{data: &d}
+		for _, op := range rec.Ops {
+			if err := op.apply(tx); err != nil {
+				return fmt.Errorf("replaying journal record %d: %w", rec.Seq, err)
+			}
+		}
+		seq = rec.Seq
+		replayed++
+	}
+
+	s.Replace(d)
+	s.seq = seq
+	s.snapshotSeq = snap.JournalSeq
+
+	Log.WithField("replayed", replayed).Info("Successfully loaded data from file")
+
+	// Start from a fresh snapshot and an empty journal, which also drops any
+	// torn record left at the end of the old journal.
+	return s.Compact()
+}
+
+// Compact writes the current data to the snapshot files and truncates the
+// journal.
+func (s *JSONStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact(s.data)
+}
+
+func (s *JSONStore) compact(d Data) error {
+	snap := jsonSnapshot{Data: d, JournalSeq: s.seq}
+
+	// Write to the backup file first
+	if err := writeSnapshotToFile(s.BackupFilePath, snap); err != nil {
+		return err
+	}
+
+	// Write to the primary file
+	if err := writeSnapshotToFile(s.FilePath, snap); err != nil {
+		return err
+	}
+
+	if s.journal != nil {
+		s.journal.Close()
+	}
+	journal, err := os.OpenFile(s.JournalFilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		s.journal = nil
+		return err
+	}
+	s.journal = journal
+	s.snapshotSeq = s.seq
+
+	Log.WithField("filePath", s.FilePath).WithField("seq", s.seq).Debug("Compacted journal into snapshot")
+	return nil
+}
+
+// append is the commit hook of the underlying MemoryStore. It is called with
+// the store lock held.
+func (s *JSONStore) append(d Data, ops []journalOp) error {
+	if s.journal == nil {
+		// An earlier compaction or append left no journal to write to.
+		// Starting a new one snapshots the data as last committed.
+		if err := s.compact(s.data); err != nil {
+			return fmt.Errorf("journal %s is not open: %w", s.JournalFilePath, err)
+		}
+		Log.WithField("filePath", s.JournalFilePath).Info("Reopened journal")
+	}
+
+	rec := journalRecord{Seq: s.seq + 1, Ops: ops}
+	if err := appendJournal(s.journal, rec); err != nil {
+		if errors.Is(err, errJournalTorn) {
+			// Records appended after the partial one would be lost on
+			// replay, so start a new journal from a snapshot of the data
+			// before this update. If that fails too, the next update
+			// tries again.
+			s.journal.Close()
+			s.journal = nil
+			if cerr := s.compact(s.data); cerr != nil {
+				Log.WithField("error", err).WithField("compactError", cerr).Error("Journal is damaged and compacting it failed")
+			} else {
+				Log.WithField("error", err).Warn("Journal was damaged; compacted it into a new snapshot")
+			}
 		}
+		return err
 	}
+	s.seq = rec.Seq
 
-	Log.Info("Successfully loaded data from file")
+	if s.CompactEvery > 0 && s.seq-s.snapshotSeq >= uint64(s.CompactEvery) {
+		if err := s.compact(d); err != nil {
+			// The record is already durable in the journal, so the update
+			// itself has succeeded.
+			Log.WithField("error", err).Error("Failed to compact journal")
+		}
+	}
 	return nil
 }
 
+// Close compacts the journal and closes it.
+func (s *JSONStore) Close() error {
+	if err := s.Compact(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.journal.Close()
+	s.journal = nil
+	return err
+}
+
 func CreateFileIfNotExists(filePath string) error {
 	_, err := os.Stat(filePath)
 	if os.IsNotExist(err) {
@@ -58,35 +210,3 @@ func CreateFileIfNotExists(filePath string) error {
 	}
 	return nil
 }
-
-func loadDataFromFile(filePath string) error {
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return err
-	}
-
-	if err := json.Unmarshal(data, &GlobalData); err != nil {
-		return err
-	}
-	return nil
-}
-
-func SaveData(filePath string) error {
-	data, err := json.Marshal(GlobalData)
-	if err != nil {
-		return err
-	}
-
-	// Write to the backup file first
-	if err = os.WriteFile(BackupFilePath, data, 0644); err != nil {
-		return err
-	}
-
-	// Write to the primary file
-	if err = os.WriteFile(filePath, data, 0644); err != nil {
-		return err
-	}
-
-	Log.WithField("filePath", filePath).Debug("Successfully saved data to file")
-	return nil
-}
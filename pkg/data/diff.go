@@ -0,0 +1,150 @@
+package data
+
+import (
+	"sort"
+	"vhub/pkg/semver"
+)
+
+// Diff reports how the apps of one set of environments differ from another.
+type Diff struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// MissingInTo lists apps deployed on the From side only.
+	MissingInTo []DiffEntry `json:"missingInTo"`
+	// MissingInFrom lists apps deployed on the To side only.
+	MissingInFrom []DiffEntry `json:"missingInFrom"`
+	// Differing lists apps deployed on both sides with a different version
+	// or route.
+	Differing []DiffEntry `json:"differing"`
+	// Identical is the number of apps deployed identically on both sides.
+	Identical int `json:"identical"`
+}
+
+// DiffEntry describes one app in a Diff.
+type DiffEntry struct {
+	App             string `json:"app"`
+	FromEnvironment string `json:"fromEnvironment"`
+	ToEnvironment   string `json:"toEnvironment"`
+	FromVersion     string `json:"fromVersion,omitempty"`
+	ToVersion       string `json:"toVersion,omitempty"`
+	FromRoute       string `json:"fromRoute,omitempty"`
+	ToRoute         string `json:"toRoute,omitempty"`
+	// Drift is the most significant SemVer part in which the versions
+	// differ (major, minor, patch or prerelease), or "unknown" if either
+	// version is not SemVer. It is empty if the versions have equal
+	// precedence.
+	Drift string `json:"drift,omitempty"`
+	// Behind is true when the To version has lower precedence than the
+	// From version.
+	Behind bool `json:"behind,omitempty"`
+}
+
+const DriftUnknown = "unknown"
+
+// DiffEnvironments compares the apps of two environments. fromKey and toKey
+// name the environments in the report.
+func DiffEnvironments(fromKey string, from Environment, toKey string, to Environment) Diff {
+	diff := Diff{
+		From:          fromKey,
+		To:            toKey,
+		MissingInTo:   []DiffEntry{},
+		MissingInFrom: []DiffEntry{},
+		Differing:     []DiffEntry{},
+	}
+	diff.add(fromKey, from, toKey, to)
+	diff.sort()
+	return diff
+}
+
+// DiffRegions compares every environment of one region with the environment
+// of the same name in another. Environments that exist in only one region
+// count as empty on the other side.
+func DiffRegions(from Region, to Region) Diff {
+	diff := Diff{
+		From:          from.Name,
+		To:            to.Name,
+		MissingInTo:   []DiffEntry{},
+		MissingInFrom: []DiffEntry{},
+		Differing:     []DiffEntry{},
+	}
+
+	names := make(map[string]bool)
+	for name := range from.Environments {
+		names[name] = true
+	}
+	for name := range to.Environments {
+		names[name] = true
+	}
+	for name := range names {
+		diff.add(MatrixKey(from.Name, name), from.Environments[name], MatrixKey(to.Name, name), to.Environments[name])
+	}
+	diff.sort()
+	return diff
+}
+
+func (d *Diff) add(fromKey string, from Environment, toKey string, to Environment) {
+	for name, fromApp := range from.Apps {
+		entry := DiffEntry{
+			App:             name,
+			FromEnvironment: fromKey,
+			ToEnvironment:   toKey,
+			FromVersion:     fromApp.Version,
+			FromRoute:       fromApp.Route,
+		}
+
+		toApp, ok := to.Apps[name]
+		if !ok {
+			d.MissingInTo = append(d.MissingInTo, entry)
+			continue
+		}
+		entry.ToVersion = toApp.Version
+		entry.ToRoute = toApp.Route
+
+		if fromApp.Version == toApp.Version && fromApp.Route == toApp.Route {
+			d.Identical++
+			continue
+		}
+		if fromApp.Version != toApp.Version {
+			entry.Drift, entry.Behind = versionDrift(fromApp.Version, toApp.Version)
+		}
+		d.Differing = append(d.Differing, entry)
+	}
+
+	for name, toApp := range to.Apps {
+		if _, ok := from.Apps[name]; ok {
+			continue
+		}
+		d.MissingInFrom = append(d.MissingInFrom, DiffEntry{
+			App:             name,
+			FromEnvironment: fromKey,
+			ToEnvironment:   toKey,
+			ToVersion:       toApp.Version,
+			ToRoute:         toApp.Route,
+		})
+	}
+}
+
+// versionDrift classifies the difference between two versions and reports
+// whether to is behind from.
+func versionDrift(from, to string) (string, bool) {
+	fromVersion, err := semver.Parse(from)
+	if err != nil {
+		return DriftUnknown, false
+	}
+	toVersion, err := semver.Parse(to)
+	if err != nil {
+		return DriftUnknown, false
+	}
+	return string(semver.Diff(fromVersion, toVersion)), toVersion.Compare(fromVersion) < 0
+}
+
+func (d *Diff) sort() {
+	for _, entries := range [][]DiffEntry{d.MissingInTo, d.MissingInFrom, d.Differing} {
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].FromEnvironment != entries[j].FromEnvironment {
+				return entries[i].FromEnvironment < entries[j].FromEnvironment
+			}
+			return entries[i].App < entries[j].App
+		})
+	}
+}
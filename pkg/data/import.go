@@ -0,0 +1,77 @@
+package data
+
+// ImportJSONFile copies every region, environment and app, along with their
+// history, locks, the webhooks and the API tokens, from a file in the
+// data.json format into s in a single transaction. If s already holds any
+// regions, webhooks or tokens, the import is skipped with a warning, so a
+// server restarted with the same -import flag comes up unchanged.
+func ImportJSONFile(s Store, filePath string) error {
+	d, err := loadDataFromFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	skipped := false
+	err = s.Update(func(tx Tx) error {
+		empty, err := isEmpty(tx)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			skipped = true
+			return nil
+		}
+		for _, region := range d.Regions {
+			if err := tx.CreateRegion(region); err != nil {
+				return err
+			}
+		}
+		for _, lock := range d.Locks {
+			if err := tx.CreateLock(lock); err != nil {
+				return err
+			}
+		}
+		for _, token := range d.Tokens {
+			if err := tx.CreateToken(token); err != nil {
+				return err
+			}
+		}
+		for _, hook := range d.Webhooks {
+			if err := tx.CreateWebhook(hook); err != nil {
+				return err
+			}
+		}
+		for _, entries := range d.History {
+			for _, entry := range entries {
+				if err := tx.AppendHistory(entry); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if skipped {
+		Log.WithField("filePath", filePath).Warn("Store is not empty, skipping import")
+		return nil
+	}
+
+	Log.WithField("filePath", filePath).WithField("regions", len(d.Regions)).Info("Imported data from JSON file")
+	return nil
+}
+
+// isEmpty reports whether tx holds no regions, webhooks or tokens.
+func isEmpty(tx Tx) (bool, error) {
+	regions, err := tx.ListRegions()
+	if err != nil || len(regions) > 0 {
+		return false, err
+	}
+	hooks, err := tx.ListWebhooks()
+	if err != nil || len(hooks) > 0 {
+		return false, err
+	}
+	tokens, err := tx.ListTokens()
+	return len(tokens) == 0, err
+}
@@ -0,0 +1,189 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// journalOp is a single mutation recorded by a read-write transaction.
+type journalOp struct {
+	Op          string        `json:"op"`
+	Region      string        `json:"region,omitempty"`
+	Environment string        `json:"environment,omitempty"`
+	Name        string        `json:"name,omitempty"`
+	RegionData  *Region       `json:"regionData,omitempty"`
+	EnvData     *Environment  `json:"environmentData,omitempty"`
+	AppData     *App          `json:"appData,omitempty"`
+	History     *HistoryEntry `json:"history,omitempty"`
+	Stages      []string      `json:"stages,omitempty"`
+	Webhook     *Webhook      `json:"webhook,omitempty"`
+	Token       *Token        `json:"token,omitempty"`
+	Lock        *Lock         `json:"lock,omitempty"`
+}
+
+// journalRecord is one line of the journal file: every mutation made by a
+// committed transaction, tagged with a sequence number.
+type journalRecord struct {
+	Seq uint64      `json:"seq"`
+	Ops []journalOp `json:"ops"`
+}
+
+const (
+	opCreateRegion      = "createRegion"
+	opUpdateRegion      = "updateRegion"
+	opDeleteRegion      = "deleteRegion"
+	opSetPipeline       = "setPipeline"
+	opCreateEnvironment = "createEnvironment"
+	opUpdateEnvironment = "updateEnvironment"
+	opDeleteEnvironment = "deleteEnvironment"
+	opCreateApp         = "createApp"
+	opUpdateApp         = "updateApp"
+	opDeleteApp         = "deleteApp"
+	opAppendHistory     = "appendHistory"
+	opCreateWebhook     = "createWebhook"
+	opUpdateWebhook     = "updateWebhook"
+	opDeleteWebhook     = "deleteWebhook"
+	opCreateToken       = "createToken"
+	opDeleteToken       = "deleteToken"
+	opCreateLock        = "createLock"
+	opRemoveLock        = "removeLock"
+	// opDeleteLock is replayed from journals written before lifted locks
+	// were kept.
+	opDeleteLock = "deleteLock"
+)
+
+// apply replays op against tx.
+func (op journalOp) apply(tx Tx) error {
+	switch op.Op {
+	case opCreateRegion:
+		return tx.CreateRegion(*op.RegionData)
+	case opUpdateRegion:
+		return tx.UpdateRegion(op.Name, *op.RegionData)
+	case opDeleteRegion:
+		return tx.DeleteRegion(op.Name)
+	case opSetPipeline:
+		return tx.SetPipeline(op.Name, op.Stages)
+	case opCreateEnvironment:
+		return tx.CreateEnvironment(op.Region, *op.EnvData)
+	case opUpdateEnvironment:
+		return tx.UpdateEnvironment(op.Region, op.Name, *op.EnvData)
+	case opDeleteEnvironment:
+		return tx.DeleteEnvironment(op.Region, op.Name)
+	case opCreateApp:
+		return tx.CreateApp(op.Region, op.Environment, *op.AppData)
+	case opUpdateApp:
+		return tx.UpdateApp(op.Region, op.Environment, op.Name, *op.AppData)
+	case opDeleteApp:
+		return tx.DeleteApp(op.Region, op.Environment, op.Name)
+	case opAppendHistory:
+		return tx.AppendHistory(*op.History)
+	case opCreateWebhook:
+		return tx.CreateWebhook(*op.Webhook)
+	case opUpdateWebhook:
+		return tx.UpdateWebhook(op.Name, *op.Webhook)
+	case opDeleteWebhook:
+		return tx.DeleteWebhook(op.Name)
+	case opCreateToken:
+		return tx.CreateToken(*op.Token)
+	case opDeleteToken:
+		return tx.DeleteToken(op.Name)
+	case opCreateLock:
+		return tx.CreateLock(*op.Lock)
+	case opRemoveLock:
+		return tx.RemoveLock(op.Name, op.Lock.RemovedBy, *op.Lock.RemovedAt)
+	case opDeleteLock:
+		return tx.RemoveLock(op.Name, "", time.Time{})
+	}
+	return fmt.Errorf("unknown journal op %q", op.Op)
+}
+
+// errJournalTorn is wrapped in the error of an append that failed part way
+// and could not be undone, leaving a partial line at the end of the journal.
+var errJournalTorn = errors.New("journal left with a partial record")
+
+// journalFile is the file a journal is appended to.
+type journalFile interface {
+	io.WriteCloser
+	Sync() error
+	Truncate(size int64) error
+	Stat() (os.FileInfo, error)
+}
+
+// appendJournal writes rec as a single line to f and fsyncs it. If either
+// fails, f is truncated back to its previous size, so that no partial line is
+// left for the next record to be appended to.
+func appendJournal(f journalFile, rec journalRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(append(line, '\n')); err == nil {
+		if err = f.Sync(); err == nil {
+			return nil
+		}
+	}
+	if terr := f.Truncate(info.Size()); terr != nil {
+		return fmt.Errorf("%w: %v; truncating it failed: %v", errJournalTorn, err, terr)
+	}
+	if serr := f.Sync(); serr != nil {
+		return fmt.Errorf("%w: %v; syncing it failed: %v", errJournalTorn, err, serr)
+	}
+	return err
+}
+
+// readJournal returns the records in the journal at filePath. A missing file
+// is treated as empty. An undecodable last line is ignored, as expected when
+// the process died part way through an append, but one followed by more
+// records is an error: dropping them would lose committed updates.
+func readJournal(filePath string) ([]journalRecord, error) {
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []journalRecord
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				Log.WithField("filePath", filePath).Warn("Ignoring incomplete record at end of journal")
+			}
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if _, perr := reader.Peek(1); perr == io.EOF {
+				Log.WithField("filePath", filePath).WithField("records", len(records)).Warn("Ignoring corrupt record at end of journal")
+				return records, nil
+			}
+			return records, fmt.Errorf("journal %s: record after seq %d is corrupt and followed by more records: %w", filePath, lastSeq(records), err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func lastSeq(records []journalRecord) uint64 {
+	if len(records) == 0 {
+		return 0
+	}
+	return records[len(records)-1].Seq
+}
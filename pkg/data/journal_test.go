@@ -0,0 +1,244 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingFile writes only part of what it is given and then fails, like a
+// disk filling up. Truncate fails too if truncateErr is set.
+type failingFile struct {
+	*os.File
+	truncateErr error
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.truncateErr != nil {
+		return f.truncateErr
+	}
+	return f.File.Truncate(size)
+}
+
+func openJournal(t *testing.T) (*os.File, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.json.journal")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f, path
+}
+
+func record(seq uint64, region string) journalRecord {
+	return journalRecord{Seq: seq, Ops: []journalOp{{Op: opCreateRegion, RegionData: &Region{Name: region}}}}
+}
+
+func TestAppendJournalUndoesFailedWrite(t *testing.T) {
+	f, path := openJournal(t)
+
+	if err := appendJournal(f, record(1, "amer")); err != nil {
+		t.Fatal(err)
+	}
+	err := appendJournal(&failingFile{File: f}, record(2, "emea"))
+	if err == nil || errors.Is(err, errJournalTorn) {
+		t.Fatalf("failed append returned %v, want a plain error", err)
+	}
+	if err := appendJournal(f, record(2, "apac")); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := readJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Ops[0].RegionData.Name != "amer" || records[1].Ops[0].RegionData.Name != "apac" {
+		t.Fatalf("records = %+v, want amer then apac", records)
+	}
+}
+
+func TestAppendJournalReportsTornRecord(t *testing.T) {
+	f, _ := openJournal(t)
+
+	err := appendJournal(&failingFile{File: f, truncateErr: errors.New("read-only file system")}, record(1, "amer"))
+	if !errors.Is(err, errJournalTorn) {
+		t.Fatalf("append = %v, want errJournalTorn", err)
+	}
+}
+
+func TestReadJournal(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []uint64
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"complete", "{\"seq\":1,\"ops\":[]}\n{\"seq\":2,\"ops\":[]}\n", []uint64{1, 2}, false},
+		{"torn tail", "{\"seq\":1,\"ops\":[]}\n{\"seq\":2,\"op", []uint64{1}, false},
+		{"corrupt last line", "{\"seq\":1,\"ops\":[]}\n{\"seq\":2,\"op\n", []uint64{1}, false},
+		{"corrupt line before others", "{\"seq\":1,\"ops\":[]}\n{\"seq\":2,\"op{\"seq\":3,\"ops\":[]}\n{\"seq\":4,\"ops\":[]}\n", []uint64{1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "journal")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			records, err := readJournal(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readJournal error = %v, want error %v", err, tt.wantErr)
+			}
+			var seqs []uint64
+			for _, rec := range records {
+				seqs = append(seqs, rec.Seq)
+			}
+			if len(seqs) != len(tt.want) {
+				t.Fatalf("seqs = %v, want %v", seqs, tt.want)
+			}
+			for i := range seqs {
+				if seqs[i] != tt.want[i] {
+					t.Fatalf("seqs = %v, want %v", seqs, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadJournalMissingFile(t *testing.T) {
+	records, err := readJournal(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || records != nil {
+		t.Fatalf("readJournal = %v, %v, want nothing", records, err)
+	}
+}
+
+func newTestJSONStore(t *testing.T, dir string) *JSONStore {
+	t.Helper()
+	path := filepath.Join(dir, "data.json")
+	for _, p := range []string{path, path + ".backup"} {
+		if err := CreateFileIfNotExists(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewJSONStore(path, path+".backup")
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJSONStoreReplaysJournal(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestJSONStore(t, dir)
+	if err := s.CreateRegion(Region{Name: "amer"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateEnvironment("amer", Environment{Name: "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateApp("amer", "prod", App{Name: "orders", Version: "1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crash: the updates are only in the journal, and the last
+	// append was cut short.
+	s.journal.Write([]byte(`{"seq":4,"ops":[{"op":"deleteApp"`))
+	s.journal.Close()
+
+	reloaded := newTestJSONStore(t, dir)
+	defer reloaded.Close()
+	app, err := reloaded.GetApp("amer", "prod", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Version != "1.0.0" {
+		t.Fatalf("version = %q, want 1.0.0", app.Version)
+	}
+}
+
+func TestJSONStoreCompactsJournal(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestJSONStore(t, dir)
+	s.CompactEvery = 2
+	for _, name := range []string{"amer", "emea", "apac"} {
+		if err := s.CreateRegion(Region{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := readJournal(s.JournalFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Seq != 3 {
+		t.Fatalf("journal after compaction = %+v, want only seq 3", records)
+	}
+	s.journal.Close()
+
+	reloaded := newTestJSONStore(t, dir)
+	defer reloaded.Close()
+	regions, err := reloaded.ListRegions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) != 3 {
+		t.Fatalf("regions = %+v, want 3", regions)
+	}
+}
+
+func TestJSONStoreRecoversFromTornJournal(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestJSONStore(t, dir)
+	if err := s.CreateRegion(Region{Name: "amer"}); err != nil {
+		t.Fatal(err)
+	}
+
+	file := s.journal.(*os.File)
+	s.journal = &failingFile{File: file, truncateErr: errors.New("read-only file system")}
+	if err := s.CreateRegion(Region{Name: "emea"}); !errors.Is(err, errJournalTorn) {
+		t.Fatalf("update with a torn journal = %v, want errJournalTorn", err)
+	}
+
+	// The failed update compacted the journal, so the next one goes through
+	// without waiting for a compaction interval
+	if err := s.CreateRegion(Region{Name: "apac"}); err != nil {
+		t.Fatal(err)
+	}
+	s.journal.Close()
+
+	reloaded := newTestJSONStore(t, dir)
+	defer reloaded.Close()
+	regions, err := reloaded.ListRegions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) != 2 || regions[0].Name != "amer" || regions[1].Name != "apac" {
+		t.Fatalf("regions = %+v, want amer and apac", regions)
+	}
+}
+
+func TestJSONStoreReopensJournal(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestJSONStore(t, dir)
+	// Simulate a compaction that could not reopen the journal
+	s.journal.Close()
+	s.journal = nil
+
+	if err := s.CreateRegion(Region{Name: "amer"}); err != nil {
+		t.Fatal(err)
+	}
+	s.journal.Close()
+
+	reloaded := newTestJSONStore(t, dir)
+	defer reloaded.Close()
+	if _, err := reloaded.GetRegion("amer"); err != nil {
+		t.Fatal(err)
+	}
+}
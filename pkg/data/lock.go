@@ -0,0 +1,158 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"vhub/pkg/cron"
+)
+
+var (
+	ErrLockNotFound = errors.New("lock not found")
+	ErrLockExists   = errors.New("lock already exists")
+	ErrLockRemoved  = errors.New("lock already removed")
+	ErrInvalidLock  = errors.New("invalid lock")
+)
+
+// maxLockDuration bounds the length of recurring freeze windows.
+const maxLockDuration = 31 * 24 * time.Hour
+
+const (
+	LockKindManual    = "manual"
+	LockKindWindow    = "window"
+	LockKindRecurring = "recurring"
+)
+
+// Lock stops changes to a region, or to one environment of it. A lock
+// without a schedule holds until it is removed; one with Start or End is a
+// freeze window; one with Cron is a freeze window of length Duration that
+// recurs at the times Cron matches, in TimeZone.
+type Lock struct {
+	ID     string `json:"id"`
+	Region string `json:"region"`
+	// Environment is empty for a lock on the whole region.
+	Environment string `json:"environment,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	// Start and End bound a one-off window; either may be left open.
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+	// Cron is a five-field cron expression, Duration a Go duration such as
+	// 48h and TimeZone an IANA zone name, UTC if empty.
+	Cron      string    `json:"cron,omitempty"`
+	Duration  string    `json:"duration,omitempty"`
+	TimeZone  string    `json:"timeZone,omitempty"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	// RemovedBy and RemovedAt record who lifted the lock and when. Lifted
+	// locks are kept, but no longer in force.
+	RemovedBy string     `json:"removedBy,omitempty"`
+	RemovedAt *time.Time `json:"removedAt,omitempty"`
+}
+
+// Kind returns LockKindManual, LockKindWindow or LockKindRecurring.
+func (l Lock) Kind() string {
+	switch {
+	case l.Cron != "":
+		return LockKindRecurring
+	case l.Start != nil || l.End != nil:
+		return LockKindWindow
+	}
+	return LockKindManual
+}
+
+// Validate checks that l names a region and has a consistent schedule.
+func (l Lock) Validate() error {
+	if l.ID == "" {
+		return fmt.Errorf("%w: id is required", ErrInvalidLock)
+	}
+	if l.Region == "" {
+		return fmt.Errorf("%w: region is required", ErrInvalidLock)
+	}
+	if l.Start != nil && l.End != nil && !l.End.After(*l.Start) {
+		return fmt.Errorf("%w: end must be after start", ErrInvalidLock)
+	}
+	if _, err := time.LoadLocation(l.TimeZone); err != nil {
+		return fmt.Errorf("%w: unknown time zone %q", ErrInvalidLock, l.TimeZone)
+	}
+
+	if l.Cron == "" {
+		if l.Duration != "" {
+			return fmt.Errorf("%w: duration needs a cron schedule", ErrInvalidLock)
+		}
+		return nil
+	}
+	if l.Start != nil || l.End != nil {
+		return fmt.Errorf("%w: a lock has either start and end or a cron schedule", ErrInvalidLock)
+	}
+	if _, err := cron.Parse(l.Cron); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidLock, err)
+	}
+	d, err := time.ParseDuration(l.Duration)
+	if err != nil || d < time.Minute || d > maxLockDuration {
+		return fmt.Errorf("%w: duration must be between 1m and %s", ErrInvalidLock, maxLockDuration)
+	}
+	return nil
+}
+
+// Active reports whether l stops changes at now.
+func (l Lock) Active(now time.Time) bool {
+	if l.RemovedAt != nil {
+		return false
+	}
+	switch l.Kind() {
+	case LockKindWindow:
+		return (l.Start == nil || !now.Before(*l.Start)) && (l.End == nil || now.Before(*l.End))
+	case LockKindRecurring:
+		schedule, err := cron.Parse(l.Cron)
+		if err != nil {
+			return false
+		}
+		d, err := time.ParseDuration(l.Duration)
+		if err != nil {
+			return false
+		}
+		location, err := time.LoadLocation(l.TimeZone)
+		if err != nil {
+			return false
+		}
+		return schedule.Covers(now.In(location), d)
+	}
+	return true
+}
+
+// Covers reports whether l applies to changes to environment of region. An
+// empty environment stands for the region as a whole, which any lock in it
+// covers.
+func (l Lock) Covers(region, environment string) bool {
+	return l.Region == region && (l.Environment == "" || environment == "" || l.Environment == environment)
+}
+
+// Clone returns a deep copy of l.
+func (l Lock) Clone() Lock {
+	if l.Start != nil {
+		start := *l.Start
+		l.Start = &start
+	}
+	if l.End != nil {
+		end := *l.End
+		l.End = &end
+	}
+	if l.RemovedAt != nil {
+		removed := *l.RemovedAt
+		l.RemovedAt = &removed
+	}
+	return l
+}
+
+func sortLocks(locks []Lock) {
+	sort.Slice(locks, func(i, j int) bool {
+		if locks[i].Region != locks[j].Region {
+			return locks[i].Region < locks[j].Region
+		}
+		if locks[i].Environment != locks[j].Environment {
+			return locks[i].Environment < locks[j].Environment
+		}
+		return locks[i].CreatedAt.Before(locks[j].CreatedAt)
+	})
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockActive(t *testing.T) {
+	at := func(s string) time.Time {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	ptr := func(s string) *time.Time {
+		t := at(s)
+		return &t
+	}
+	weekend := Lock{Cron: "0 18 * * FRI", Duration: "63h", TimeZone: "America/New_York"}
+
+	tests := []struct {
+		name string
+		lock Lock
+		now  string
+		want bool
+	}{
+		{"manual", Lock{}, "2026-10-16T12:00:00Z", true},
+		{"removed", Lock{RemovedAt: ptr("2026-10-16T11:00:00Z")}, "2026-10-16T12:00:00Z", false},
+		{"window at start", Lock{Start: ptr("2026-10-16T12:00:00Z"), End: ptr("2026-10-17T12:00:00Z")}, "2026-10-16T12:00:00Z", true},
+		{"window before start", Lock{Start: ptr("2026-10-16T12:00:00Z"), End: ptr("2026-10-17T12:00:00Z")}, "2026-10-16T11:59:59Z", false},
+		{"window at end", Lock{Start: ptr("2026-10-16T12:00:00Z"), End: ptr("2026-10-17T12:00:00Z")}, "2026-10-17T12:00:00Z", false},
+		{"window open at start", Lock{End: ptr("2026-10-17T12:00:00Z")}, "2000-01-01T00:00:00Z", true},
+		{"window open at end", Lock{Start: ptr("2026-10-16T12:00:00Z")}, "2100-01-01T00:00:00Z", true},
+		{"removed window", Lock{Start: ptr("2026-10-16T12:00:00Z"), RemovedAt: ptr("2026-10-16T13:00:00Z")}, "2026-10-16T14:00:00Z", false},
+		// Friday 18:00 in New York is 22:00 UTC in October
+		{"recurring before", weekend, "2026-10-16T21:59:00Z", false},
+		{"recurring start", weekend, "2026-10-16T22:00:00Z", true},
+		{"recurring end", weekend, "2026-10-19T12:59:00Z", true},
+		{"recurring after", weekend, "2026-10-19T13:00:00Z", false},
+		{"recurring invalid", Lock{Cron: "0 18 * *", Duration: "63h"}, "2026-10-16T22:00:00Z", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.lock.Active(at(tt.now)); got != tt.want {
+				t.Fatalf("Active(%s) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockValidate(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	tests := []struct {
+		name  string
+		lock  Lock
+		valid bool
+	}{
+		{"manual", Lock{ID: "1", Region: "amer"}, true},
+		{"no region", Lock{ID: "1"}, false},
+		{"window", Lock{ID: "1", Region: "amer", Start: &start, End: &end}, true},
+		{"reversed window", Lock{ID: "1", Region: "amer", Start: &end, End: &start}, false},
+		{"recurring", Lock{ID: "1", Region: "amer", Cron: "0 18 * * FRI", Duration: "63h"}, true},
+		{"recurring without duration", Lock{ID: "1", Region: "amer", Cron: "0 18 * * FRI"}, false},
+		{"recurring too long", Lock{ID: "1", Region: "amer", Cron: "@daily", Duration: "800h"}, false},
+		{"recurring window", Lock{ID: "1", Region: "amer", Cron: "@daily", Duration: "1h", Start: &start}, false},
+		{"duration without cron", Lock{ID: "1", Region: "amer", Duration: "1h"}, false},
+		{"unknown time zone", Lock{ID: "1", Region: "amer", TimeZone: "Mars/Olympus"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.lock.Validate(); (err == nil) != tt.valid {
+				t.Fatalf("Validate = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
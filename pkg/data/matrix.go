@@ -0,0 +1,136 @@
+package data
+
+import (
+	"sort"
+	"vhub/pkg/semver"
+)
+
+// Matrix is a grid of app versions: one row per app and one column per
+// region/environment pair.
+type Matrix struct {
+	Columns []MatrixColumn `json:"columns"`
+	Rows    []MatrixRow    `json:"rows"`
+}
+
+type MatrixColumn struct {
+	Key         string `json:"key"`
+	Region      string `json:"region"`
+	Environment string `json:"environment"`
+
+	stage int // position in the region's pipeline, or -1
+}
+
+type MatrixRow struct {
+	App string `json:"app"`
+	// Cells is keyed by MatrixColumn.Key. Columns where the app is not
+	// deployed have no cell.
+	Cells map[string]*MatrixCell `json:"cells"`
+}
+
+type MatrixCell struct {
+	Version string `json:"version"`
+	Route   string `json:"route"`
+	Date    string `json:"date"`
+}
+
+// MatrixFilter restricts a matrix to the listed apps, regions and
+// environments. An empty list matches everything.
+type MatrixFilter struct {
+	Apps         []string
+	Regions      []string
+	Environments []string
+}
+
+func matches(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func stageIndex(stages []string, environment string) int {
+	for i, stage := range stages {
+		if stage == environment {
+			return i
+		}
+	}
+	return -1
+}
+
+// MatrixKey is the column key of a region/environment pair.
+func MatrixKey(region, environment string) string {
+	return region + "/" + environment
+}
+
+// BuildMatrix lays out the apps of regions as a Matrix. Columns are ordered
+// by region, then by pipeline stage with environments outside the pipeline
+// last, then by environment name. Rows are ordered by app name.
+func BuildMatrix(regions []Region, filter MatrixFilter) Matrix {
+	matrix := Matrix{Columns: []MatrixColumn{}, Rows: []MatrixRow{}}
+	rows := make(map[string]MatrixRow)
+
+	for _, region := range regions {
+		if !matches(filter.Regions, region.Name) {
+			continue
+		}
+		for envName, environment := range region.Environments {
+			if !matches(filter.Environments, envName) {
+				continue
+			}
+			key := MatrixKey(region.Name, envName)
+			matrix.Columns = append(matrix.Columns, MatrixColumn{Key: key, Region: region.Name, Environment: envName, stage: stageIndex(region.Pipeline, envName)})
+
+			for appName, app := range environment.Apps {
+				if !matches(filter.Apps, appName) {
+					continue
+				}
+				row, ok := rows[appName]
+				if !ok {
+					row = MatrixRow{App: appName, Cells: make(map[string]*MatrixCell)}
+					rows[appName] = row
+				}
+				row.Cells[key] = &MatrixCell{Version: app.Version, Route: app.Route, Date: app.Date}
+			}
+		}
+	}
+
+	sort.Slice(matrix.Columns, func(i, j int) bool {
+		a, b := matrix.Columns[i], matrix.Columns[j]
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		if a.stage != b.stage {
+			// Environments outside the pipeline (-1) sort last
+			return uint(a.stage) < uint(b.stage)
+		}
+		return a.Environment < b.Environment
+	})
+
+	for _, row := range rows {
+		matrix.Rows = append(matrix.Rows, row)
+	}
+	sort.Slice(matrix.Rows, func(i, j int) bool { return matrix.Rows[i].App < matrix.Rows[j].App })
+
+	return matrix
+}
+
+// SortByVersion orders the rows of m by the highest version precedence
+// found in any of their cells, highest first.
+func (m *Matrix) SortByVersion() {
+	highest := make(map[string]string, len(m.Rows))
+	for _, row := range m.Rows {
+		for _, cell := range row.Cells {
+			if current, ok := highest[row.App]; !ok || semver.CompareStrings(cell.Version, current) > 0 {
+				highest[row.App] = cell.Version
+			}
+		}
+	}
+	sort.SliceStable(m.Rows, func(i, j int) bool {
+		return semver.CompareStrings(highest[m.Rows[i].App], highest[m.Rows[j].App]) > 0
+	})
+}
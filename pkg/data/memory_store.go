@@ -0,0 +1,838 @@
+package data
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrReadOnlyTx = errors.New("write attempted in a read-only transaction")
+
+// MemoryStore keeps all data in memory. It is the base for the JSON file
+// store and can be used on its own in tests.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data Data
+
+	// commit is called with the new data and the mutations that produced it
+	// before an update is applied. If it returns an error the update is
+	// discarded.
+	commit func(Data, []journalOp) error
+
+	onChange []ChangeFunc
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: Data{Regions: make(map[string]Region)}}
+}
+
+func (s *MemoryStore) View(fn func(tx Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(&memTx{data: &s.data, readOnly: true})
+}
+
+func (s *MemoryStore) Update(fn func(tx Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := s.data.Clone()
+	tx := &memTx{data: &next}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	var changes []Change
+	if len(s.onChange) > 0 && len(tx.changes) > 0 {
+		var err error
+		if changes, err = tx.resolve(tx, tx.rev); err != nil {
+			return err
+		}
+	}
+
+	if s.commit != nil && len(tx.ops) > 0 {
+		if err := s.commit(next, tx.ops); err != nil {
+			return err
+		}
+	}
+
+	s.data = next
+	for _, fn := range s.onChange {
+		fn(changes)
+	}
+	return nil
+}
+
+func (s *MemoryStore) OnChange(fn ChangeFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onChange = append(s.onChange, fn)
+}
+
+func (s *MemoryStore) Snapshot() (Data, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.data.Clone(), nil
+}
+
+// Replace swaps the whole data set held by the store without calling the
+// commit hook.
+func (s *MemoryStore) Replace(d Data) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d.Regions == nil {
+		d.Regions = make(map[string]Region)
+	}
+	s.data = d
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) Revision() (rev uint64, err error) {
+	err = s.View(func(tx Tx) error {
+		rev, err = tx.Revision()
+		return err
+	})
+	return rev, err
+}
+
+func (s *MemoryStore) ListRegions() (regions []Region, err error) {
+	err = s.View(func(tx Tx) error {
+		regions, err = tx.ListRegions()
+		return err
+	})
+	return regions, err
+}
+
+func (s *MemoryStore) GetRegion(name string) (region Region, err error) {
+	err = s.View(func(tx Tx) error {
+		region, err = tx.GetRegion(name)
+		return err
+	})
+	return region, err
+}
+
+func (s *MemoryStore) CreateRegion(region Region) error {
+	return s.Update(func(tx Tx) error { return tx.CreateRegion(region) })
+}
+
+func (s *MemoryStore) UpdateRegion(name string, region Region) error {
+	return s.Update(func(tx Tx) error { return tx.UpdateRegion(name, region) })
+}
+
+func (s *MemoryStore) DeleteRegion(name string) error {
+	return s.Update(func(tx Tx) error { return tx.DeleteRegion(name) })
+}
+
+func (s *MemoryStore) SetPipeline(region string, stages []string) error {
+	return s.Update(func(tx Tx) error { return tx.SetPipeline(region, stages) })
+}
+
+func (s *MemoryStore) ListEnvironments(region string) (environments []Environment, err error) {
+	err = s.View(func(tx Tx) error {
+		environments, err = tx.ListEnvironments(region)
+		return err
+	})
+	return environments, err
+}
+
+func (s *MemoryStore) GetEnvironment(region, name string) (environment Environment, err error) {
+	err = s.View(func(tx Tx) error {
+		environment, err = tx.GetEnvironment(region, name)
+		return err
+	})
+	return environment, err
+}
+
+func (s *MemoryStore) CreateEnvironment(region string, environment Environment) error {
+	return s.Update(func(tx Tx) error { return tx.CreateEnvironment(region, environment) })
+}
+
+func (s *MemoryStore) UpdateEnvironment(region, name string, environment Environment) error {
+	return s.Update(func(tx Tx) error { return tx.UpdateEnvironment(region, name, environment) })
+}
+
+func (s *MemoryStore) DeleteEnvironment(region, name string) error {
+	return s.Update(func(tx Tx) error { return tx.DeleteEnvironment(region, name) })
+}
+
+func (s *MemoryStore) ListApps(region, environment string) (apps []App, err error) {
+	err = s.View(func(tx Tx) error {
+		apps, err = tx.ListApps(region, environment)
+		return err
+	})
+	return apps, err
+}
+
+func (s *MemoryStore) GetApp(region, environment, name string) (app App, err error) {
+	err = s.View(func(tx Tx) error {
+		app, err = tx.GetApp(region, environment, name)
+		return err
+	})
+	return app, err
+}
+
+func (s *MemoryStore) CreateApp(region, environment string, app App) error {
+	return s.Update(func(tx Tx) error { return tx.CreateApp(region, environment, app) })
+}
+
+func (s *MemoryStore) UpdateApp(region, environment, name string, app App) error {
+	return s.Update(func(tx Tx) error { return tx.UpdateApp(region, environment, name, app) })
+}
+
+func (s *MemoryStore) DeleteApp(region, environment, name string) error {
+	return s.Update(func(tx Tx) error { return tx.DeleteApp(region, environment, name) })
+}
+
+func (s *MemoryStore) AppendHistory(entry HistoryEntry) error {
+	return s.Update(func(tx Tx) error { return tx.AppendHistory(entry) })
+}
+
+func (s *MemoryStore) ListHistory(region, environment, app string, query HistoryQuery) (entries []HistoryEntry, total int, err error) {
+	err = s.View(func(tx Tx) error {
+		entries, total, err = tx.ListHistory(region, environment, app, query)
+		return err
+	})
+	return entries, total, err
+}
+
+func (s *MemoryStore) ListWebhooks() (hooks []Webhook, err error) {
+	err = s.View(func(tx Tx) error {
+		hooks, err = tx.ListWebhooks()
+		return err
+	})
+	return hooks, err
+}
+
+func (s *MemoryStore) GetWebhook(id string) (hook Webhook, err error) {
+	err = s.View(func(tx Tx) error {
+		hook, err = tx.GetWebhook(id)
+		return err
+	})
+	return hook, err
+}
+
+func (s *MemoryStore) CreateWebhook(hook Webhook) error {
+	return s.Update(func(tx Tx) error { return tx.CreateWebhook(hook) })
+}
+
+func (s *MemoryStore) UpdateWebhook(id string, hook Webhook) error {
+	return s.Update(func(tx Tx) error { return tx.UpdateWebhook(id, hook) })
+}
+
+func (s *MemoryStore) DeleteWebhook(id string) error {
+	return s.Update(func(tx Tx) error { return tx.DeleteWebhook(id) })
+}
+
+func (s *MemoryStore) ListTokens() (tokens []Token, err error) {
+	err = s.View(func(tx Tx) error {
+		tokens, err = tx.ListTokens()
+		return err
+	})
+	return tokens, err
+}
+
+func (s *MemoryStore) GetToken(id string) (token Token, err error) {
+	err = s.View(func(tx Tx) error {
+		token, err = tx.GetToken(id)
+		return err
+	})
+	return token, err
+}
+
+func (s *MemoryStore) FindToken(hash string) (token Token, err error) {
+	err = s.View(func(tx Tx) error {
+		token, err = tx.FindToken(hash)
+		return err
+	})
+	return token, err
+}
+
+func (s *MemoryStore) CreateToken(token Token) error {
+	return s.Update(func(tx Tx) error { return tx.CreateToken(token) })
+}
+
+func (s *MemoryStore) DeleteToken(id string) error {
+	return s.Update(func(tx Tx) error { return tx.DeleteToken(id) })
+}
+
+func (s *MemoryStore) ListLocks() (locks []Lock, err error) {
+	err = s.View(func(tx Tx) error {
+		locks, err = tx.ListLocks()
+		return err
+	})
+	return locks, err
+}
+
+func (s *MemoryStore) GetLock(id string) (lock Lock, err error) {
+	err = s.View(func(tx Tx) error {
+		lock, err = tx.GetLock(id)
+		return err
+	})
+	return lock, err
+}
+
+func (s *MemoryStore) CreateLock(lock Lock) error {
+	return s.Update(func(tx Tx) error { return tx.CreateLock(lock) })
+}
+
+func (s *MemoryStore) RemoveLock(id, removedBy string, removedAt time.Time) error {
+	return s.Update(func(tx Tx) error { return tx.RemoveLock(id, removedBy, removedAt) })
+}
+
+// memTx operates directly on a Data value. Update hands it a private copy of
+// the store's data, so writes only become visible once the update commits.
+type memTx struct {
+	data     *Data
+	readOnly bool
+	ops      []journalOp
+	rev      uint64
+	changeLog
+}
+
+func (tx *memTx) record(op journalOp) {
+	tx.ops = append(tx.ops, op)
+}
+
+// revision returns the revision of the transaction, advancing the store
+// revision on the first write.
+func (tx *memTx) revision() uint64 {
+	if tx.rev == 0 {
+		tx.data.Revision++
+		tx.rev = tx.data.Revision
+	}
+	return tx.rev
+}
+
+// touch stamps a region and, if environmentName is set, one of its
+// environments with the revision of the transaction.
+func (tx *memTx) touch(regionName, environmentName string) {
+	rev := tx.revision()
+	region := tx.data.Regions[regionName]
+	region.Revision = rev
+	if environmentName != "" {
+		environment := region.Environments[environmentName]
+		environment.Revision = rev
+		region.Environments[environmentName] = environment
+	}
+	tx.data.Regions[regionName] = region
+}
+
+func (tx *memTx) Revision() (uint64, error) {
+	return tx.data.Revision, nil
+}
+
+func (tx *memTx) region(name string) (Region, error) {
+	region, ok := tx.data.Regions[name]
+	if !ok {
+		return Region{}, ErrRegionNotFound
+	}
+	return region, nil
+}
+
+func (tx *memTx) environment(regionName, name string) (Region, Environment, error) {
+	region, err := tx.region(regionName)
+	if err != nil {
+		return Region{}, Environment{}, err
+	}
+	environment, ok := region.Environments[name]
+	if !ok {
+		return Region{}, Environment{}, ErrEnvironmentNotFound
+	}
+	return region, environment, nil
+}
+
+func (tx *memTx) ListRegions() ([]Region, error) {
+	regions := make([]Region, 0, len(tx.data.Regions))
+	for _, region := range tx.data.Regions {
+		regions = append(regions, region.Clone())
+	}
+	sortRegions(regions)
+	return regions, nil
+}
+
+func (tx *memTx) GetRegion(name string) (Region, error) {
+	region, err := tx.region(name)
+	if err != nil {
+		return Region{}, err
+	}
+	return region.Clone(), nil
+}
+
+func (tx *memTx) CreateRegion(region Region) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, exists := tx.data.Regions[region.Name]; exists {
+		return ErrRegionExists
+	}
+	region = region.keyed(region.Name)
+	if err := region.ValidatePipeline(region.Pipeline); err != nil {
+		return err
+	}
+	created := region.Clone()
+	created.setRevision(tx.revision())
+	tx.data.Regions[region.Name] = created
+	tx.changed(ChangeCreated, KindRegion, region.Name, "", "")
+	tx.record(journalOp{Op: opCreateRegion, RegionData: &region})
+	return nil
+}
+
+func (tx *memTx) UpdateRegion(name string, region Region) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, err := tx.region(name); err != nil {
+		return err
+	}
+	region = region.keyed(name)
+	if err := region.ValidatePipeline(region.Pipeline); err != nil {
+		return err
+	}
+	updated := region.Clone()
+	updated.setRevision(tx.revision())
+	tx.data.Regions[name] = updated
+	tx.dropLocks(name)
+	tx.changed(ChangeUpdated, KindRegion, name, "", "")
+	tx.record(journalOp{Op: opUpdateRegion, Name: name, RegionData: &region})
+	return nil
+}
+
+func (tx *memTx) DeleteRegion(name string) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, err := tx.region(name); err != nil {
+		return err
+	}
+	delete(tx.data.Regions, name)
+	tx.dropLocks(name)
+	tx.revision()
+	tx.changed(ChangeDeleted, KindRegion, name, "", "")
+	tx.record(journalOp{Op: opDeleteRegion, Name: name})
+	return nil
+}
+
+func (tx *memTx) SetPipeline(name string, stages []string) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	region, err := tx.region(name)
+	if err != nil {
+		return err
+	}
+	if err := region.ValidatePipeline(stages); err != nil {
+		return err
+	}
+	region.Pipeline = append([]string(nil), stages...)
+	tx.data.Regions[name] = region
+	tx.touch(name, "")
+	tx.changed(ChangeUpdated, KindRegion, name, "", "")
+	tx.record(journalOp{Op: opSetPipeline, Name: name, Stages: stages})
+	return nil
+}
+
+func (tx *memTx) ListEnvironments(regionName string) ([]Environment, error) {
+	region, err := tx.region(regionName)
+	if err != nil {
+		return nil, err
+	}
+	environments := make([]Environment, 0, len(region.Environments))
+	for _, environment := range region.Environments {
+		environments = append(environments, environment.Clone())
+	}
+	sortEnvironments(environments)
+	return environments, nil
+}
+
+func (tx *memTx) GetEnvironment(regionName, name string) (Environment, error) {
+	_, environment, err := tx.environment(regionName, name)
+	if err != nil {
+		return Environment{}, err
+	}
+	return environment.Clone(), nil
+}
+
+func (tx *memTx) CreateEnvironment(regionName string, environment Environment) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	region, err := tx.region(regionName)
+	if err != nil {
+		return err
+	}
+	if region.Environments == nil {
+		region.Environments = make(map[string]Environment)
+	}
+	if _, exists := region.Environments[environment.Name]; exists {
+		return ErrEnvironmentExists
+	}
+	environment = environment.keyed(environment.Name)
+	created := environment.Clone()
+	created.setRevision(tx.revision())
+	region.Environments[environment.Name] = created
+	tx.data.Regions[regionName] = region
+	tx.touch(regionName, "")
+	tx.changed(ChangeCreated, KindEnvironment, regionName, environment.Name, "")
+	tx.record(journalOp{Op: opCreateEnvironment, Region: regionName, EnvData: &environment})
+	return nil
+}
+
+func (tx *memTx) UpdateEnvironment(regionName, name string, environment Environment) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	region, _, err := tx.environment(regionName, name)
+	if err != nil {
+		return err
+	}
+	environment = environment.keyed(name)
+	updated := environment.Clone()
+	updated.setRevision(tx.revision())
+	region.Environments[name] = updated
+	tx.touch(regionName, "")
+	tx.changed(ChangeUpdated, KindEnvironment, regionName, name, "")
+	tx.record(journalOp{Op: opUpdateEnvironment, Region: regionName, Name: name, EnvData: &environment})
+	return nil
+}
+
+func (tx *memTx) DeleteEnvironment(regionName, name string) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	region, _, err := tx.environment(regionName, name)
+	if err != nil {
+		return err
+	}
+	delete(region.Environments, name)
+	region.Pipeline = removeStage(region.Pipeline, name)
+	tx.data.Regions[regionName] = region
+	tx.dropLocks(regionName)
+	tx.touch(regionName, "")
+	tx.changed(ChangeDeleted, KindEnvironment, regionName, name, "")
+	tx.record(journalOp{Op: opDeleteEnvironment, Region: regionName, Name: name})
+	return nil
+}
+
+func (tx *memTx) ListApps(regionName, environmentName string) ([]App, error) {
+	_, environment, err := tx.environment(regionName, environmentName)
+	if err != nil {
+		return nil, err
+	}
+	apps := make([]App, 0, len(environment.Apps))
+	for _, app := range environment.Apps {
+		apps = append(apps, app)
+	}
+	sortApps(apps)
+	return apps, nil
+}
+
+func (tx *memTx) GetApp(regionName, environmentName, name string) (App, error) {
+	_, environment, err := tx.environment(regionName, environmentName)
+	if err != nil {
+		return App{}, err
+	}
+	app, ok := environment.Apps[name]
+	if !ok {
+		return App{}, ErrAppNotFound
+	}
+	return app, nil
+}
+
+func (tx *memTx) CreateApp(regionName, environmentName string, app App) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	region, environment, err := tx.environment(regionName, environmentName)
+	if err != nil {
+		return err
+	}
+	if environment.Apps == nil {
+		environment.Apps = make(map[string]App)
+	}
+	if _, exists := environment.Apps[app.Name]; exists {
+		return ErrAppExists
+	}
+	app.Revision = tx.revision()
+	environment.Apps[app.Name] = app
+	region.Environments[environmentName] = environment
+	tx.touch(regionName, environmentName)
+	tx.changed(ChangeCreated, KindApp, regionName, environmentName, app.Name)
+	tx.record(journalOp{Op: opCreateApp, Region: regionName, Environment: environmentName, AppData: &app})
+	return nil
+}
+
+func (tx *memTx) UpdateApp(regionName, environmentName, name string, app App) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	_, environment, err := tx.environment(regionName, environmentName)
+	if err != nil {
+		return err
+	}
+	if _, ok := environment.Apps[name]; !ok {
+		return ErrAppNotFound
+	}
+	app.Name = name
+	app.Revision = tx.revision()
+	environment.Apps[name] = app
+	tx.touch(regionName, environmentName)
+	tx.changed(ChangeUpdated, KindApp, regionName, environmentName, name)
+	tx.record(journalOp{Op: opUpdateApp, Region: regionName, Environment: environmentName, Name: name, AppData: &app})
+	return nil
+}
+
+func (tx *memTx) DeleteApp(regionName, environmentName, name string) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	_, environment, err := tx.environment(regionName, environmentName)
+	if err != nil {
+		return err
+	}
+	if _, ok := environment.Apps[name]; !ok {
+		return ErrAppNotFound
+	}
+	delete(environment.Apps, name)
+	tx.touch(regionName, environmentName)
+	tx.changed(ChangeDeleted, KindApp, regionName, environmentName, name)
+	tx.record(journalOp{Op: opDeleteApp, Region: regionName, Environment: environmentName, Name: name})
+	return nil
+}
+
+func (tx *memTx) AppendHistory(entry HistoryEntry) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	if tx.data.History == nil {
+		tx.data.History = make(map[string][]HistoryEntry)
+	}
+	key := HistoryKey(entry.Region, entry.Environment, entry.App)
+	tx.data.History[key] = append(tx.data.History[key], entry)
+	tx.record(journalOp{Op: opAppendHistory, History: &entry})
+	return nil
+}
+
+func (tx *memTx) ListHistory(regionName, environmentName, name string, query HistoryQuery) ([]HistoryEntry, int, error) {
+	entries := tx.data.History[HistoryKey(regionName, environmentName, name)]
+
+	// Entries are stored oldest first
+	var matched []HistoryEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if query.Matches(entries[i]) {
+			matched = append(matched, entries[i])
+		}
+	}
+	if query.SortByVersion {
+		sortHistoryByVersion(matched)
+	}
+	return pageHistory(matched, query), len(matched), nil
+}
+
+func (tx *memTx) ListWebhooks() ([]Webhook, error) {
+	hooks := make([]Webhook, 0, len(tx.data.Webhooks))
+	for _, hook := range tx.data.Webhooks {
+		hooks = append(hooks, hook.Clone())
+	}
+	sortWebhooks(hooks)
+	return hooks, nil
+}
+
+func (tx *memTx) GetWebhook(id string) (Webhook, error) {
+	hook, ok := tx.data.Webhooks[id]
+	if !ok {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	return hook.Clone(), nil
+}
+
+func (tx *memTx) CreateWebhook(hook Webhook) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, exists := tx.data.Webhooks[hook.ID]; exists {
+		return ErrWebhookExists
+	}
+	if err := hook.Validate(); err != nil {
+		return err
+	}
+	if tx.data.Webhooks == nil {
+		tx.data.Webhooks = make(map[string]Webhook)
+	}
+	tx.data.Webhooks[hook.ID] = hook.Clone()
+	tx.record(journalOp{Op: opCreateWebhook, Webhook: &hook})
+	return nil
+}
+
+func (tx *memTx) UpdateWebhook(id string, hook Webhook) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, ok := tx.data.Webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	if err := hook.Validate(); err != nil {
+		return err
+	}
+	hook.ID = id
+	tx.data.Webhooks[id] = hook.Clone()
+	tx.record(journalOp{Op: opUpdateWebhook, Name: id, Webhook: &hook})
+	return nil
+}
+
+func (tx *memTx) DeleteWebhook(id string) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, ok := tx.data.Webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(tx.data.Webhooks, id)
+	tx.record(journalOp{Op: opDeleteWebhook, Name: id})
+	return nil
+}
+
+func (tx *memTx) ListTokens() ([]Token, error) {
+	tokens := make([]Token, 0, len(tx.data.Tokens))
+	for _, token := range tx.data.Tokens {
+		tokens = append(tokens, token.Clone())
+	}
+	sortTokens(tokens)
+	return tokens, nil
+}
+
+func (tx *memTx) GetToken(id string) (Token, error) {
+	token, ok := tx.data.Tokens[id]
+	if !ok {
+		return Token{}, ErrTokenNotFound
+	}
+	return token.Clone(), nil
+}
+
+func (tx *memTx) FindToken(hash string) (Token, error) {
+	for _, token := range tx.data.Tokens {
+		if token.Hash == hash {
+			return token.Clone(), nil
+		}
+	}
+	return Token{}, ErrTokenNotFound
+}
+
+func (tx *memTx) CreateToken(token Token) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, exists := tx.data.Tokens[token.ID]; exists {
+		return ErrTokenExists
+	}
+	if _, err := tx.FindToken(token.Hash); err == nil {
+		return ErrTokenExists
+	}
+	for _, existing := range tx.data.Tokens {
+		if existing.Name == token.Name {
+			return ErrTokenNameUsed
+		}
+	}
+	if err := token.Validate(); err != nil {
+		return err
+	}
+	if tx.data.Tokens == nil {
+		tx.data.Tokens = make(map[string]Token)
+	}
+	tx.data.Tokens[token.ID] = token.Clone()
+	tx.record(journalOp{Op: opCreateToken, Token: &token})
+	return nil
+}
+
+func (tx *memTx) DeleteToken(id string) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, ok := tx.data.Tokens[id]; !ok {
+		return ErrTokenNotFound
+	}
+	delete(tx.data.Tokens, id)
+	tx.record(journalOp{Op: opDeleteToken, Name: id})
+	return nil
+}
+
+func (tx *memTx) ListLocks() ([]Lock, error) {
+	locks := make([]Lock, 0, len(tx.data.Locks))
+	for _, lock := range tx.data.Locks {
+		locks = append(locks, lock.Clone())
+	}
+	sortLocks(locks)
+	return locks, nil
+}
+
+func (tx *memTx) GetLock(id string) (Lock, error) {
+	lock, ok := tx.data.Locks[id]
+	if !ok {
+		return Lock{}, ErrLockNotFound
+	}
+	return lock.Clone(), nil
+}
+
+func (tx *memTx) CreateLock(lock Lock) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, exists := tx.data.Locks[lock.ID]; exists {
+		return ErrLockExists
+	}
+	if err := lock.Validate(); err != nil {
+		return err
+	}
+	if lock.Environment == "" {
+		if _, err := tx.region(lock.Region); err != nil {
+			return err
+		}
+	} else if _, _, err := tx.environment(lock.Region, lock.Environment); err != nil {
+		return err
+	}
+	if tx.data.Locks == nil {
+		tx.data.Locks = make(map[string]Lock)
+	}
+	tx.data.Locks[lock.ID] = lock.Clone()
+	tx.record(journalOp{Op: opCreateLock, Lock: &lock})
+	return nil
+}
+
+func (tx *memTx) RemoveLock(id, removedBy string, removedAt time.Time) error {
+	if tx.readOnly {
+		return ErrReadOnlyTx
+	}
+	lock, ok := tx.data.Locks[id]
+	if !ok {
+		return ErrLockNotFound
+	}
+	if lock.RemovedAt != nil {
+		return ErrLockRemoved
+	}
+	lock.RemovedBy = removedBy
+	lock.RemovedAt = &removedAt
+	tx.data.Locks[id] = lock
+	tx.record(journalOp{Op: opRemoveLock, Name: id, Lock: &Lock{ID: id, RemovedBy: removedBy, RemovedAt: &removedAt}})
+	return nil
+}
+
+// dropLocks removes the locks on a region, or on environments of it that no
+// longer exist. The removal follows from the region or environment change
+// and is not journaled separately.
+func (tx *memTx) dropLocks(regionName string) {
+	region, exists := tx.data.Regions[regionName]
+	for id, lock := range tx.data.Locks {
+		if lock.Region != regionName {
+			continue
+		}
+		if _, ok := region.Environments[lock.Environment]; !exists || (lock.Environment != "" && !ok) {
+			delete(tx.data.Locks, id)
+		}
+	}
+}
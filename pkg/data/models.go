@@ -1,17 +1,46 @@
 package data
 
+import (
+	"fmt"
+	"time"
+	"vhub/pkg/semver"
+)
+
 type Data struct {
 	Regions map[string]Region `json:"regions"`
+	// History holds the deployment history of every app, keyed by
+	// HistoryKey. It outlives the apps themselves.
+	History map[string][]HistoryEntry `json:"history,omitempty"`
+	// Revision is the revision of the last committed change to any region,
+	// environment or app.
+	Revision uint64 `json:"revision,omitempty"`
+	// Webhooks is keyed by Webhook.ID.
+	Webhooks map[string]Webhook `json:"webhooks,omitempty"`
+	// Tokens is keyed by Token.ID.
+	Tokens map[string]Token `json:"tokens,omitempty"`
+	// Locks is keyed by Lock.ID.
+	Locks map[string]Lock `json:"locks,omitempty"`
 }
 
 type Region struct {
 	Name         string                 `json:"name"`
 	Environments map[string]Environment `json:"environments"`
+	// Pipeline lists the environments of the region in promotion order.
+	Pipeline []string `json:"pipeline,omitempty"`
+	// Revision is the store revision at which the region or anything in it
+	// last changed. It is set by the store.
+	Revision uint64 `json:"revision"`
 }
 
 type Environment struct {
 	Name string         `json:"name"`
 	Apps map[string]App `json:"apps"`
+	// EnforceSemver requires app versions in the environment to be valid
+	// SemVer 2.0.0 versions and rejects downgrades unless forced.
+	EnforceSemver bool `json:"enforceSemver,omitempty"`
+	// Revision is the store revision at which the environment or any of its
+	// apps last changed. It is set by the store.
+	Revision uint64 `json:"revision"`
 }
 
 type App struct {
@@ -19,4 +48,227 @@ type App struct {
 	Version string `json:"version"`
 	Route   string `json:"route"`
 	Date    string `json:"date"`
+	// Revision is the store revision at which the app last changed. It is
+	// set by the store.
+	Revision uint64 `json:"revision"`
+}
+
+// ValidatePipeline checks that stages is a valid promotion pipeline for r:
+// every stage must be an environment of the region, listed once.
+func (r Region) ValidatePipeline(stages []string) error {
+	seen := make(map[string]bool, len(stages))
+	for _, stage := range stages {
+		if _, ok := r.Environments[stage]; !ok {
+			return fmt.Errorf("%w: unknown environment %q", ErrInvalidPipeline, stage)
+		}
+		if seen[stage] {
+			return fmt.Errorf("%w: environment %q listed twice", ErrInvalidPipeline, stage)
+		}
+		seen[stage] = true
+	}
+	return nil
+}
+
+// CheckVersion applies the version policy of e to a change of an app's
+// version from current (empty for a new app) to next.
+func (e Environment) CheckVersion(current, next string, force bool) error {
+	if !e.EnforceSemver {
+		return nil
+	}
+	nextVersion, err := semver.Parse(next)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidVersion, err)
+	}
+	if force || current == "" {
+		return nil
+	}
+	currentVersion, err := semver.Parse(current)
+	if err != nil {
+		// The current version predates the policy; any valid version is an
+		// upgrade from it.
+		return nil
+	}
+	if nextVersion.Compare(currentVersion) < 0 {
+		return fmt.Errorf("%w: %s is lower than the current version %s", ErrVersionDowngrade, next, current)
+	}
+	return nil
+}
+
+// NextStage returns the environment that follows environment in the
+// region's pipeline.
+func (r Region) NextStage(environment string) (string, bool) {
+	for i, stage := range r.Pipeline {
+		if stage == environment && i+1 < len(r.Pipeline) {
+			return r.Pipeline[i+1], true
+		}
+	}
+	return "", false
+}
+
+// HistoryEntry records a single change to the version or route of an app.
+// Entries are never modified once written.
+type HistoryEntry struct {
+	Region      string    `json:"region"`
+	Environment string    `json:"environment"`
+	App         string    `json:"app"`
+	Action      string    `json:"action"`
+	OldVersion  string    `json:"oldVersion"`
+	NewVersion  string    `json:"newVersion"`
+	OldRoute    string    `json:"oldRoute"`
+	NewRoute    string    `json:"newRoute"`
+	Timestamp   time.Time `json:"timestamp"`
+	Actor       string    `json:"actor"`
+	Note        string    `json:"note,omitempty"`
+}
+
+const (
+	HistoryActionCreate   = "create"
+	HistoryActionUpdate   = "update"
+	HistoryActionDelete   = "delete"
+	HistoryActionRollback = "rollback"
+	HistoryActionPromote  = "promote"
+)
+
+// HistoryQuery selects a page of history entries. Zero From or To leave that
+// end of the time range open; a zero Limit returns all remaining entries.
+type HistoryQuery struct {
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int
+	// SortByVersion orders entries by the precedence of their new version,
+	// highest first, instead of by time.
+	SortByVersion bool
+}
+
+// Matches reports whether e falls within the time range of q.
+func (q HistoryQuery) Matches(e HistoryEntry) bool {
+	if !q.From.IsZero() && e.Timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && e.Timestamp.After(q.To) {
+		return false
+	}
+	return true
+}
+
+// HistoryKey is the key under which the history of an app is stored.
+func HistoryKey(region, environment, app string) string {
+	return region + "/" + environment + "/" + app
+}
+
+// Clone returns a deep copy of d. History entries are immutable, so the
+// history slices are shared but capped so appends to the copy never write
+// into the original.
+func (d Data) Clone() Data {
+	regions := make(map[string]Region, len(d.Regions))
+	for name, region := range d.Regions {
+		regions[name] = region.Clone()
+	}
+	var history map[string][]HistoryEntry
+	if d.History != nil {
+		history = make(map[string][]HistoryEntry, len(d.History))
+		for key, entries := range d.History {
+			history[key] = entries[:len(entries):len(entries)]
+		}
+	}
+	var webhooks map[string]Webhook
+	if d.Webhooks != nil {
+		webhooks = make(map[string]Webhook, len(d.Webhooks))
+		for id, hook := range d.Webhooks {
+			webhooks[id] = hook.Clone()
+		}
+	}
+	var tokens map[string]Token
+	if d.Tokens != nil {
+		tokens = make(map[string]Token, len(d.Tokens))
+		for id, token := range d.Tokens {
+			tokens[id] = token.Clone()
+		}
+	}
+	var locks map[string]Lock
+	if d.Locks != nil {
+		locks = make(map[string]Lock, len(d.Locks))
+		for id, lock := range d.Locks {
+			locks[id] = lock.Clone()
+		}
+	}
+	return Data{Regions: regions, History: history, Revision: d.Revision, Webhooks: webhooks, Tokens: tokens, Locks: locks}
+}
+
+// Clone returns a deep copy of r.
+func (r Region) Clone() Region {
+	if r.Pipeline != nil {
+		r.Pipeline = append([]string(nil), r.Pipeline...)
+	}
+	if r.Environments == nil {
+		return r
+	}
+	environments := make(map[string]Environment, len(r.Environments))
+	for name, environment := range r.Environments {
+		environments[name] = environment.Clone()
+	}
+	r.Environments = environments
+	return r
+}
+
+// Clone returns a deep copy of e.
+func (e Environment) Clone() Environment {
+	if e.Apps == nil {
+		return e
+	}
+	apps := make(map[string]App, len(e.Apps))
+	for name, app := range e.Apps {
+		apps[name] = app
+	}
+	e.Apps = apps
+	return e
+}
+
+// keyed returns a deep copy of r named name, with every environment and app
+// named after the key it is stored under. Stores go by keys, never by the
+// name fields of a request body.
+func (r Region) keyed(name string) Region {
+	r = r.Clone()
+	r.Name = name
+	if r.Environments == nil {
+		r.Environments = make(map[string]Environment)
+	}
+	for key, environment := range r.Environments {
+		r.Environments[key] = environment.keyed(key)
+	}
+	return r
+}
+
+// keyed returns a deep copy of e named name, with every app named after the
+// key it is stored under.
+func (e Environment) keyed(name string) Environment {
+	e = e.Clone()
+	e.Name = name
+	if e.Apps == nil {
+		e.Apps = make(map[string]App)
+	}
+	for key, app := range e.Apps {
+		app.Name = key
+		e.Apps[key] = app
+	}
+	return e
+}
+
+// setRevision stamps r and everything in it with rev.
+func (r *Region) setRevision(rev uint64) {
+	r.Revision = rev
+	for name, environment := range r.Environments {
+		environment.setRevision(rev)
+		r.Environments[name] = environment
+	}
+}
+
+// setRevision stamps e and all of its apps with rev.
+func (e *Environment) setRevision(rev uint64) {
+	e.Revision = rev
+	for name, app := range e.Apps {
+		app.Revision = rev
+		e.Apps[name] = app
+	}
 }
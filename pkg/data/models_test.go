@@ -0,0 +1,36 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		name          string
+		enforceSemver bool
+		current, next string
+		force         bool
+		want          error
+	}{
+		{"no policy", false, "2.0.0", "latest", false, nil},
+		{"new app", true, "", "1.0.0", false, nil},
+		{"new app with an invalid version", true, "", "1.0", false, ErrInvalidVersion},
+		{"upgrade", true, "1.9.0", "1.10.0", false, nil},
+		{"same version", true, "1.0.0", "1.0.0", false, nil},
+		{"downgrade", true, "1.10.0", "1.9.0", false, ErrVersionDowngrade},
+		{"release to pre-release", true, "1.0.0", "1.0.0-rc.1", false, ErrVersionDowngrade},
+		{"forced downgrade", true, "1.10.0", "1.9.0", true, nil},
+		{"forced invalid version", true, "1.0.0", "latest", true, ErrInvalidVersion},
+		{"from a version predating the policy", true, "latest", "0.1.0", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			environment := Environment{Name: "prod", EnforceSemver: tt.enforceSemver}
+			err := environment.CheckVersion(tt.current, tt.next, tt.force)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("CheckVersion(%q, %q) = %v, want %v", tt.current, tt.next, err, tt.want)
+			}
+		})
+	}
+}
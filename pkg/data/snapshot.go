@@ -0,0 +1,177 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotFormatVersion is written into the header of every snapshot file.
+// Files without a header are the original plain data.json format and are
+// read as version 1.
+const snapshotFormatVersion = 2
+
+var ErrChecksumMismatch = errors.New("snapshot checksum mismatch")
+
+// jsonSnapshot is the data held in a snapshot file: the plain Data format
+// with the sequence number of the last journal record folded into it.
+type jsonSnapshot struct {
+	Data
+	JournalSeq uint64 `json:"journalSeq,omitempty"`
+}
+
+// snapshotFile is the on-disk layout of a snapshot. Checksum is the SHA-256
+// of the exact bytes of Snapshot.
+type snapshotFile struct {
+	FormatVersion int             `json:"formatVersion"`
+	SavedAt       time.Time       `json:"savedAt"`
+	Checksum      string          `json:"checksum"`
+	Snapshot      json.RawMessage `json:"snapshot"`
+}
+
+func loadDataFromFile(filePath string) (Data, error) {
+	snap, _, err := loadSnapshotFromFile(filePath)
+	return snap.Data, err
+}
+
+// loadSnapshotFromFile reads and verifies a snapshot file, returning the
+// snapshot and the time it was saved. Legacy files carry no checksum and
+// report a zero time.
+func loadSnapshotFromFile(filePath string) (jsonSnapshot, time.Time, error) {
+	var snap jsonSnapshot
+
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return snap, time.Time{}, err
+	}
+
+	var file snapshotFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return snap, time.Time{}, err
+	}
+
+	if file.FormatVersion == 0 {
+		// Legacy file without a header
+		if err := json.Unmarshal(raw, &snap); err != nil {
+			return snap, time.Time{}, err
+		}
+		return snap, time.Time{}, nil
+	}
+
+	if file.FormatVersion > snapshotFormatVersion {
+		return snap, time.Time{}, fmt.Errorf("unsupported snapshot format version %d", file.FormatVersion)
+	}
+
+	if checksum(file.Snapshot) != file.Checksum {
+		return snap, time.Time{}, ErrChecksumMismatch
+	}
+
+	if err := json.Unmarshal(file.Snapshot, &snap); err != nil {
+		return snap, time.Time{}, err
+	}
+	return snap, file.SavedAt, nil
+}
+
+// loadNewestSnapshot loads every valid snapshot among filePaths and returns
+// the newest one, preferring earlier paths when two are equally new.
+func loadNewestSnapshot(filePaths ...string) (jsonSnapshot, error) {
+	var (
+		newest     jsonSnapshot
+		newestAt   time.Time
+		newestPath string
+		lastErr    error
+	)
+
+	for _, filePath := range filePaths {
+		snap, savedAt, err := loadSnapshotFromFile(filePath)
+		if err != nil {
+			Log.WithField("filePath", filePath).WithField("error", err).Warn("Ignoring invalid data file")
+			lastErr = err
+			continue
+		}
+
+		if newestPath == "" || snap.JournalSeq > newest.JournalSeq ||
+			(snap.JournalSeq == newest.JournalSeq && savedAt.After(newestAt)) {
+			newest, newestAt, newestPath = snap, savedAt, filePath
+		}
+	}
+
+	if newestPath == "" {
+		Log.Error("No valid data file found")
+		return newest, lastErr
+	}
+
+	Log.WithField("filePath", newestPath).WithField("seq", newest.JournalSeq).Info("Using data file")
+	return newest, nil
+}
+
+// writeSnapshotToFile atomically replaces filePath with snap: the data is
+// written to a temporary file in the same directory, fsync'd and renamed
+// over the original.
+func writeSnapshotToFile(filePath string, snap jsonSnapshot) error {
+	body, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(snapshotFile{
+		FormatVersion: snapshotFormatVersion,
+		SavedAt:       time.Now().UTC(),
+		Checksum:      checksum(body),
+		Snapshot:      body,
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filePath, raw, 0644)
+}
+
+func writeFileAtomic(filePath string, raw []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filePath)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+
+	// Persist the rename itself
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func checksum(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
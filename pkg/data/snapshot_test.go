@@ -0,0 +1,176 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestSnapshot writes a snapshot of one region, named after the file,
+// with the given journal sequence number and save time.
+func writeTestSnapshot(t *testing.T, filePath string, seq uint64, savedAt time.Time) {
+	t.Helper()
+	name := filepath.Base(filePath)
+	body, err := json.Marshal(jsonSnapshot{Data: Data{Regions: map[string]Region{name: {Name: name}}}, JournalSeq: seq})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(snapshotFile{FormatVersion: snapshotFormatVersion, SavedAt: savedAt, Checksum: checksum(body), Snapshot: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// snapshotName returns the name of the only region of snap.
+func snapshotName(snap jsonSnapshot) string {
+	for name := range snap.Regions {
+		return name
+	}
+	return ""
+}
+
+func TestWriteSnapshotToFile(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "data.json")
+	snap := jsonSnapshot{Data: Data{Regions: map[string]Region{"amer": {Name: "amer"}}, Revision: 7}, JournalSeq: 42}
+
+	if err := writeSnapshotToFile(filePath, snap); err != nil {
+		t.Fatal(err)
+	}
+	got, savedAt, err := loadSnapshotFromFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.JournalSeq != 42 || got.Revision != 7 || got.Regions["amer"].Name != "amer" {
+		t.Fatalf("snapshot = %+v", got)
+	}
+	if savedAt.IsZero() {
+		t.Fatal("savedAt is zero")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("directory holds %d files, want only data.json", len(entries))
+	}
+}
+
+func TestLoadSnapshotFromFile(t *testing.T) {
+	dir := t.TempDir()
+
+	legacy := filepath.Join(dir, "legacy.json")
+	if err := os.WriteFile(legacy, []byte(`{"regions":{"amer":{"name":"amer"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snap, savedAt, err := loadSnapshotFromFile(legacy)
+	if err != nil || snap.Regions["amer"].Name != "amer" || !savedAt.IsZero() {
+		t.Fatalf("legacy file = %+v, %v, %v", snap, savedAt, err)
+	}
+
+	tampered := filepath.Join(dir, "tampered.json")
+	writeTestSnapshot(t, tampered, 1, time.Now())
+	raw, err := os.ReadFile(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file snapshotFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		t.Fatal(err)
+	}
+	file.Snapshot = json.RawMessage(`{"journalSeq":99}`)
+	if raw, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tampered, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadSnapshotFromFile(tampered); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("tampered file error = %v, want ErrChecksumMismatch", err)
+	}
+
+	future := filepath.Join(dir, "future.json")
+	if err := os.WriteFile(future, []byte(`{"formatVersion":99,"snapshot":{}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadSnapshotFromFile(future); err == nil {
+		t.Fatal("file of a newer format loaded")
+	}
+
+	truncated := filepath.Join(dir, "truncated.json")
+	if err := os.WriteFile(truncated, []byte(`{"formatVersion":2,"snap`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadSnapshotFromFile(truncated); err == nil {
+		t.Fatal("truncated file loaded")
+	}
+}
+
+func TestLoadNewestSnapshot(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		write func(t *testing.T, a, b string)
+		want  string
+	}{
+		{"higher seq wins over later save", func(t *testing.T, a, b string) {
+			writeTestSnapshot(t, a, 5, now)
+			writeTestSnapshot(t, b, 6, now.Add(-time.Hour))
+		}, "b"},
+		{"later save wins at the same seq", func(t *testing.T, a, b string) {
+			writeTestSnapshot(t, a, 5, now)
+			writeTestSnapshot(t, b, 5, now.Add(time.Second))
+		}, "b"},
+		{"first path wins a tie", func(t *testing.T, a, b string) {
+			writeTestSnapshot(t, a, 5, now)
+			writeTestSnapshot(t, b, 5, now)
+		}, "a"},
+		{"corrupt file is skipped", func(t *testing.T, a, b string) {
+			writeTestSnapshot(t, a, 9, now)
+			raw, _ := os.ReadFile(a)
+			os.WriteFile(a, raw[:len(raw)/2], 0644)
+			writeTestSnapshot(t, b, 1, now)
+		}, "b"},
+		{"missing file is skipped", func(t *testing.T, a, b string) {
+			writeTestSnapshot(t, b, 1, now)
+		}, "b"},
+		{"headered file wins over legacy at the same seq", func(t *testing.T, a, b string) {
+			os.WriteFile(a, []byte(`{"regions":{"a":{"name":"a"}},"journalSeq":3}`), 0644)
+			writeTestSnapshot(t, b, 3, now)
+		}, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+			tt.write(t, a, b)
+
+			snap, err := loadNewestSnapshot(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := snapshotName(snap); got != tt.want {
+				t.Fatalf("loaded %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadNewestSnapshotWithoutValidFile(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad")
+	if err := os.WriteFile(bad, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadNewestSnapshot(bad, filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("loadNewestSnapshot succeeded without a valid file")
+	}
+}
@@ -0,0 +1,1427 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS regions (
+	name     TEXT PRIMARY KEY,
+	revision INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS environments (
+	region         TEXT NOT NULL REFERENCES regions(name) ON DELETE CASCADE,
+	name           TEXT NOT NULL,
+	enforce_semver INTEGER NOT NULL DEFAULT 0,
+	revision       INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (region, name)
+);
+
+CREATE TABLE IF NOT EXISTS apps (
+	region      TEXT NOT NULL,
+	environment TEXT NOT NULL,
+	name        TEXT NOT NULL,
+	version     TEXT NOT NULL DEFAULT '',
+	route       TEXT NOT NULL DEFAULT '',
+	date        TEXT NOT NULL DEFAULT '',
+	revision    INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (region, environment, name),
+	FOREIGN KEY (region, environment) REFERENCES environments(region, name) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS apps_name ON apps(name);
+
+CREATE TABLE IF NOT EXISTS pipeline_stages (
+	region      TEXT NOT NULL,
+	position    INTEGER NOT NULL,
+	environment TEXT NOT NULL,
+	PRIMARY KEY (region, position),
+	FOREIGN KEY (region, environment) REFERENCES environments(region, name) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS history (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	region      TEXT NOT NULL,
+	environment TEXT NOT NULL,
+	app         TEXT NOT NULL,
+	action      TEXT NOT NULL,
+	old_version TEXT NOT NULL,
+	new_version TEXT NOT NULL,
+	old_route   TEXT NOT NULL,
+	new_route   TEXT NOT NULL,
+	timestamp   INTEGER NOT NULL,
+	actor       TEXT NOT NULL,
+	note        TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS history_app_time ON history(region, environment, app, timestamp);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id           TEXT PRIMARY KEY,
+	url          TEXT NOT NULL,
+	secret       TEXT NOT NULL DEFAULT '',
+	regions      TEXT NOT NULL DEFAULT '[]',
+	environments TEXT NOT NULL DEFAULT '[]',
+	apps         TEXT NOT NULL DEFAULT '[]',
+	events       TEXT NOT NULL DEFAULT '[]',
+	disabled     INTEGER NOT NULL DEFAULT 0,
+	created_at   INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	hash       TEXT NOT NULL UNIQUE,
+	scopes     TEXT NOT NULL DEFAULT '[]',
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS locks (
+	id          TEXT PRIMARY KEY,
+	region      TEXT NOT NULL REFERENCES regions(name) ON DELETE CASCADE,
+	environment TEXT NOT NULL DEFAULT '',
+	reason      TEXT NOT NULL DEFAULT '',
+	starts_at   INTEGER NOT NULL DEFAULT 0,
+	ends_at     INTEGER NOT NULL DEFAULT 0,
+	cron        TEXT NOT NULL DEFAULT '',
+	duration    TEXT NOT NULL DEFAULT '',
+	time_zone   TEXT NOT NULL DEFAULT '',
+	created_by  TEXT NOT NULL DEFAULT '',
+	created_at  INTEGER NOT NULL,
+	removed_by  TEXT NOT NULL DEFAULT '',
+	removed_at  INTEGER NOT NULL DEFAULT 0
+);
+`
+
+// sqliteMigrations add columns introduced after a table was first created.
+var sqliteMigrations = []struct {
+	table, column, definition string
+}{
+	{"environments", "enforce_semver", "INTEGER NOT NULL DEFAULT 0"},
+	{"regions", "revision", "INTEGER NOT NULL DEFAULT 0"},
+	{"environments", "revision", "INTEGER NOT NULL DEFAULT 0"},
+	{"apps", "revision", "INTEGER NOT NULL DEFAULT 0"},
+	{"locks", "removed_by", "TEXT NOT NULL DEFAULT ''"},
+	{"locks", "removed_at", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// SQLiteStore is a Store backed by an embedded SQLite database.
+type SQLiteStore struct {
+	db *sql.DB
+
+	// SQLite allows a single writer; serialising updates here avoids
+	// SQLITE_BUSY errors when a read transaction is upgraded.
+	writeMu sync.Mutex
+
+	onChange []ChangeFunc
+}
+
+// NewSQLiteStore opens (creating if needed) the SQLite database at filePath.
+func NewSQLiteStore(filePath string) (*SQLiteStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", filePath)
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	Log.WithField("filePath", filePath).Info("Opened SQLite data store")
+	return &SQLiteStore{db: db}, nil
+}
+
+func migrateSQLite(db *sql.DB) error {
+	for _, m := range sqliteMigrations {
+		var count int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, m.table, m.column).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, m.table, m.column, m.definition)); err != nil {
+			return err
+		}
+		Log.WithField("table", m.table).WithField("column", m.column).Info("Migrated SQLite schema")
+	}
+	return nil
+}
+
+func (s *SQLiteStore) View(fn func(tx Tx) error) error {
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return fn(&sqlTx{tx: tx, readOnly: true})
+}
+
+func (s *SQLiteStore) Update(fn func(tx Tx) error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t := &sqlTx{tx: tx}
+	if err := fn(t); err != nil {
+		return err
+	}
+
+	var changes []Change
+	if len(s.onChange) > 0 && len(t.changes) > 0 {
+		if changes, err = t.resolve(t, t.rev); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, fn := range s.onChange {
+		fn(changes)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) OnChange(fn ChangeFunc) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.onChange = append(s.onChange, fn)
+}
+
+func (s *SQLiteStore) Snapshot() (Data, error) {
+	d := Data{Regions: make(map[string]Region)}
+	err := s.View(func(tx Tx) error {
+		var err error
+		if d.Revision, err = tx.Revision(); err != nil {
+			return err
+		}
+		regions, err := tx.ListRegions()
+		if err != nil {
+			return err
+		}
+		for _, region := range regions {
+			d.Regions[region.Name] = region
+		}
+
+		if d.History, err = tx.(*sqlTx).allHistory(); err != nil {
+			return err
+		}
+
+		hooks, err := tx.ListWebhooks()
+		if err != nil {
+			return err
+		}
+		if len(hooks) > 0 {
+			d.Webhooks = make(map[string]Webhook, len(hooks))
+			for _, hook := range hooks {
+				d.Webhooks[hook.ID] = hook
+			}
+		}
+
+		tokens, err := tx.ListTokens()
+		if err != nil {
+			return err
+		}
+		if len(tokens) > 0 {
+			d.Tokens = make(map[string]Token, len(tokens))
+			for _, token := range tokens {
+				d.Tokens[token.ID] = token
+			}
+		}
+
+		locks, err := tx.ListLocks()
+		if err != nil {
+			return err
+		}
+		if len(locks) > 0 {
+			d.Locks = make(map[string]Lock, len(locks))
+			for _, lock := range locks {
+				d.Locks[lock.ID] = lock
+			}
+		}
+		return nil
+	})
+	return d, err
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) Revision() (rev uint64, err error) {
+	err = s.View(func(tx Tx) error {
+		rev, err = tx.Revision()
+		return err
+	})
+	return rev, err
+}
+
+func (s *SQLiteStore) ListRegions() (regions []Region, err error) {
+	err = s.View(func(tx Tx) error {
+		regions, err = tx.ListRegions()
+		return err
+	})
+	return regions, err
+}
+
+func (s *SQLiteStore) GetRegion(name string) (region Region, err error) {
+	err = s.View(func(tx Tx) error {
+		region, err = tx.GetRegion(name)
+		return err
+	})
+	return region, err
+}
+
+func (s *SQLiteStore) CreateRegion(region Region) error {
+	return s.Update(func(tx Tx) error { return tx.CreateRegion(region) })
+}
+
+func (s *SQLiteStore) UpdateRegion(name string, region Region) error {
+	return s.Update(func(tx Tx) error { return tx.UpdateRegion(name, region) })
+}
+
+func (s *SQLiteStore) DeleteRegion(name string) error {
+	return s.Update(func(tx Tx) error { return tx.DeleteRegion(name) })
+}
+
+func (s *SQLiteStore) SetPipeline(region string, stages []string) error {
+	return s.Update(func(tx Tx) error { return tx.SetPipeline(region, stages) })
+}
+
+func (s *SQLiteStore) ListEnvironments(region string) (environments []Environment, err error) {
+	err = s.View(func(tx Tx) error {
+		environments, err = tx.ListEnvironments(region)
+		return err
+	})
+	return environments, err
+}
+
+func (s *SQLiteStore) GetEnvironment(region, name string) (environment Environment, err error) {
+	err = s.View(func(tx Tx) error {
+		environment, err = tx.GetEnvironment(region, name)
+		return err
+	})
+	return environment, err
+}
+
+func (s *SQLiteStore) CreateEnvironment(region string, environment Environment) error {
+	return s.Update(func(tx Tx) error { return tx.CreateEnvironment(region, environment) })
+}
+
+func (s *SQLiteStore) UpdateEnvironment(region, name string, environment Environment) error {
+	return s.Update(func(tx Tx) error { return tx.UpdateEnvironment(region, name, environment) })
+}
+
+func (s *SQLiteStore) DeleteEnvironment(region, name string) error {
+	return s.Update(func(tx Tx) error { return tx.DeleteEnvironment(region, name) })
+}
+
+func (s *SQLiteStore) ListApps(region, environment string) (apps []App, err error) {
+	err = s.View(func(tx Tx) error {
+		apps, err = tx.ListApps(region, environment)
+		return err
+	})
+	return apps, err
+}
+
+func (s *SQLiteStore) GetApp(region, environment, name string) (app App, err error) {
+	err = s.View(func(tx Tx) error {
+		app, err = tx.GetApp(region, environment, name)
+		return err
+	})
+	return app, err
+}
+
+func (s *SQLiteStore) CreateApp(region, environment string, app App) error {
+	return s.Update(func(tx Tx) error { return tx.CreateApp(region, environment, app) })
+}
+
+func (s *SQLiteStore) UpdateApp(region, environment, name string, app App) error {
+	return s.Update(func(tx Tx) error { return tx.UpdateApp(region, environment, name, app) })
+}
+
+func (s *SQLiteStore) DeleteApp(region, environment, name string) error {
+	return s.Update(func(tx Tx) error { return tx.DeleteApp(region, environment, name) })
+}
+
+func (s *SQLiteStore) AppendHistory(entry HistoryEntry) error {
+	return s.Update(func(tx Tx) error { return tx.AppendHistory(entry) })
+}
+
+func (s *SQLiteStore) ListHistory(region, environment, app string, query HistoryQuery) (entries []HistoryEntry, total int, err error) {
+	err = s.View(func(tx Tx) error {
+		entries, total, err = tx.ListHistory(region, environment, app, query)
+		return err
+	})
+	return entries, total, err
+}
+
+func (s *SQLiteStore) ListWebhooks() (hooks []Webhook, err error) {
+	err = s.View(func(tx Tx) error {
+		hooks, err = tx.ListWebhooks()
+		return err
+	})
+	return hooks, err
+}
+
+func (s *SQLiteStore) GetWebhook(id string) (hook Webhook, err error) {
+	err = s.View(func(tx Tx) error {
+		hook, err = tx.GetWebhook(id)
+		return err
+	})
+	return hook, err
+}
+
+func (s *SQLiteStore) CreateWebhook(hook Webhook) error {
+	return s.Update(func(tx Tx) error { return tx.CreateWebhook(hook) })
+}
+
+func (s *SQLiteStore) UpdateWebhook(id string, hook Webhook) error {
+	return s.Update(func(tx Tx) error { return tx.UpdateWebhook(id, hook) })
+}
+
+func (s *SQLiteStore) DeleteWebhook(id string) error {
+	return s.Update(func(tx Tx) error { return tx.DeleteWebhook(id) })
+}
+
+func (s *SQLiteStore) ListTokens() (tokens []Token, err error) {
+	err = s.View(func(tx Tx) error {
+		tokens, err = tx.ListTokens()
+		return err
+	})
+	return tokens, err
+}
+
+func (s *SQLiteStore) GetToken(id string) (token Token, err error) {
+	err = s.View(func(tx Tx) error {
+		token, err = tx.GetToken(id)
+		return err
+	})
+	return token, err
+}
+
+func (s *SQLiteStore) FindToken(hash string) (token Token, err error) {
+	err = s.View(func(tx Tx) error {
+		token, err = tx.FindToken(hash)
+		return err
+	})
+	return token, err
+}
+
+func (s *SQLiteStore) CreateToken(token Token) error {
+	return s.Update(func(tx Tx) error { return tx.CreateToken(token) })
+}
+
+func (s *SQLiteStore) DeleteToken(id string) error {
+	return s.Update(func(tx Tx) error { return tx.DeleteToken(id) })
+}
+
+func (s *SQLiteStore) ListLocks() (locks []Lock, err error) {
+	err = s.View(func(tx Tx) error {
+		locks, err = tx.ListLocks()
+		return err
+	})
+	return locks, err
+}
+
+func (s *SQLiteStore) GetLock(id string) (lock Lock, err error) {
+	err = s.View(func(tx Tx) error {
+		lock, err = tx.GetLock(id)
+		return err
+	})
+	return lock, err
+}
+
+func (s *SQLiteStore) CreateLock(lock Lock) error {
+	return s.Update(func(tx Tx) error { return tx.CreateLock(lock) })
+}
+
+func (s *SQLiteStore) RemoveLock(id, removedBy string, removedAt time.Time) error {
+	return s.Update(func(tx Tx) error { return tx.RemoveLock(id, removedBy, removedAt) })
+}
+
+type sqlTx struct {
+	tx       *sql.Tx
+	readOnly bool
+	rev      uint64
+	changeLog
+}
+
+func (t *sqlTx) Revision() (uint64, error) {
+	var rev uint64
+	err := t.tx.QueryRow(`SELECT value FROM meta WHERE key = 'revision'`).Scan(&rev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return rev, err
+}
+
+// revision returns the revision of the transaction, advancing the store
+// revision on the first write.
+func (t *sqlTx) revision() (uint64, error) {
+	if t.rev != 0 {
+		return t.rev, nil
+	}
+	current, err := t.Revision()
+	if err != nil {
+		return 0, err
+	}
+	_, err = t.tx.Exec(`INSERT INTO meta (key, value) VALUES ('revision', ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, current+1)
+	if err != nil {
+		return 0, err
+	}
+	t.rev = current + 1
+	return t.rev, nil
+}
+
+// touch stamps a region and, if environment is set, one of its environments
+// with the revision of the transaction.
+func (t *sqlTx) touch(region, environment string) error {
+	rev, err := t.revision()
+	if err != nil {
+		return err
+	}
+	if _, err := t.tx.Exec(`UPDATE regions SET revision = ? WHERE name = ?`, rev, region); err != nil {
+		return err
+	}
+	if environment == "" {
+		return nil
+	}
+	_, err = t.tx.Exec(`UPDATE environments SET revision = ? WHERE region = ? AND name = ?`, rev, region, environment)
+	return err
+}
+
+func (t *sqlTx) exists(query string, args ...interface{}) (bool, error) {
+	var one int
+	err := t.tx.QueryRow(query, args...).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t *sqlTx) requireRegion(region string) error {
+	ok, err := t.exists(`SELECT 1 FROM regions WHERE name = ?`, region)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrRegionNotFound
+	}
+	return nil
+}
+
+func (t *sqlTx) requireEnvironment(region, environment string) error {
+	if err := t.requireRegion(region); err != nil {
+		return err
+	}
+	ok, err := t.exists(`SELECT 1 FROM environments WHERE region = ? AND name = ?`, region, environment)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrEnvironmentNotFound
+	}
+	return nil
+}
+
+// environments loads the environments of a region, with their apps.
+func (t *sqlTx) environments(region string) (map[string]Environment, error) {
+	environments := make(map[string]Environment)
+
+	rows, err := t.tx.Query(`SELECT name, enforce_semver, revision FROM environments WHERE region = ?`, region)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		environment := Environment{Apps: make(map[string]App)}
+		if err := rows.Scan(&environment.Name, &environment.EnforceSemver, &environment.Revision); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		environments[environment.Name] = environment
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = t.tx.Query(`SELECT environment, name, version, route, date, revision FROM apps WHERE region = ?`, region)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var environment string
+		var app App
+		if err := rows.Scan(&environment, &app.Name, &app.Version, &app.Route, &app.Date, &app.Revision); err != nil {
+			return nil, err
+		}
+		environments[environment].Apps[app.Name] = app
+	}
+	return environments, rows.Err()
+}
+
+func (t *sqlTx) apps(region, environment string) (map[string]App, error) {
+	apps := make(map[string]App)
+
+	rows, err := t.tx.Query(`SELECT name, version, route, date, revision FROM apps WHERE region = ? AND environment = ?`, region, environment)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var app App
+		if err := rows.Scan(&app.Name, &app.Version, &app.Route, &app.Date, &app.Revision); err != nil {
+			return nil, err
+		}
+		apps[app.Name] = app
+	}
+	return apps, rows.Err()
+}
+
+func (t *sqlTx) insertEnvironment(region string, environment Environment) error {
+	rev, err := t.revision()
+	if err != nil {
+		return err
+	}
+	if _, err := t.tx.Exec(`INSERT INTO environments (region, name, enforce_semver, revision) VALUES (?, ?, ?, ?)`,
+		region, environment.Name, environment.EnforceSemver, rev); err != nil {
+		return err
+	}
+	for name, app := range environment.Apps {
+		app.Name = name
+		if err := t.insertApp(region, environment.Name, app); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqlTx) insertApp(region, environment string, app App) error {
+	rev, err := t.revision()
+	if err != nil {
+		return err
+	}
+	_, err = t.tx.Exec(`INSERT INTO apps (region, environment, name, version, route, date, revision) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		region, environment, app.Name, app.Version, app.Route, app.Date, rev)
+	return err
+}
+
+func (t *sqlTx) ListRegions() ([]Region, error) {
+	rows, err := t.tx.Query(`SELECT name, revision FROM regions ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	var regions []Region
+	for rows.Next() {
+		var region Region
+		if err := rows.Scan(&region.Name, &region.Revision); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		regions = append(regions, region)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range regions {
+		if regions[i].Environments, err = t.environments(regions[i].Name); err != nil {
+			return nil, err
+		}
+		if regions[i].Pipeline, err = t.pipeline(regions[i].Name); err != nil {
+			return nil, err
+		}
+	}
+	if regions == nil {
+		regions = []Region{}
+	}
+	return regions, nil
+}
+
+func (t *sqlTx) GetRegion(name string) (Region, error) {
+	region := Region{Name: name}
+	err := t.tx.QueryRow(`SELECT revision FROM regions WHERE name = ?`, name).Scan(&region.Revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Region{}, ErrRegionNotFound
+	}
+	if err != nil {
+		return Region{}, err
+	}
+	if region.Environments, err = t.environments(name); err != nil {
+		return Region{}, err
+	}
+	if region.Pipeline, err = t.pipeline(name); err != nil {
+		return Region{}, err
+	}
+	return region, nil
+}
+
+func (t *sqlTx) CreateRegion(region Region) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	ok, err := t.exists(`SELECT 1 FROM regions WHERE name = ?`, region.Name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrRegionExists
+	}
+	if err := region.ValidatePipeline(region.Pipeline); err != nil {
+		return err
+	}
+	rev, err := t.revision()
+	if err != nil {
+		return err
+	}
+	if _, err := t.tx.Exec(`INSERT INTO regions (name, revision) VALUES (?, ?)`, region.Name, rev); err != nil {
+		return err
+	}
+	for name, environment := range region.Environments {
+		environment.Name = name
+		if err := t.insertEnvironment(region.Name, environment); err != nil {
+			return err
+		}
+	}
+	t.changed(ChangeCreated, KindRegion, region.Name, "", "")
+	return t.insertPipeline(region.Name, region.Pipeline)
+}
+
+func (t *sqlTx) UpdateRegion(name string, region Region) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if err := t.requireRegion(name); err != nil {
+		return err
+	}
+	if err := region.ValidatePipeline(region.Pipeline); err != nil {
+		return err
+	}
+	if _, err := t.tx.Exec(`DELETE FROM environments WHERE region = ?`, name); err != nil {
+		return err
+	}
+	for key, environment := range region.Environments {
+		environment.Name = key
+		if err := t.insertEnvironment(name, environment); err != nil {
+			return err
+		}
+	}
+	if err := t.insertPipeline(name, region.Pipeline); err != nil {
+		return err
+	}
+	if err := t.dropLocks(name); err != nil {
+		return err
+	}
+	t.changed(ChangeUpdated, KindRegion, name, "", "")
+	return t.touch(name, "")
+}
+
+func (t *sqlTx) SetPipeline(name string, stages []string) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	region, err := t.GetRegion(name)
+	if err != nil {
+		return err
+	}
+	if err := region.ValidatePipeline(stages); err != nil {
+		return err
+	}
+	if _, err := t.tx.Exec(`DELETE FROM pipeline_stages WHERE region = ?`, name); err != nil {
+		return err
+	}
+	if err := t.insertPipeline(name, stages); err != nil {
+		return err
+	}
+	t.changed(ChangeUpdated, KindRegion, name, "", "")
+	return t.touch(name, "")
+}
+
+func (t *sqlTx) pipeline(region string) ([]string, error) {
+	rows, err := t.tx.Query(`SELECT environment FROM pipeline_stages WHERE region = ? ORDER BY position`, region)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stages []string
+	for rows.Next() {
+		var stage string
+		if err := rows.Scan(&stage); err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+	return stages, rows.Err()
+}
+
+func (t *sqlTx) insertPipeline(region string, stages []string) error {
+	for i, stage := range stages {
+		if _, err := t.tx.Exec(`INSERT INTO pipeline_stages (region, position, environment) VALUES (?, ?, ?)`, region, i, stage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqlTx) DeleteRegion(name string) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if err := t.requireRegion(name); err != nil {
+		return err
+	}
+	if _, err := t.tx.Exec(`DELETE FROM regions WHERE name = ?`, name); err != nil {
+		return err
+	}
+	t.changed(ChangeDeleted, KindRegion, name, "", "")
+	_, err := t.revision()
+	return err
+}
+
+func (t *sqlTx) ListEnvironments(region string) ([]Environment, error) {
+	if err := t.requireRegion(region); err != nil {
+		return nil, err
+	}
+	environments, err := t.environments(region)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Environment, 0, len(environments))
+	for _, environment := range environments {
+		list = append(list, environment)
+	}
+	sortEnvironments(list)
+	return list, nil
+}
+
+func (t *sqlTx) GetEnvironment(region, name string) (Environment, error) {
+	if err := t.requireRegion(region); err != nil {
+		return Environment{}, err
+	}
+	environment := Environment{Name: name}
+	err := t.tx.QueryRow(`SELECT enforce_semver, revision FROM environments WHERE region = ? AND name = ?`, region, name).Scan(&environment.EnforceSemver, &environment.Revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Environment{}, ErrEnvironmentNotFound
+	}
+	if err != nil {
+		return Environment{}, err
+	}
+	if environment.Apps, err = t.apps(region, name); err != nil {
+		return Environment{}, err
+	}
+	return environment, nil
+}
+
+func (t *sqlTx) CreateEnvironment(region string, environment Environment) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if err := t.requireRegion(region); err != nil {
+		return err
+	}
+	ok, err := t.exists(`SELECT 1 FROM environments WHERE region = ? AND name = ?`, region, environment.Name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrEnvironmentExists
+	}
+	if err := t.insertEnvironment(region, environment); err != nil {
+		return err
+	}
+	t.changed(ChangeCreated, KindEnvironment, region, environment.Name, "")
+	return t.touch(region, "")
+}
+
+func (t *sqlTx) UpdateEnvironment(region, name string, environment Environment) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if err := t.requireEnvironment(region, name); err != nil {
+		return err
+	}
+	if _, err := t.tx.Exec(`UPDATE environments SET enforce_semver = ? WHERE region = ? AND name = ?`, environment.EnforceSemver, region, name); err != nil {
+		return err
+	}
+	if _, err := t.tx.Exec(`DELETE FROM apps WHERE region = ? AND environment = ?`, region, name); err != nil {
+		return err
+	}
+	for key, app := range environment.Apps {
+		app.Name = key
+		if err := t.insertApp(region, name, app); err != nil {
+			return err
+		}
+	}
+	t.changed(ChangeUpdated, KindEnvironment, region, name, "")
+	return t.touch(region, name)
+}
+
+func (t *sqlTx) DeleteEnvironment(region, name string) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if err := t.requireEnvironment(region, name); err != nil {
+		return err
+	}
+	if _, err := t.tx.Exec(`DELETE FROM environments WHERE region = ? AND name = ?`, region, name); err != nil {
+		return err
+	}
+	if err := t.dropLocks(region); err != nil {
+		return err
+	}
+	t.changed(ChangeDeleted, KindEnvironment, region, name, "")
+	return t.touch(region, "")
+}
+
+func (t *sqlTx) ListApps(region, environment string) ([]App, error) {
+	if err := t.requireEnvironment(region, environment); err != nil {
+		return nil, err
+	}
+	apps, err := t.apps(region, environment)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]App, 0, len(apps))
+	for _, app := range apps {
+		list = append(list, app)
+	}
+	sortApps(list)
+	return list, nil
+}
+
+func (t *sqlTx) GetApp(region, environment, name string) (App, error) {
+	if err := t.requireEnvironment(region, environment); err != nil {
+		return App{}, err
+	}
+	app := App{Name: name}
+	err := t.tx.QueryRow(`SELECT version, route, date, revision FROM apps WHERE region = ? AND environment = ? AND name = ?`,
+		region, environment, name).Scan(&app.Version, &app.Route, &app.Date, &app.Revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return App{}, ErrAppNotFound
+	}
+	return app, err
+}
+
+func (t *sqlTx) CreateApp(region, environment string, app App) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if err := t.requireEnvironment(region, environment); err != nil {
+		return err
+	}
+	ok, err := t.exists(`SELECT 1 FROM apps WHERE region = ? AND environment = ? AND name = ?`, region, environment, app.Name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrAppExists
+	}
+	if err := t.insertApp(region, environment, app); err != nil {
+		return err
+	}
+	t.changed(ChangeCreated, KindApp, region, environment, app.Name)
+	return t.touch(region, environment)
+}
+
+func (t *sqlTx) UpdateApp(region, environment, name string, app App) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, err := t.GetApp(region, environment, name); err != nil {
+		return err
+	}
+	rev, err := t.revision()
+	if err != nil {
+		return err
+	}
+	_, err = t.tx.Exec(`UPDATE apps SET version = ?, route = ?, date = ?, revision = ? WHERE region = ? AND environment = ? AND name = ?`,
+		app.Version, app.Route, app.Date, rev, region, environment, name)
+	if err != nil {
+		return err
+	}
+	t.changed(ChangeUpdated, KindApp, region, environment, name)
+	return t.touch(region, environment)
+}
+
+func (t *sqlTx) DeleteApp(region, environment, name string) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, err := t.GetApp(region, environment, name); err != nil {
+		return err
+	}
+	if _, err := t.tx.Exec(`DELETE FROM apps WHERE region = ? AND environment = ? AND name = ?`, region, environment, name); err != nil {
+		return err
+	}
+	t.changed(ChangeDeleted, KindApp, region, environment, name)
+	return t.touch(region, environment)
+}
+
+const historyColumns = `region, environment, app, action, old_version, new_version, old_route, new_route, timestamp, actor, note`
+
+func scanHistory(rows *sql.Rows) ([]HistoryEntry, error) {
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		var e HistoryEntry
+		var ts int64
+		if err := rows.Scan(&e.Region, &e.Environment, &e.App, &e.Action, &e.OldVersion, &e.NewVersion,
+			&e.OldRoute, &e.NewRoute, &ts, &e.Actor, &e.Note); err != nil {
+			return nil, err
+		}
+		e.Timestamp = time.Unix(0, ts).UTC()
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (t *sqlTx) allHistory() (map[string][]HistoryEntry, error) {
+	rows, err := t.tx.Query(`SELECT ` + historyColumns + ` FROM history ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := scanHistory(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make(map[string][]HistoryEntry)
+	for _, e := range entries {
+		key := HistoryKey(e.Region, e.Environment, e.App)
+		history[key] = append(history[key], e)
+	}
+	return history, nil
+}
+
+func (t *sqlTx) AppendHistory(e HistoryEntry) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	_, err := t.tx.Exec(`INSERT INTO history (`+historyColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Region, e.Environment, e.App, e.Action, e.OldVersion, e.NewVersion, e.OldRoute, e.NewRoute,
+		e.Timestamp.UnixNano(), e.Actor, e.Note)
+	return err
+}
+
+func (t *sqlTx) ListHistory(region, environment, app string, query HistoryQuery) ([]HistoryEntry, int, error) {
+	where := `region = ? AND environment = ? AND app = ?`
+	args := []interface{}{region, environment, app}
+	if !query.From.IsZero() {
+		where += ` AND timestamp >= ?`
+		args = append(args, query.From.UnixNano())
+	}
+	if !query.To.IsZero() {
+		where += ` AND timestamp <= ?`
+		args = append(args, query.To.UnixNano())
+	}
+
+	var total int
+	if err := t.tx.QueryRow(`SELECT COUNT(*) FROM history WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if query.SortByVersion {
+		// Version precedence cannot be expressed in SQL, so sort and page
+		// the whole time range here
+		rows, err := t.tx.Query(`SELECT `+historyColumns+` FROM history WHERE `+where+` ORDER BY timestamp DESC, id DESC`, args...)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries, err := scanHistory(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		sortHistoryByVersion(entries)
+		return pageHistory(entries, query), total, nil
+	}
+
+	limit := -1
+	if query.Limit > 0 {
+		limit = query.Limit
+	}
+	rows, err := t.tx.Query(`SELECT `+historyColumns+` FROM history WHERE `+where+` ORDER BY timestamp DESC, id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, query.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, err := scanHistory(rows)
+	return entries, total, err
+}
+
+const webhookColumns = `id, url, secret, regions, environments, apps, events, disabled, created_at`
+
+// webhookRow returns the column values of a webhook in webhookColumns order,
+// with the filter lists encoded as JSON arrays.
+func webhookRow(hook Webhook) ([]interface{}, error) {
+	row := []interface{}{hook.ID, hook.URL, hook.Secret}
+	for _, list := range [][]string{hook.Regions, hook.Environments, hook.Apps, hook.Events} {
+		if list == nil {
+			list = []string{}
+		}
+		encoded, err := json.Marshal(list)
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, string(encoded))
+	}
+	return append(row, hook.Disabled, hook.CreatedAt.UnixNano()), nil
+}
+
+func scanWebhooks(rows *sql.Rows) ([]Webhook, error) {
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		var hook Webhook
+		var regions, environments, apps, events string
+		var createdAt int64
+		if err := rows.Scan(&hook.ID, &hook.URL, &hook.Secret, &regions, &environments, &apps, &events,
+			&hook.Disabled, &createdAt); err != nil {
+			return nil, err
+		}
+		for _, field := range []struct {
+			encoded string
+			list    *[]string
+		}{{regions, &hook.Regions}, {environments, &hook.Environments}, {apps, &hook.Apps}, {events, &hook.Events}} {
+			if err := json.Unmarshal([]byte(field.encoded), field.list); err != nil {
+				return nil, err
+			}
+			if len(*field.list) == 0 {
+				*field.list = nil
+			}
+		}
+		hook.CreatedAt = time.Unix(0, createdAt).UTC()
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (t *sqlTx) ListWebhooks() ([]Webhook, error) {
+	rows, err := t.tx.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhooks(rows)
+}
+
+func (t *sqlTx) GetWebhook(id string) (Webhook, error) {
+	rows, err := t.tx.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	hooks, err := scanWebhooks(rows)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(hooks) == 0 {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	return hooks[0], nil
+}
+
+func (t *sqlTx) CreateWebhook(hook Webhook) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	ok, err := t.exists(`SELECT 1 FROM webhooks WHERE id = ?`, hook.ID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrWebhookExists
+	}
+	if err := hook.Validate(); err != nil {
+		return err
+	}
+	row, err := webhookRow(hook)
+	if err != nil {
+		return err
+	}
+	_, err = t.tx.Exec(`INSERT INTO webhooks (`+webhookColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, row...)
+	return err
+}
+
+func (t *sqlTx) UpdateWebhook(id string, hook Webhook) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, err := t.GetWebhook(id); err != nil {
+		return err
+	}
+	if err := hook.Validate(); err != nil {
+		return err
+	}
+	hook.ID = id
+	row, err := webhookRow(hook)
+	if err != nil {
+		return err
+	}
+	_, err = t.tx.Exec(`UPDATE webhooks SET url = ?, secret = ?, regions = ?, environments = ?, apps = ?, events = ?, disabled = ?, created_at = ? WHERE id = ?`,
+		append(row[1:], id)...)
+	return err
+}
+
+func (t *sqlTx) DeleteWebhook(id string) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, err := t.GetWebhook(id); err != nil {
+		return err
+	}
+	_, err := t.tx.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	return err
+}
+
+const tokenColumns = `id, name, hash, scopes, created_at, expires_at`
+
+func scanTokens(rows *sql.Rows) ([]Token, error) {
+	defer rows.Close()
+
+	tokens := []Token{}
+	for rows.Next() {
+		var token Token
+		var scopes string
+		var createdAt, expiresAt int64
+		if err := rows.Scan(&token.ID, &token.Name, &token.Hash, &scopes, &createdAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(scopes), &token.Scopes); err != nil {
+			return nil, err
+		}
+		token.CreatedAt = time.Unix(0, createdAt).UTC()
+		if expiresAt != 0 {
+			token.ExpiresAt = time.Unix(0, expiresAt).UTC()
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (t *sqlTx) queryToken(where string, arg interface{}) (Token, error) {
+	rows, err := t.tx.Query(`SELECT `+tokenColumns+` FROM tokens WHERE `+where, arg)
+	if err != nil {
+		return Token{}, err
+	}
+	tokens, err := scanTokens(rows)
+	if err != nil {
+		return Token{}, err
+	}
+	if len(tokens) == 0 {
+		return Token{}, ErrTokenNotFound
+	}
+	return tokens[0], nil
+}
+
+func (t *sqlTx) ListTokens() ([]Token, error) {
+	rows, err := t.tx.Query(`SELECT ` + tokenColumns + ` FROM tokens ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	return scanTokens(rows)
+}
+
+func (t *sqlTx) GetToken(id string) (Token, error) {
+	return t.queryToken(`id = ?`, id)
+}
+
+func (t *sqlTx) FindToken(hash string) (Token, error) {
+	return t.queryToken(`hash = ?`, hash)
+}
+
+func (t *sqlTx) CreateToken(token Token) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	ok, err := t.exists(`SELECT 1 FROM tokens WHERE id = ? OR hash = ?`, token.ID, token.Hash)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrTokenExists
+	}
+	ok, err = t.exists(`SELECT 1 FROM tokens WHERE name = ?`, token.Name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrTokenNameUsed
+	}
+	if err := token.Validate(); err != nil {
+		return err
+	}
+	scopes, err := json.Marshal(token.Scopes)
+	if err != nil {
+		return err
+	}
+	var expiresAt int64
+	if !token.ExpiresAt.IsZero() {
+		expiresAt = token.ExpiresAt.UnixNano()
+	}
+	_, err = t.tx.Exec(`INSERT INTO tokens (`+tokenColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		token.ID, token.Name, token.Hash, string(scopes), token.CreatedAt.UnixNano(), expiresAt)
+	return err
+}
+
+func (t *sqlTx) DeleteToken(id string) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	if _, err := t.GetToken(id); err != nil {
+		return err
+	}
+	_, err := t.tx.Exec(`DELETE FROM tokens WHERE id = ?`, id)
+	return err
+}
+
+const lockColumns = `id, region, environment, reason, starts_at, ends_at, cron, duration, time_zone, created_by, created_at, removed_by, removed_at`
+
+func scanLocks(rows *sql.Rows) ([]Lock, error) {
+	defer rows.Close()
+
+	locks := []Lock{}
+	for rows.Next() {
+		var lock Lock
+		var startsAt, endsAt, createdAt, removedAt int64
+		if err := rows.Scan(&lock.ID, &lock.Region, &lock.Environment, &lock.Reason, &startsAt, &endsAt,
+			&lock.Cron, &lock.Duration, &lock.TimeZone, &lock.CreatedBy, &createdAt, &lock.RemovedBy, &removedAt); err != nil {
+			return nil, err
+		}
+		if startsAt != 0 {
+			start := time.Unix(0, startsAt).UTC()
+			lock.Start = &start
+		}
+		if endsAt != 0 {
+			end := time.Unix(0, endsAt).UTC()
+			lock.End = &end
+		}
+		lock.CreatedAt = time.Unix(0, createdAt).UTC()
+		if removedAt != 0 {
+			removed := time.Unix(0, removedAt).UTC()
+			lock.RemovedAt = &removed
+		}
+		locks = append(locks, lock)
+	}
+	return locks, rows.Err()
+}
+
+func (t *sqlTx) ListLocks() ([]Lock, error) {
+	rows, err := t.tx.Query(`SELECT ` + lockColumns + ` FROM locks ORDER BY region, environment, created_at`)
+	if err != nil {
+		return nil, err
+	}
+	return scanLocks(rows)
+}
+
+func (t *sqlTx) GetLock(id string) (Lock, error) {
+	rows, err := t.tx.Query(`SELECT `+lockColumns+` FROM locks WHERE id = ?`, id)
+	if err != nil {
+		return Lock{}, err
+	}
+	locks, err := scanLocks(rows)
+	if err != nil {
+		return Lock{}, err
+	}
+	if len(locks) == 0 {
+		return Lock{}, ErrLockNotFound
+	}
+	return locks[0], nil
+}
+
+func (t *sqlTx) CreateLock(lock Lock) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	ok, err := t.exists(`SELECT 1 FROM locks WHERE id = ?`, lock.ID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrLockExists
+	}
+	if err := lock.Validate(); err != nil {
+		return err
+	}
+	if lock.Environment == "" {
+		err = t.requireRegion(lock.Region)
+	} else {
+		err = t.requireEnvironment(lock.Region, lock.Environment)
+	}
+	if err != nil {
+		return err
+	}
+	var startsAt, endsAt int64
+	if lock.Start != nil {
+		startsAt = lock.Start.UnixNano()
+	}
+	if lock.End != nil {
+		endsAt = lock.End.UnixNano()
+	}
+	var removedAt int64
+	if lock.RemovedAt != nil {
+		removedAt = lock.RemovedAt.UnixNano()
+	}
+	_, err = t.tx.Exec(`INSERT INTO locks (`+lockColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		lock.ID, lock.Region, lock.Environment, lock.Reason, startsAt, endsAt,
+		lock.Cron, lock.Duration, lock.TimeZone, lock.CreatedBy, lock.CreatedAt.UnixNano(), lock.RemovedBy, removedAt)
+	return err
+}
+
+func (t *sqlTx) RemoveLock(id, removedBy string, removedAt time.Time) error {
+	if t.readOnly {
+		return ErrReadOnlyTx
+	}
+	lock, err := t.GetLock(id)
+	if err != nil {
+		return err
+	}
+	if lock.RemovedAt != nil {
+		return ErrLockRemoved
+	}
+	_, err = t.tx.Exec(`UPDATE locks SET removed_by = ?, removed_at = ? WHERE id = ?`, removedBy, removedAt.UnixNano(), id)
+	return err
+}
+
+// dropLocks removes the locks on environments of a region that no longer
+// exist. Locks on the region itself go with it through the foreign key.
+func (t *sqlTx) dropLocks(region string) error {
+	_, err := t.tx.Exec(`DELETE FROM locks WHERE region = ? AND environment != ''
+		AND environment NOT IN (SELECT name FROM environments WHERE region = ?)`, region, region)
+	return err
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"errors"
+	"sort"
+	"time"
+	"vhub/pkg/semver"
+)
+
+var (
+	ErrRegionNotFound      = errors.New("region not found")
+	ErrEnvironmentNotFound = errors.New("environment not found")
+	ErrAppNotFound         = errors.New("app not found")
+	ErrRegionExists        = errors.New("region already exists")
+	ErrEnvironmentExists   = errors.New("environment already exists")
+	ErrAppExists           = errors.New("app already exists")
+	ErrInvalidPipeline     = errors.New("invalid pipeline")
+	ErrInvalidVersion      = errors.New("invalid version")
+	ErrVersionDowngrade    = errors.New("version downgrade")
+)
+
+// Tx is the set of operations available on regions, environments and apps.
+// It is implemented by every Store, and handed to the callbacks of View and
+// Update so several operations can be grouped into one transaction.
+//
+// The Update methods store an object under the name passed to them, and
+// nested environments and apps under their map keys. Name fields that
+// disagree with those are overwritten.
+type Tx interface {
+	// Revision returns the current store revision. Every transaction that
+	// changes a region, environment or app advances it by one and stamps
+	// the changed objects, and their parents, with the new value.
+	Revision() (uint64, error)
+
+	ListRegions() ([]Region, error)
+	GetRegion(region string) (Region, error)
+	CreateRegion(region Region) error
+	UpdateRegion(name string, region Region) error
+	DeleteRegion(region string) error
+	// SetPipeline replaces the promotion pipeline of a region.
+	SetPipeline(region string, stages []string) error
+
+	ListEnvironments(region string) ([]Environment, error)
+	GetEnvironment(region, environment string) (Environment, error)
+	CreateEnvironment(region string, environment Environment) error
+	UpdateEnvironment(region, name string, environment Environment) error
+	DeleteEnvironment(region, environment string) error
+
+	ListApps(region, environment string) ([]App, error)
+	GetApp(region, environment, app string) (App, error)
+	CreateApp(region, environment string, app App) error
+	UpdateApp(region, environment, name string, app App) error
+	DeleteApp(region, environment, app string) error
+
+	// AppendHistory records a history entry for the app named in it.
+	AppendHistory(entry HistoryEntry) error
+	// ListHistory returns the page of history entries for an app selected by
+	// query, newest first, together with the total number of entries in the
+	// time range.
+	ListHistory(region, environment, app string, query HistoryQuery) ([]HistoryEntry, int, error)
+
+	ListWebhooks() ([]Webhook, error)
+	GetWebhook(id string) (Webhook, error)
+	CreateWebhook(hook Webhook) error
+	UpdateWebhook(id string, hook Webhook) error
+	DeleteWebhook(id string) error
+
+	ListTokens() ([]Token, error)
+	GetToken(id string) (Token, error)
+	// FindToken returns the token whose secret has the given hash.
+	FindToken(hash string) (Token, error)
+	CreateToken(token Token) error
+	DeleteToken(id string) error
+
+	// ListLocks returns every lock, in force, lifted or not. Locks are
+	// deleted with the region or environment they apply to.
+	ListLocks() ([]Lock, error)
+	GetLock(id string) (Lock, error)
+	CreateLock(lock Lock) error
+	// RemoveLock lifts a lock, recording who did and when.
+	RemoveLock(id, removedBy string, removedAt time.Time) error
+}
+
+// Store is a storage backend for vhub data.
+type Store interface {
+	Tx
+
+	// View runs fn in a read-only transaction.
+	View(fn func(tx Tx) error) error
+	// Update runs fn in a read-write transaction. Changes made by fn are
+	// discarded if it returns an error.
+	Update(fn func(tx Tx) error) error
+	// OnChange registers fn to be called with the changes of every update
+	// committed from then on.
+	OnChange(fn ChangeFunc)
+	// Snapshot returns a copy of all data held by the store.
+	Snapshot() (Data, error)
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+func sortRegions(regions []Region) {
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Name < regions[j].Name })
+}
+
+func sortEnvironments(environments []Environment) {
+	sort.Slice(environments, func(i, j int) bool { return environments[i].Name < environments[j].Name })
+}
+
+func sortApps(apps []App) {
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+}
+
+// sortHistoryByVersion orders newest-first entries by version precedence,
+// keeping newer entries first among equal versions.
+func sortHistoryByVersion(entries []HistoryEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return semver.CompareStrings(entries[i].NewVersion, entries[j].NewVersion) > 0
+	})
+}
+
+// pageHistory returns the page of entries selected by the offset and limit
+// of query.
+func pageHistory(entries []HistoryEntry, query HistoryQuery) []HistoryEntry {
+	if query.Offset >= len(entries) {
+		return []HistoryEntry{}
+	}
+	entries = entries[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(entries) {
+		entries = entries[:query.Limit]
+	}
+	return entries
+}
+
+// removeStage returns stages without environment.
+func removeStage(stages []string, environment string) []string {
+	var kept []string
+	for _, stage := range stages {
+		if stage != environment {
+			kept = append(kept, stage)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,427 @@
+package data
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testStores runs fn against a fresh store of every backend, so that they
+// are held to the same behaviour.
+func testStores(t *testing.T, fn func(t *testing.T, s Store)) {
+	backends := []struct {
+		name string
+		open func(t *testing.T) Store
+	}{
+		{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+		{"json", func(t *testing.T) Store { return newTestJSONStore(t, t.TempDir()) }},
+		{"sqlite", func(t *testing.T) Store {
+			s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "vhub.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		}},
+	}
+	for _, backend := range backends {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			s := backend.open(t)
+			defer s.Close()
+			fn(t, s)
+		})
+	}
+}
+
+func testRegion() Region {
+	return Region{
+		Name: "amer",
+		Environments: map[string]Environment{
+			"dev": {Name: "dev", Apps: map[string]App{
+				"api": {Name: "api", Version: "1.0.0", Route: "/api", Date: "2026-10-01"},
+			}},
+			"prod": {Name: "prod", Apps: map[string]App{}},
+		},
+		Pipeline: []string{"dev", "prod"},
+	}
+}
+
+func mustUpdate(t *testing.T, s Store, fn func(tx Tx) error) {
+	t.Helper()
+	if err := s.Update(fn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreCRUD(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		mustUpdate(t, s, func(tx Tx) error { return tx.CreateRegion(testRegion()) })
+
+		region, err := s.GetRegion("amer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(region.Environments) != 2 || !reflect.DeepEqual(region.Pipeline, []string{"dev", "prod"}) {
+			t.Fatalf("GetRegion = %+v", region)
+		}
+		app, err := s.GetApp("amer", "dev", "api")
+		if err != nil || app.Version != "1.0.0" || app.Route != "/api" || app.Date != "2026-10-01" {
+			t.Fatalf("GetApp = %+v, %v", app, err)
+		}
+
+		mustUpdate(t, s, func(tx Tx) error {
+			if err := tx.CreateEnvironment("amer", Environment{Name: "staging"}); err != nil {
+				return err
+			}
+			if err := tx.CreateApp("amer", "staging", App{Name: "web", Version: "2.0.0"}); err != nil {
+				return err
+			}
+			return tx.UpdateApp("amer", "dev", "api", App{Name: "api", Version: "1.1.0", Route: "/v2"})
+		})
+		environments, err := s.ListEnvironments("amer")
+		if err != nil || len(environments) != 3 || environments[1].Name != "prod" || environments[2].Name != "staging" {
+			t.Fatalf("ListEnvironments = %+v, %v", environments, err)
+		}
+		apps, err := s.ListApps("amer", "staging")
+		if err != nil || len(apps) != 1 || apps[0].Name != "web" || apps[0].Version != "2.0.0" {
+			t.Fatalf("ListApps = %+v, %v", apps, err)
+		}
+		if app, _ := s.GetApp("amer", "dev", "api"); app.Version != "1.1.0" || app.Route != "/v2" {
+			t.Fatalf("updated app = %+v", app)
+		}
+
+		errorTests := []struct {
+			name string
+			fn   func(tx Tx) error
+			want error
+		}{
+			{"region exists", func(tx Tx) error { return tx.CreateRegion(Region{Name: "amer"}) }, ErrRegionExists},
+			{"environment exists", func(tx Tx) error { return tx.CreateEnvironment("amer", Environment{Name: "dev"}) }, ErrEnvironmentExists},
+			{"app exists", func(tx Tx) error { return tx.CreateApp("amer", "dev", App{Name: "api"}) }, ErrAppExists},
+			{"region not found", func(tx Tx) error { return tx.UpdateRegion("emea", Region{Name: "emea"}) }, ErrRegionNotFound},
+			{"environment not found", func(tx Tx) error { return tx.DeleteEnvironment("amer", "qa") }, ErrEnvironmentNotFound},
+			{"app not found", func(tx Tx) error { return tx.UpdateApp("amer", "dev", "web", App{Name: "web"}) }, ErrAppNotFound},
+			{"app in missing environment", func(tx Tx) error { return tx.CreateApp("amer", "qa", App{Name: "web"}) }, ErrEnvironmentNotFound},
+			{"unknown pipeline stage", func(tx Tx) error { return tx.SetPipeline("amer", []string{"dev", "qa"}) }, ErrInvalidPipeline},
+		}
+		for _, tt := range errorTests {
+			if err := s.Update(tt.fn); !errors.Is(err, tt.want) {
+				t.Errorf("%s: Update = %v, want %v", tt.name, err, tt.want)
+			}
+		}
+
+		mustUpdate(t, s, func(tx Tx) error {
+			if err := tx.DeleteApp("amer", "dev", "api"); err != nil {
+				return err
+			}
+			return tx.DeleteEnvironment("amer", "prod")
+		})
+		if _, err := s.GetApp("amer", "dev", "api"); !errors.Is(err, ErrAppNotFound) {
+			t.Fatalf("GetApp after delete = %v", err)
+		}
+		if region, _ := s.GetRegion("amer"); !reflect.DeepEqual(region.Pipeline, []string{"dev"}) {
+			t.Fatalf("pipeline after deleting prod = %v", region.Pipeline)
+		}
+
+		mustUpdate(t, s, func(tx Tx) error { return tx.DeleteRegion("amer") })
+		if regions, err := s.ListRegions(); err != nil || len(regions) != 0 {
+			t.Fatalf("ListRegions after delete = %+v, %v", regions, err)
+		}
+	})
+}
+
+func TestStoreRevisions(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		mustUpdate(t, s, func(tx Tx) error { return tx.CreateRegion(testRegion()) })
+		mustUpdate(t, s, func(tx Tx) error {
+			return tx.UpdateApp("amer", "dev", "api", App{Name: "api", Version: "1.1.0"})
+		})
+
+		rev, err := s.Revision()
+		if err != nil || rev != 2 {
+			t.Fatalf("Revision = %d, %v, want 2", rev, err)
+		}
+		region, err := s.GetRegion("amer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]uint64{
+			"region": region.Revision,
+			"dev":    region.Environments["dev"].Revision,
+			"api":    region.Environments["dev"].Apps["api"].Revision,
+			"prod":   region.Environments["prod"].Revision,
+		}
+		want := map[string]uint64{"region": 2, "dev": 2, "api": 2, "prod": 1}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("revisions = %v, want %v", got, want)
+		}
+
+		// A transaction that changes nothing leaves the revision alone
+		mustUpdate(t, s, func(tx Tx) error {
+			_, err := tx.ListRegions()
+			return err
+		})
+		if rev, _ := s.Revision(); rev != 2 {
+			t.Fatalf("Revision after a read-only update = %d, want 2", rev)
+		}
+	})
+}
+
+func TestStoreRollsBackFailedUpdate(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		mustUpdate(t, s, func(tx Tx) error { return tx.CreateRegion(testRegion()) })
+
+		failure := errors.New("failed")
+		err := s.Update(func(tx Tx) error {
+			if err := tx.UpdateApp("amer", "dev", "api", App{Name: "api", Version: "9.9.9"}); err != nil {
+				return err
+			}
+			if err := tx.CreateRegion(Region{Name: "emea"}); err != nil {
+				return err
+			}
+			if err := tx.DeleteEnvironment("amer", "prod"); err != nil {
+				return err
+			}
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Fatalf("Update = %v, want %v", err, failure)
+		}
+
+		if app, _ := s.GetApp("amer", "dev", "api"); app.Version != "1.0.0" {
+			t.Fatalf("app after rollback = %+v", app)
+		}
+		if _, err := s.GetRegion("emea"); !errors.Is(err, ErrRegionNotFound) {
+			t.Fatalf("GetRegion of rolled back region = %v", err)
+		}
+		if _, err := s.GetEnvironment("amer", "prod"); err != nil {
+			t.Fatalf("GetEnvironment of rolled back deletion = %v", err)
+		}
+		if rev, _ := s.Revision(); rev != 1 {
+			t.Fatalf("Revision after rollback = %d, want 1", rev)
+		}
+	})
+}
+
+func TestStoreNamesObjectsByKey(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		mustUpdate(t, s, func(tx Tx) error { return tx.CreateRegion(testRegion()) })
+
+		mustUpdate(t, s, func(tx Tx) error {
+			return tx.UpdateRegion("amer", Region{
+				Name: "emea",
+				Environments: map[string]Environment{
+					"dev": {Name: "qa", Apps: map[string]App{
+						"api": {Name: "web", Version: "1.0.0"},
+						"db":  {Version: "5.0.0"},
+					}},
+				},
+			})
+		})
+		region, err := s.GetRegion("amer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dev, ok := region.Environments["dev"]
+		if region.Name != "amer" || len(region.Environments) != 1 || !ok || dev.Name != "dev" {
+			t.Fatalf("region after UpdateRegion = %+v", region)
+		}
+		if dev.Apps["api"].Name != "api" || dev.Apps["db"].Name != "db" || len(dev.Apps) != 2 {
+			t.Fatalf("apps after UpdateRegion = %+v", dev.Apps)
+		}
+
+		mustUpdate(t, s, func(tx Tx) error {
+			return tx.UpdateEnvironment("amer", "dev", Environment{Name: "prod", Apps: map[string]App{
+				"api": {Name: "web", Version: "2.0.0"},
+			}})
+		})
+		environment, err := s.GetEnvironment("amer", "dev")
+		if err != nil || environment.Name != "dev" || len(environment.Apps) != 1 || environment.Apps["api"].Name != "api" {
+			t.Fatalf("GetEnvironment after UpdateEnvironment = %+v, %v", environment, err)
+		}
+
+		mustUpdate(t, s, func(tx Tx) error {
+			return tx.UpdateApp("amer", "dev", "api", App{Name: "web", Version: "3.0.0"})
+		})
+		apps, err := s.ListApps("amer", "dev")
+		if err != nil || len(apps) != 1 || apps[0].Name != "api" || apps[0].Version != "3.0.0" {
+			t.Fatalf("ListApps after UpdateApp = %+v, %v", apps, err)
+		}
+	})
+}
+
+func TestStoreHistory(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+		versions := []string{"1.0.0", "1.2.0", "1.1.0"}
+		mustUpdate(t, s, func(tx Tx) error {
+			for i, version := range versions {
+				entry := HistoryEntry{Region: "amer", Environment: "dev", App: "api", Action: HistoryActionUpdate,
+					NewVersion: version, Timestamp: start.Add(time.Duration(i) * time.Hour), Actor: "alice"}
+				if err := tx.AppendHistory(entry); err != nil {
+					return err
+				}
+			}
+			return tx.AppendHistory(HistoryEntry{Region: "amer", Environment: "dev", App: "web", NewVersion: "9.0.0", Timestamp: start})
+		})
+
+		listVersions := func(query HistoryQuery) ([]string, int) {
+			t.Helper()
+			var entries []HistoryEntry
+			var total int
+			err := s.View(func(tx Tx) (err error) {
+				entries, total, err = tx.ListHistory("amer", "dev", "api", query)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, e := range entries {
+				got = append(got, e.NewVersion)
+			}
+			return got, total
+		}
+
+		tests := []struct {
+			name  string
+			query HistoryQuery
+			want  []string
+			total int
+		}{
+			{"all", HistoryQuery{}, []string{"1.1.0", "1.2.0", "1.0.0"}, 3},
+			{"page", HistoryQuery{Offset: 1, Limit: 1}, []string{"1.2.0"}, 3},
+			{"past the end", HistoryQuery{Offset: 5}, []string{}, 3},
+			{"from", HistoryQuery{From: start.Add(time.Hour)}, []string{"1.1.0", "1.2.0"}, 2},
+			{"to", HistoryQuery{To: start.Add(time.Hour)}, []string{"1.2.0", "1.0.0"}, 2},
+			{"by version", HistoryQuery{SortByVersion: true}, []string{"1.2.0", "1.1.0", "1.0.0"}, 3},
+		}
+		for _, tt := range tests {
+			if got, total := listVersions(tt.query); !reflect.DeepEqual(got, tt.want) || total != tt.total {
+				t.Errorf("%s: ListHistory = %v, %d, want %v, %d", tt.name, got, total, tt.want, tt.total)
+			}
+		}
+	})
+}
+
+func TestStoreLocks(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		createdAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+		end := createdAt.Add(24 * time.Hour)
+		mustUpdate(t, s, func(tx Tx) error {
+			if err := tx.CreateRegion(testRegion()); err != nil {
+				return err
+			}
+			if err := tx.CreateLock(Lock{ID: "region", Region: "amer", CreatedBy: "alice", CreatedAt: createdAt}); err != nil {
+				return err
+			}
+			return tx.CreateLock(Lock{ID: "prod", Region: "amer", Environment: "prod", Reason: "release",
+				End: &end, CreatedBy: "alice", CreatedAt: createdAt})
+		})
+
+		lock, err := s.GetLock("prod")
+		if err != nil || lock.Reason != "release" || lock.End == nil || !lock.End.Equal(end) || !lock.CreatedAt.Equal(createdAt) {
+			t.Fatalf("GetLock = %+v, %v", lock, err)
+		}
+
+		errorTests := []struct {
+			name string
+			fn   func(tx Tx) error
+			want error
+		}{
+			{"exists", func(tx Tx) error { return tx.CreateLock(Lock{ID: "prod", Region: "amer"}) }, ErrLockExists},
+			{"invalid", func(tx Tx) error { return tx.CreateLock(Lock{ID: "x"}) }, ErrInvalidLock},
+			{"missing region", func(tx Tx) error { return tx.CreateLock(Lock{ID: "x", Region: "emea"}) }, ErrRegionNotFound},
+			{"missing environment", func(tx Tx) error { return tx.CreateLock(Lock{ID: "x", Region: "amer", Environment: "qa"}) }, ErrEnvironmentNotFound},
+			{"remove unknown", func(tx Tx) error { return tx.RemoveLock("x", "bob", createdAt) }, ErrLockNotFound},
+		}
+		for _, tt := range errorTests {
+			if err := s.Update(tt.fn); !errors.Is(err, tt.want) {
+				t.Errorf("%s: Update = %v, want %v", tt.name, err, tt.want)
+			}
+		}
+
+		removedAt := createdAt.Add(time.Hour)
+		mustUpdate(t, s, func(tx Tx) error { return tx.RemoveLock("region", "bob", removedAt) })
+		if lock, _ := s.GetLock("region"); lock.RemovedBy != "bob" || lock.RemovedAt == nil || !lock.RemovedAt.Equal(removedAt) {
+			t.Fatalf("removed lock = %+v", lock)
+		}
+		if err := s.RemoveLock("region", "bob", removedAt); !errors.Is(err, ErrLockRemoved) {
+			t.Fatalf("RemoveLock twice = %v, want ErrLockRemoved", err)
+		}
+
+		// Locks go with the environment or region they apply to
+		mustUpdate(t, s, func(tx Tx) error { return tx.DeleteEnvironment("amer", "prod") })
+		if _, err := s.GetLock("prod"); !errors.Is(err, ErrLockNotFound) {
+			t.Fatalf("GetLock after deleting its environment = %v", err)
+		}
+		mustUpdate(t, s, func(tx Tx) error { return tx.DeleteRegion("amer") })
+		if locks, err := s.ListLocks(); err != nil || len(locks) != 0 {
+			t.Fatalf("ListLocks after deleting the region = %+v, %v", locks, err)
+		}
+	})
+}
+
+func TestStoreTokens(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		createdAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+		token := Token{ID: "t1", Name: "ci", Hash: HashToken("secret"), Scopes: []string{"write:amer/dev"}, CreatedAt: createdAt}
+		mustUpdate(t, s, func(tx Tx) error { return tx.CreateToken(token) })
+
+		found, err := s.FindToken(HashToken("secret"))
+		if err != nil || !reflect.DeepEqual(found, token) {
+			t.Fatalf("FindToken = %+v, %v, want %+v", found, err, token)
+		}
+		if _, err := s.FindToken(HashToken("other")); !errors.Is(err, ErrTokenNotFound) {
+			t.Fatalf("FindToken of an unknown secret = %v", err)
+		}
+
+		errorTests := []struct {
+			name  string
+			token Token
+			want  error
+		}{
+			{"same id", Token{ID: "t1", Name: "other", Hash: HashToken("other"), Scopes: []string{"read"}}, ErrTokenExists},
+			{"same secret", Token{ID: "t2", Name: "other", Hash: HashToken("secret"), Scopes: []string{"read"}}, ErrTokenExists},
+			{"same name", Token{ID: "t2", Name: "ci", Hash: HashToken("other"), Scopes: []string{"read"}}, ErrTokenNameUsed},
+			{"invalid scope", Token{ID: "t2", Name: "other", Hash: HashToken("other"), Scopes: []string{"root"}}, ErrInvalidToken},
+		}
+		for _, tt := range errorTests {
+			if err := s.CreateToken(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("%s: CreateToken = %v, want %v", tt.name, err, tt.want)
+			}
+		}
+
+		mustUpdate(t, s, func(tx Tx) error { return tx.DeleteToken("t1") })
+		if tokens, err := s.ListTokens(); err != nil || len(tokens) != 0 {
+			t.Fatalf("ListTokens after delete = %+v, %v", tokens, err)
+		}
+		if err := s.DeleteToken("t1"); !errors.Is(err, ErrTokenNotFound) {
+			t.Fatalf("DeleteToken twice = %v", err)
+		}
+	})
+}
+
+func TestImportJSONFileSkipsNonEmptyStore(t *testing.T) {
+	source := newTestJSONStore(t, t.TempDir())
+	mustUpdate(t, source, func(tx Tx) error { return tx.CreateRegion(testRegion()) })
+	if err := source.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	testStores(t, func(t *testing.T, s Store) {
+		for i := 0; i < 2; i++ {
+			if err := ImportJSONFile(s, source.FilePath); err != nil {
+				t.Fatalf("import %d: %v", i+1, err)
+			}
+		}
+		if app, err := s.GetApp("amer", "dev", "api"); err != nil || app.Version != "1.0.0" {
+			t.Fatalf("GetApp after import = %+v, %v", app, err)
+		}
+		if rev, _ := s.Revision(); rev != 1 {
+			t.Fatalf("Revision after importing twice = %d, want 1", rev)
+		}
+	})
+}
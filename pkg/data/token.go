@@ -0,0 +1,140 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenNotFound = errors.New("token not found")
+	ErrTokenExists   = errors.New("token already exists")
+	ErrTokenNameUsed = errors.New("token name already in use")
+	ErrInvalidToken  = errors.New("invalid token")
+)
+
+const (
+	ActionRead  = "read"
+	ActionWrite = "write"
+	ActionAdmin = "admin"
+)
+
+// Token is an API bearer token. Only the SHA-256 hash of the secret is
+// stored.
+type Token struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Hash string `json:"hash,omitempty"`
+	// Scopes lists what the token may do: read, admin, write, or write
+	// limited to a region (write:region) or an environment
+	// (write:region/environment).
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+	// ExpiresAt is the zero time for tokens that do not expire.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Permission is what a request needs to be allowed. Region and Environment
+// name the part of the data a write touches.
+type Permission struct {
+	Action      string `json:"action"`
+	Region      string `json:"region,omitempty"`
+	Environment string `json:"environment,omitempty"`
+}
+
+// NewID returns a random 128-bit identifier, hex encoded.
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// HashToken returns the hash under which the secret of a token is stored.
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expired reports whether t has expired at now.
+func (t Token) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// Validate checks that t has a name, a hash and only known scopes. A token
+// without scopes relies on the roles bound to its name, as token:<name>, so
+// the name may not contain a colon and pass for a subject prefix such as
+// group:.
+func (t Token) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidToken)
+	}
+	if strings.Contains(t.Name, ":") {
+		return fmt.Errorf("%w: name %q may not contain a colon", ErrInvalidToken, t.Name)
+	}
+	if t.Hash == "" {
+		return fmt.Errorf("%w: hash is required", ErrInvalidToken)
+	}
+	for _, scope := range t.Scopes {
+		if err := validateScope(scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateScope(scope string) error {
+	switch scope {
+	case ActionRead, ActionWrite, ActionAdmin:
+		return nil
+	}
+	target, ok := strings.CutPrefix(scope, ActionWrite+":")
+	if !ok || target == "" {
+		return fmt.Errorf("%w: unknown scope %q", ErrInvalidToken, scope)
+	}
+	region, environment, _ := strings.Cut(target, "/")
+	if region == "" || strings.Contains(environment, "/") {
+		return fmt.Errorf("%w: scope %q must be write:region or write:region/environment", ErrInvalidToken, scope)
+	}
+	return nil
+}
+
+// ScopesAllow reports whether scopes grant p. Any write scope also grants
+// read; admin grants everything.
+func ScopesAllow(scopes []string, p Permission) bool {
+	for _, scope := range scopes {
+		switch {
+		case scope == ActionAdmin:
+			return true
+		case p.Action == ActionRead && (scope == ActionRead || scope == ActionWrite || strings.HasPrefix(scope, ActionWrite+":")):
+			return true
+		case p.Action == ActionWrite && scope == ActionWrite:
+			return true
+		case p.Action == ActionWrite && strings.HasPrefix(scope, ActionWrite+":"):
+			region, environment, scoped := strings.Cut(strings.TrimPrefix(scope, ActionWrite+":"), "/")
+			if region != p.Region || p.Region == "" {
+				continue
+			}
+			if !scoped || environment == p.Environment {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Clone returns a deep copy of t.
+func (t Token) Clone() Token {
+	t.Scopes = append([]string(nil), t.Scopes...)
+	return t
+}
+
+func sortTokens(tokens []Token) {
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+}
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrWebhookExists   = errors.New("webhook already exists")
+	ErrInvalidWebhook  = errors.New("invalid webhook")
+)
+
+// Webhook is an HTTP endpoint that is sent the changes it subscribes to.
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret is the key used to sign payloads with HMAC-SHA256.
+	Secret string `json:"secret,omitempty"`
+	// Regions, Environments and Apps restrict the changes sent to the hook.
+	// An empty list matches everything.
+	Regions      []string `json:"regions,omitempty"`
+	Environments []string `json:"environments,omitempty"`
+	Apps         []string `json:"apps,omitempty"`
+	// Events lists the event types sent to the hook, such as app.updated.
+	// An empty list matches every event type.
+	Events    []string  `json:"events,omitempty"`
+	Disabled  bool      `json:"disabled,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// EventType names the kind of a change and what happened to it, e.g.
+// app.updated.
+func EventType(c Change) string {
+	return c.Kind + "." + c.Action
+}
+
+// EventTypes lists every event type a webhook can subscribe to.
+func EventTypes() []string {
+	var types []string
+	for _, kind := range []string{KindRegion, KindEnvironment, KindApp} {
+		for _, action := range []string{ChangeCreated, ChangeUpdated, ChangeDeleted} {
+			types = append(types, kind+"."+action)
+		}
+	}
+	return types
+}
+
+// Validate checks that h has an absolute http(s) URL and only subscribes to
+// known event types.
+func (h Webhook) Validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	known := EventTypes()
+	for _, event := range h.Events {
+		if !matches(known, event) {
+			return fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, event)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether c should be sent to h. Changes to a region or
+// environment as a whole match filters on anything inside it.
+func (h Webhook) Matches(c Change) bool {
+	return !h.Disabled &&
+		matches(h.Events, EventType(c)) &&
+		(c.Region == "" || matches(h.Regions, c.Region)) &&
+		(c.Environment == "" || matches(h.Environments, c.Environment)) &&
+		(c.App == "" || matches(h.Apps, c.App))
+}
+
+// Clone returns a deep copy of h.
+func (h Webhook) Clone() Webhook {
+	h.Regions = append([]string(nil), h.Regions...)
+	h.Environments = append([]string(nil), h.Environments...)
+	h.Apps = append([]string(nil), h.Apps...)
+	h.Events = append([]string(nil), h.Events...)
+	return h
+}
+
+func sortWebhooks(hooks []Webhook) {
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+}
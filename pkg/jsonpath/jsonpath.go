@@ -0,0 +1,124 @@
+// Package jsonpath evaluates simple JSONPath expressions: $ followed by
+// member steps written .name or ['name'] and array index steps written [n].
+// A negative index counts from the end of the array.
+package jsonpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a parsed JSONPath expression.
+type Path struct {
+	expr  string
+	steps []step
+}
+
+// step selects a member of an object by name, or an element of an array by
+// index if name is empty.
+type step struct {
+	name  string
+	index int
+}
+
+// Parse parses a JSONPath expression such as $.status or
+// $.components['db'].checks[0].
+func Parse(expr string) (Path, error) {
+	p := Path{expr: expr}
+	rest, ok := strings.CutPrefix(strings.TrimSpace(expr), "$")
+	if !ok {
+		return Path{}, fmt.Errorf("JSONPath %q must start with $", expr)
+	}
+
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" {
+				return Path{}, fmt.Errorf("JSONPath %q has an empty member name", expr)
+			}
+			p.steps = append(p.steps, step{name: name})
+			rest = rest[end+1:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return Path{}, fmt.Errorf("JSONPath %q has an unclosed [", expr)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				p.steps = append(p.steps, step{name: inner[1 : len(inner)-1]})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return Path{}, fmt.Errorf("JSONPath %q has an invalid index [%s]", expr, inner)
+				}
+				p.steps = append(p.steps, step{index: index})
+			}
+			rest = rest[end+1:]
+		default:
+			return Path{}, fmt.Errorf("JSONPath %q is invalid at %q", expr, rest)
+		}
+	}
+	return p, nil
+}
+
+func (p Path) String() string {
+	return p.expr
+}
+
+// Get returns the value p selects in doc, a document decoded by
+// encoding/json into interface{}, and whether there is one.
+func (p Path) Get(doc interface{}) (interface{}, bool) {
+	value := doc
+	for _, s := range p.steps {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if s.name == "" {
+				return nil, false
+			}
+			var ok bool
+			if value, ok = v[s.name]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			if s.name != "" {
+				return nil, false
+			}
+			index := s.index
+			if index < 0 {
+				index += len(v)
+			}
+			if index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Lookup decodes a JSON document and returns the value p selects in it as a
+// string: strings as they are, other values in their JSON encoding.
+func (p Path) Lookup(body []byte) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", fmt.Errorf("response is not JSON: %w", err)
+	}
+	value, ok := p.Get(doc)
+	if !ok {
+		return "", fmt.Errorf("%s not found in response", p.expr)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(value)
+	return string(b), err
+}
@@ -0,0 +1,108 @@
+package jsonpath
+
+import "testing"
+
+const document = `{
+	"status": "UP",
+	"version": "1.4.2",
+	"uptime": 3600,
+	"ready": true,
+	"build": null,
+	"components": {
+		"db": {"status": "UP", "checks": [{"name": "ping", "ok": true}, {"name": "pool", "ok": false}]},
+		"cache.redis": {"status": "DOWN"}
+	},
+	"tags": ["blue", "canary"]
+}`
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"$.status", "UP"},
+		{"$.version", "1.4.2"},
+		{"$.uptime", "3600"},
+		{"$.ready", "true"},
+		{"$.build", "null"},
+		{"$.components.db.status", "UP"},
+		{"$.components['db'].checks[0].name", "ping"},
+		{`$.components["db"].checks[1].ok`, "false"},
+		{"$.components['cache.redis'].status", "DOWN"},
+		{"$.components.db.checks[-1].name", "pool"},
+		{"$.tags[1]", "canary"},
+		{"$.tags", `["blue","canary"]`},
+		{"$['tags'][0]", "blue"},
+		{" $.status ", "UP"},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		got, err := p.Lookup([]byte(document))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestLookupWholeDocument(t *testing.T) {
+	p, err := Parse("$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.Lookup([]byte(`"ok"`)); err != nil || got != "ok" {
+		t.Fatalf("$ = %q, %v, want ok", got, err)
+	}
+}
+
+func TestLookupNotFound(t *testing.T) {
+	for _, expr := range []string{
+		"$.missing",
+		"$.status.inner",
+		"$.tags[2]",
+		"$.tags[-3]",
+		"$.tags.first",
+		"$.components[0]",
+	} {
+		p, err := Parse(expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", expr, err)
+		}
+		if got, err := p.Lookup([]byte(document)); err == nil {
+			t.Fatalf("%s = %s, want an error", expr, got)
+		}
+	}
+}
+
+func TestLookupInvalidJSON(t *testing.T) {
+	p, err := Parse("$.status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Lookup([]byte("<html>")); err == nil {
+		t.Fatal("Lookup of a non-JSON body succeeded")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"status",
+		".status",
+		"$.",
+		"$.a..b",
+		"$[0",
+		"$[x]",
+		"$['a]",
+		"$status",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Fatalf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+// Package oidc validates the JWTs issued by an OpenID Connect identity
+// provider against its JSON Web Key Set.
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"vhub/pkg/data"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+const (
+	// jwksRefreshInterval is how long keys fetched from a URL are trusted
+	// before the set is fetched again.
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefresh limits how often tokens signed with an unknown key make
+	// the set be fetched again.
+	jwksMinRefresh = time.Minute
+	fetchTimeout   = 10 * time.Second
+)
+
+// Config describes the identity provider tokens are accepted from.
+type Config struct {
+	Issuer   string
+	Audience string
+	// JWKS is the path or http(s) URL of the key set. If empty, it is
+	// discovered from the issuer's openid-configuration.
+	JWKS string
+	// SubjectClaim names the claim identifying the caller, sub by default.
+	SubjectClaim string
+	// GroupsClaim names the claim listing the caller's groups, groups by
+	// default. Nested claims are named with dots, e.g. realm_access.roles.
+	GroupsClaim string
+	// Leeway allows for clock skew when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// Identity is who a valid token was issued to.
+type Identity struct {
+	Subject   string
+	Groups    []string
+	ExpiresAt time.Time
+}
+
+// Verifier checks the signature and claims of tokens.
+type Verifier struct {
+	config Config
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	loaded  time.Time
+	modTime time.Time
+}
+
+func NewVerifier(config Config) (*Verifier, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, fmt.Errorf("an issuer and an audience are required")
+	}
+	if config.SubjectClaim == "" {
+		config.SubjectClaim = "sub"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+
+	v := &Verifier{config: config, client: &http.Client{Timeout: fetchTimeout}}
+	if v.config.JWKS == "" {
+		uri, err := v.discover()
+		if err != nil {
+			return nil, fmt.Errorf("discovering the key set of %s: %w", config.Issuer, err)
+		}
+		v.config.JWKS = uri
+	}
+	if err := v.load(); err != nil {
+		return nil, fmt.Errorf("loading the key set %s: %w", v.config.JWKS, err)
+	}
+	return v, nil
+}
+
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+func (v *Verifier) fetch(url string) ([]byte, error) {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func (v *Verifier) discover() (string, error) {
+	body, err := v.fetch(strings.TrimSuffix(v.config.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(body, &discovery); err != nil {
+		return "", err
+	}
+	if discovery.JWKSURI == "" {
+		return "", fmt.Errorf("no jwks_uri in the openid-configuration")
+	}
+	return discovery.JWKSURI, nil
+}
+
+// load reads the key set. It is called with v.mu held, except by NewVerifier.
+func (v *Verifier) load() error {
+	var body []byte
+	var err error
+	if isURL(v.config.JWKS) {
+		body, err = v.fetch(v.config.JWKS)
+	} else {
+		var info os.FileInfo
+		if info, err = os.Stat(v.config.JWKS); err == nil {
+			v.modTime = info.ModTime()
+			body, err = os.ReadFile(v.config.JWKS)
+		}
+	}
+	v.loaded = time.Now()
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(body)
+	if err != nil {
+		return err
+	}
+	v.keys = keys
+	return nil
+}
+
+// stale reports whether the key set should be loaded again. Files are reloaded
+// when they change; URLs when the set is old or a key is missing from it.
+func (v *Verifier) stale(missing bool) bool {
+	if !isURL(v.config.JWKS) {
+		info, err := os.Stat(v.config.JWKS)
+		return err == nil && !info.ModTime().Equal(v.modTime)
+	}
+	age := time.Since(v.loaded)
+	return age > jwksRefreshInterval || missing && age > jwksMinRefresh
+}
+
+func (v *Verifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key, ok := v.lookup(kid)
+	if v.stale(!ok) {
+		if err := v.load(); err != nil {
+			data.Log.WithField("jwks", v.config.JWKS).WithField("error", err).Warn("Failed to reload key set")
+		}
+		key, ok = v.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+func (v *Verifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// jwk is a JSON Web Key as defined by RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signing keys of a key set by ID. Keys of unsupported
+// types are skipped.
+func parseJWKS(body []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// Verify checks the signature, issuer, audience and lifetime of a token and
+// returns whom it identifies.
+func (v *Verifier) Verify(token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return Identity{}, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return Identity{}, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, err
+	}
+	return v.checkClaims(claims, time.Now())
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil || json.Unmarshal(b, v) != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	return nil
+}
+
+func (v *Verifier) checkClaims(claims map[string]interface{}, now time.Time) (Identity, error) {
+	if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+		return Identity{}, fmt.Errorf("%w: issued by %q", ErrInvalidToken, iss)
+	}
+	if !contains(stringList(claims["aud"]), v.config.Audience) {
+		return Identity{}, fmt.Errorf("%w: not issued for audience %q", ErrInvalidToken, v.config.Audience)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	expiresAt := time.Unix(int64(exp), 0)
+	if now.After(expiresAt.Add(v.config.Leeway)) {
+		return Identity{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return Identity{}, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+
+	subject, _ := claim(claims, v.config.SubjectClaim).(string)
+	if subject == "" {
+		return Identity{}, fmt.Errorf("%w: no %s claim", ErrInvalidToken, v.config.SubjectClaim)
+	}
+	return Identity{
+		Subject:   subject,
+		Groups:    stringList(claim(claims, v.config.GroupsClaim)),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// claim looks up a claim by its dotted path.
+func claim(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// stringList returns a claim that is a string or a list of strings as a list.
+func stringList(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var list []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
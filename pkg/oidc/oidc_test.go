@@ -0,0 +1,323 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	issuer   = "https://idp.example.com"
+	audience = "vhub"
+)
+
+// testKeys are the private keys of a test identity provider, by key ID.
+type testKeys map[string]crypto.Signer
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{"rsa": rsaKey, "p256": p256, "p384": p384, "ed": ed}
+}
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// writeJWKS writes the public keys of keys to a key set file and returns its
+// path.
+func writeJWKS(t *testing.T, path string, keys testKeys) string {
+	t.Helper()
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range keys {
+		k := jwk{Kid: kid, Use: "sig"}
+		switch pub := key.Public().(type) {
+		case *rsa.PublicKey:
+			k.Kty, k.N, k.E = "RSA", encodeInt(pub.N), encodeInt(big.NewInt(int64(pub.E)))
+		case *ecdsa.PublicKey:
+			k.Kty, k.Crv, k.X, k.Y = "EC", pub.Curve.Params().Name, encodeInt(pub.X), encodeInt(pub.Y)
+		case ed25519.PublicKey:
+			k.Kty, k.Crv, k.X = "OKP", "Ed25519", base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, k)
+	}
+	b, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func segment(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign returns a token with the given header and claims signed by key with
+// alg. HS256 uses a fixed HMAC secret and none leaves the token unsigned.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signed := segment(t, header) + "." + segment(t, claims)
+
+	var signature []byte
+	var err error
+	switch alg {
+	case "none":
+	case "HS256":
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "EdDSA":
+		signature = ed25519.Sign(key.(ed25519.PrivateKey), []byte(signed))
+	default:
+		hash := hashes[alg]
+		h := hash.New()
+		h.Write([]byte(signed))
+		digest := h.Sum(nil)
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			if alg[0] == 'P' {
+				signature, err = rsa.SignPSS(rand.Reader, key, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			} else {
+				signature, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+			}
+		case *ecdsa.PrivateKey:
+			var r, s *big.Int
+			r, s, err = ecdsa.Sign(rand.Reader, key, digest)
+			size := (key.Curve.Params().BitSize + 7) / 8
+			signature = make([]byte, 2*size)
+			r.FillBytes(signature[:size])
+			s.FillBytes(signature[size:])
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// claims returns valid claims for a token issued now, changed by set.
+func claims(set map[string]interface{}) map[string]interface{} {
+	now := time.Now()
+	c := map[string]interface{}{
+		"iss":    issuer,
+		"aud":    audience,
+		"sub":    "alice",
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Hour).Unix(),
+		"groups": []string{"dev", "ops"},
+	}
+	for name, value := range set {
+		if value == nil {
+			delete(c, name)
+		} else {
+			c[name] = value
+		}
+	}
+	return c
+}
+
+func newTestVerifier(t *testing.T, keys testKeys, config Config) *Verifier {
+	t.Helper()
+	config.Issuer = issuer
+	config.Audience = audience
+	if config.JWKS == "" {
+		config.JWKS = writeJWKS(t, filepath.Join(t.TempDir(), "jwks.json"), keys)
+	}
+	v, err := NewVerifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, keys, Config{Leeway: time.Minute})
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256", sign(t, "RS256", "rsa", keys["rsa"], claims(nil)), true},
+		{"RS512", sign(t, "RS512", "rsa", keys["rsa"], claims(nil)), true},
+		{"PS256", sign(t, "PS256", "rsa", keys["rsa"], claims(nil)), true},
+		{"ES256", sign(t, "ES256", "p256", keys["p256"], claims(nil)), true},
+		{"ES384", sign(t, "ES384", "p384", keys["p384"], claims(nil)), true},
+		{"EdDSA", sign(t, "EdDSA", "ed", keys["ed"], claims(nil)), true},
+		{"audience list", sign(t, "RS256", "rsa", keys["rsa"], claims(map[string]interface{}{"aud": []string{"other", audience}})), true},
+
+		{"signed by another key", sign(t, "RS256", "p256", keys["rsa"], claims(nil)), false},
+		{"none", sign(t, "none", "rsa", nil, claims(nil)), false},
+		{"HS256", sign(t, "HS256", "rsa", nil, claims(nil)), false},
+		{"ES256 with a P-384 key", sign(t, "ES256", "p384", keys["p384"], claims(nil)), false},
+		{"RS256 with an EC key", sign(t, "RS256", "p256", keys["p256"], claims(nil)), false},
+		{"unknown kid", sign(t, "RS256", "gone", keys["rsa"], claims(nil)), false},
+		{"no kid with several keys", sign(t, "RS256", "", keys["rsa"], claims(nil)), false},
+		{"malformed", "not.a-token", false},
+
+		{"wrong issuer", sign(t, "RS256", "rsa", keys["rsa"], claims(map[string]interface{}{"iss": "https://evil.example.com"})), false},
+		{"wrong audience", sign(t, "RS256", "rsa", keys["rsa"], claims(map[string]interface{}{"aud": "other"})), false},
+		{"no expiry", sign(t, "RS256", "rsa", keys["rsa"], claims(map[string]interface{}{"exp": nil})), false},
+		{"no subject", sign(t, "RS256", "rsa", keys["rsa"], claims(map[string]interface{}{"sub": nil})), false},
+		{"expired within leeway", sign(t, "RS256", "rsa", keys["rsa"], claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), true},
+		{"expired", sign(t, "RS256", "rsa", keys["rsa"], claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), false},
+		{"not valid yet within leeway", sign(t, "RS256", "rsa", keys["rsa"], claims(map[string]interface{}{"nbf": now.Add(30 * time.Second).Unix()})), true},
+		{"not valid yet", sign(t, "RS256", "rsa", keys["rsa"], claims(map[string]interface{}{"nbf": now.Add(2 * time.Minute).Unix()})), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := v.Verify(tt.token)
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Verify = %+v, %v, want ErrInvalidToken", identity, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Subject != "alice" || !reflect.DeepEqual(identity.Groups, []string{"dev", "ops"}) {
+				t.Fatalf("identity = %+v", identity)
+			}
+		})
+	}
+}
+
+func TestVerifyTamperedPayload(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, keys, Config{})
+
+	token := sign(t, "RS256", "rsa", keys["rsa"], claims(nil))
+	forged := sign(t, "RS256", "rsa", keys["rsa"], claims(map[string]interface{}{"sub": "mallory"}))
+	header, signature := token[:strings.Index(token, ".")], token[strings.LastIndex(token, "."):]
+	payload := forged[strings.Index(forged, "."):strings.LastIndex(forged, ".")]
+
+	if _, err := v.Verify(header + payload + signature); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyWithoutKid(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, testKeys{"ed": keys["ed"]}, Config{})
+
+	if _, err := v.Verify(sign(t, "EdDSA", "", keys["ed"], claims(nil))); err != nil {
+		t.Fatalf("token without kid from a set of one key: %v", err)
+	}
+}
+
+func TestVerifyClaimNames(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, keys, Config{SubjectClaim: "email", GroupsClaim: "realm_access.roles"})
+
+	token := sign(t, "ES256", "p256", keys["p256"], claims(map[string]interface{}{
+		"email":        "alice@example.com",
+		"realm_access": map[string]interface{}{"roles": []string{"admin"}},
+	}))
+	identity, err := v.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "alice@example.com" || !reflect.DeepEqual(identity.Groups, []string{"admin"}) {
+		t.Fatalf("identity = %+v", identity)
+	}
+
+	// A groups claim that is a single string is a list of one
+	token = sign(t, "ES256", "p256", keys["p256"], claims(map[string]interface{}{
+		"email":        "alice@example.com",
+		"realm_access": map[string]interface{}{"roles": "admin"},
+	}))
+	if identity, err = v.Verify(token); err != nil || !reflect.DeepEqual(identity.Groups, []string{"admin"}) {
+		t.Fatalf("identity = %+v, %v", identity, err)
+	}
+}
+
+func TestVerifyReloadsChangedKeySet(t *testing.T) {
+	keys := newTestKeys(t)
+	path := writeJWKS(t, filepath.Join(t.TempDir(), "jwks.json"), testKeys{"rsa": keys["rsa"]})
+	v := newTestVerifier(t, nil, Config{JWKS: path})
+
+	token := sign(t, "EdDSA", "ed", keys["ed"], claims(nil))
+	if _, err := v.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify before rotation = %v, want ErrInvalidToken", err)
+	}
+
+	writeJWKS(t, path, testKeys{"ed": keys["ed"]})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("Verify after rotation = %v", err)
+	}
+}
+
+func TestNewVerifierErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.json")
+	if err := os.WriteFile(empty, []byte(`{"keys":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offCurve := filepath.Join(dir, "off-curve.json")
+	if err := os.WriteFile(offCurve, []byte(`{"keys":[{"kty":"EC","kid":"x","crv":"P-256","x":"AQ","y":"AQ"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, config := range []Config{
+		{Audience: audience, JWKS: empty},
+		{Issuer: issuer, JWKS: empty},
+		{Issuer: issuer, Audience: audience, JWKS: filepath.Join(dir, "missing.json")},
+		{Issuer: issuer, Audience: audience, JWKS: empty},
+		{Issuer: issuer, Audience: audience, JWKS: offCurve},
+	} {
+		if _, err := NewVerifier(config); err == nil {
+			t.Fatalf("NewVerifier(%+v) succeeded", config)
+		}
+	}
+}
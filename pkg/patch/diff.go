@@ -0,0 +1,83 @@
+package patch
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Diff returns a JSON patch that turns doc into target. Objects are compared
+// member by member; arrays and other values that differ are replaced whole.
+// A null doc or target is treated as missing, so the patch adds or removes
+// the whole document.
+func Diff(doc, target []byte) ([]Operation, error) {
+	a, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decode(target)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []Operation{}
+	switch {
+	case a == nil && b == nil:
+	case a == nil:
+		ops = append(ops, operation("add", "", b))
+	case b == nil:
+		ops = append(ops, Operation{Op: "remove", Path: ""})
+	default:
+		diff(&ops, "", a, b)
+	}
+	return ops, nil
+}
+
+func diff(ops *[]Operation, path string, a, b interface{}) {
+	objectA, okA := a.(map[string]interface{})
+	objectB, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		if !reflect.DeepEqual(a, b) {
+			*ops = append(*ops, operation("replace", path, b))
+		}
+		return
+	}
+
+	keys := make([]string, 0, len(objectA)+len(objectB))
+	for key := range objectA {
+		keys = append(keys, key)
+	}
+	for key := range objectB {
+		if _, ok := objectA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		member := path + "/" + escapePointer(key)
+		valueA, inA := objectA[key]
+		valueB, inB := objectB[key]
+		switch {
+		case !inB:
+			*ops = append(*ops, Operation{Op: "remove", Path: member})
+		case !inA:
+			*ops = append(*ops, operation("add", member, valueB))
+		default:
+			diff(ops, member, valueA, valueB)
+		}
+	}
+}
+
+func operation(op, path string, value interface{}) Operation {
+	b, _ := json.Marshal(value)
+	raw := json.RawMessage(b)
+	return Operation{Op: op, Path: path, Value: &raw}
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func escapePointer(token string) string {
+	return pointerEscaper.Replace(token)
+}
@@ -0,0 +1,320 @@
+// Package patch applies RFC 7396 JSON merge patches and RFC 6902 JSON
+// patches to JSON documents.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned for malformed patches and for operations
+	// that cannot be applied to the document.
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrTestFailed is returned when a JSON patch test operation does not
+	// hold.
+	ErrTestFailed = errors.New("patch test failed")
+)
+
+// Operation is one operation of an RFC 6902 JSON patch.
+type Operation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from,omitempty"`
+	Value *json.RawMessage `json:"value,omitempty"`
+}
+
+func decode(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	// Keep numbers as written so large integers survive a round trip
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPatch, fmt.Sprintf(format, args...))
+}
+
+// MergePatch applies an RFC 7396 merge patch to doc: members of patch
+// replace those of doc, objects are merged recursively and null removes a
+// member.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decode(patch)
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = merge(t[key], value)
+	}
+	return t
+}
+
+// Apply applies an RFC 6902 JSON patch, a JSON array of operations, to doc.
+// The operations are applied in order and the patch fails as a whole if
+// any of them fails.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var ops []Operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, invalid("%v", err)
+	}
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		if target, err = op.apply(target); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+func (op Operation) value() (interface{}, error) {
+	if op.Value == nil {
+		return nil, invalid("missing value")
+	}
+	v, err := decode(*op.Value)
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	return v, nil
+}
+
+func (op Operation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "remove":
+		doc, _, err = remove(doc, path)
+		return doc, err
+	case "replace":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := get(doc, path); err != nil {
+			return nil, err
+		}
+		return set(doc, path, value)
+	case "move":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+			return nil, invalid("cannot move %s into one of its children", op.From)
+		}
+		doc, value, err := remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		// Copy the value so later operations cannot change both locations
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = decode(b); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "test":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	}
+	return nil, invalid("unknown operation %q", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, invalid("pointer %q does not start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, appending bool) (int, error) {
+	if appending && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, invalid("invalid array index %q", token)
+	}
+	max := length - 1
+	if appending {
+		max = length
+	}
+	if i > max {
+		return 0, invalid("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, invalid("member %q not found", token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, invalid("cannot descend into %q", token)
+		}
+	}
+	return doc, nil
+}
+
+// set replaces the value at an existing location and returns the new
+// document.
+func set(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[key] = value
+	case []interface{}:
+		i, err := arrayIndex(key, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	default:
+		return nil, invalid("cannot set %q", key)
+	}
+	return doc, nil
+}
+
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parentPath, key := path[:len(path)-1], path[len(path)-1]
+	parent, err := get(doc, parentPath)
+	if err != nil {
+		return nil, err
+	}
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[key] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(key, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return set(doc, parentPath, node)
+	}
+	return nil, invalid("cannot add %q", key)
+}
+
+func remove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, invalid("cannot remove the whole document")
+	}
+	parentPath, key := path[:len(path)-1], path[len(path)-1]
+	parent, err := get(doc, parentPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[key]
+		if !ok {
+			return nil, nil, invalid("member %q not found", key)
+		}
+		delete(node, key)
+		return doc, value, nil
+	case []interface{}:
+		i, err := arrayIndex(key, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = set(doc, parentPath, node)
+		return doc, value, err
+	}
+	return nil, nil, invalid("cannot remove %q", key)
+}
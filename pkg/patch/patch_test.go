@@ -0,0 +1,153 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// equalJSON reports whether two JSON documents hold the same value.
+func equalJSON(t *testing.T, a, b string) bool {
+	t.Helper()
+	var va, vb interface{}
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		t.Fatalf("invalid JSON %s: %v", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"add member", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`},
+		{"add replaces member", `{"a":1}`, `[{"op":"add","path":"/a","value":2}]`, `{"a":2}`},
+		{"add into array", `{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`},
+		{"add at array end", `{"a":[1,2]}`, `[{"op":"add","path":"/a/2","value":3}]`, `{"a":[1,2,3]}`},
+		{"append to array", `{"a":[1,2]}`, `[{"op":"add","path":"/a/-","value":3}]`, `{"a":[1,2,3]}`},
+		{"add whole document", `{"a":1}`, `[{"op":"add","path":"","value":[1]}]`, `[1]`},
+		{"remove member", `{"a":1,"b":2}`, `[{"op":"remove","path":"/a"}]`, `{"b":2}`},
+		{"remove from array", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/0"}]`, `{"a":[2,3]}`},
+		{"replace", `{"a":{"b":1}}`, `[{"op":"replace","path":"/a/b","value":"x"}]`, `{"a":{"b":"x"}}`},
+		{"replace in array", `[1,2,3]`, `[{"op":"replace","path":"/1","value":9}]`, `[1,9,3]`},
+		{"move member", `{"a":{"b":1},"c":{}}`, `[{"op":"move","from":"/a/b","path":"/c/d"}]`, `{"a":{},"c":{"d":1}}`},
+		// The target index is read after the value is removed
+		{"move forward in array", `[1,2,3,4]`, `[{"op":"move","from":"/0","path":"/2"}]`, `[2,3,1,4]`},
+		{"move back in array", `[1,2,3,4]`, `[{"op":"move","from":"/3","path":"/0"}]`, `[4,1,2,3]`},
+		{"move from array to object", `{"a":[1,2],"b":{}}`, `[{"op":"move","from":"/a/1","path":"/b/x"}]`, `{"a":[1],"b":{"x":2}}`},
+		{"copy", `{"a":{"b":[1]}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/b/-","value":2}]`, `{"a":{"b":[1]},"c":{"b":[1,2]}}`},
+		{"test passes", `{"a":[1,"x"]}`, `[{"op":"test","path":"/a","value":[1,"x"]}]`, `{"a":[1,"x"]}`},
+		{"escaped pointer", `{"a/b":{"m~n":1}}`, `[{"op":"replace","path":"/a~1b/m~0n","value":2}]`, `{"a/b":{"m~n":2}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalJSON(t, string(got), tt.want) {
+				t.Fatalf("Apply = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyKeepsLargeIntegers(t *testing.T) {
+	got, err := Apply([]byte(`{"a":12345678901234567890}`), []byte(`[{"op":"copy","from":"/a","path":"/b"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"a":12345678901234567890,"b":12345678901234567890}` {
+		t.Fatalf("Apply = %s", got)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  error
+	}{
+		{"not an array", `{}`, `{"op":"add"}`, ErrInvalidPatch},
+		{"unknown operation", `{}`, `[{"op":"merge","path":"/a","value":1}]`, ErrInvalidPatch},
+		{"missing value", `{}`, `[{"op":"add","path":"/a"}]`, ErrInvalidPatch},
+		{"bad pointer", `{}`, `[{"op":"add","path":"a","value":1}]`, ErrInvalidPatch},
+		{"add to missing parent", `{}`, `[{"op":"add","path":"/a/b","value":1}]`, ErrInvalidPatch},
+		{"add past array end", `[1]`, `[{"op":"add","path":"/2","value":1}]`, ErrInvalidPatch},
+		{"leading zero index", `[1,2]`, `[{"op":"replace","path":"/01","value":1}]`, ErrInvalidPatch},
+		{"negative index", `[1,2]`, `[{"op":"remove","path":"/-1"}]`, ErrInvalidPatch},
+		{"append is not an element", `[1,2]`, `[{"op":"remove","path":"/-"}]`, ErrInvalidPatch},
+		{"remove missing member", `{}`, `[{"op":"remove","path":"/a"}]`, ErrInvalidPatch},
+		{"remove whole document", `{}`, `[{"op":"remove","path":""}]`, ErrInvalidPatch},
+		{"replace missing member", `{}`, `[{"op":"replace","path":"/a","value":1}]`, ErrInvalidPatch},
+		{"move into own child", `{"a":{"b":{}}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, ErrInvalidPatch},
+		{"copy from missing member", `{}`, `[{"op":"copy","from":"/a","path":"/b"}]`, ErrInvalidPatch},
+		{"test fails", `{"a":1}`, `[{"op":"test","path":"/a","value":2}]`, ErrTestFailed},
+		{"test of number type", `{"a":1}`, `[{"op":"test","path":"/a","value":"1"}]`, ErrTestFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Apply = %s, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyIsAtomic(t *testing.T) {
+	doc := []byte(`{"a":1}`)
+	_, err := Apply(doc, []byte(`[{"op":"replace","path":"/a","value":2},{"op":"test","path":"/a","value":1}]`))
+	if !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("Apply error = %v, want ErrTestFailed", err)
+	}
+	if string(doc) != `{"a":1}` {
+		t.Fatalf("document changed to %s", doc)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// Examples from RFC 7396, appendix A
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Fatalf("MergePatch(%s, %s): %v", tt.doc, tt.patch, err)
+		}
+		if !equalJSON(t, string(got), tt.want) {
+			t.Fatalf("MergePatch(%s, %s) = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	if _, err := MergePatch([]byte(`{}`), []byte(`{"a":`)); !errors.Is(err, ErrInvalidPatch) {
+		t.Fatalf("MergePatch error = %v, want ErrInvalidPatch", err)
+	}
+}
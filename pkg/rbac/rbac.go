@@ -0,0 +1,180 @@
+// Package rbac grants permissions to subjects through roles bound to regions
+// and environments.
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"vhub/pkg/data"
+)
+
+// Subjects are prefixed by the source of the identity they name, so that an
+// API token cannot be named after a JWT subject or a group.
+const (
+	TokenSubject = "token:"
+	OIDCSubject  = "oidc:"
+	CertSubject  = "cert:"
+	GroupSubject = "group:"
+)
+
+// SubjectPrefixes lists the prefixes a subject may start with.
+var SubjectPrefixes = []string{TokenSubject, OIDCSubject, CertSubject, GroupSubject}
+
+// Role names a set of actions: read, write or admin.
+type Role struct {
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// Binding grants a role to a subject, such as token:ci, oidc:<sub>,
+// cert:<name> or group:<name>, in the regions and environments matching
+// its globs (as in path.Match). An empty glob, like "*", matches everything.
+type Binding struct {
+	Subject     string `json:"subject"`
+	Role        string `json:"role"`
+	Region      string `json:"region"`
+	Environment string `json:"environment"`
+}
+
+// Policy is the set of roles and bindings loaded from a config file.
+type Policy struct {
+	Roles    []Role    `json:"roles"`
+	Bindings []Binding `json:"bindings"`
+}
+
+// DefaultRoles are available to bindings without being declared.
+var DefaultRoles = []Role{
+	{Name: "viewer", Actions: []string{data.ActionRead}},
+	{Name: "editor", Actions: []string{data.ActionWrite}},
+	{Name: "admin", Actions: []string{data.ActionAdmin}},
+}
+
+// Load reads a policy from a JSON file and checks it.
+func Load(file string) (*Policy, error) {
+	var policy Policy
+
+	configFile, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer configFile.Close()
+
+	if err := json.NewDecoder(configFile).Decode(&policy); err != nil {
+		return nil, err
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Validate checks that every role has known actions and every binding names
+// a prefixed subject, a known role and well-formed globs.
+func (p *Policy) Validate() error {
+	for _, role := range p.Roles {
+		if role.Name == "" {
+			return fmt.Errorf("role without a name")
+		}
+		for _, action := range role.Actions {
+			switch action {
+			case data.ActionRead, data.ActionWrite, data.ActionAdmin:
+			default:
+				return fmt.Errorf("role %s: unknown action %q", role.Name, action)
+			}
+		}
+	}
+	for i, binding := range p.Bindings {
+		if binding.Subject == "" {
+			return fmt.Errorf("binding %d: subject is required", i)
+		}
+		if !validSubject(binding.Subject) {
+			return fmt.Errorf("binding %d: subject %q must start with one of %s", i, binding.Subject, strings.Join(SubjectPrefixes, ", "))
+		}
+		if _, ok := p.role(binding.Role); !ok {
+			return fmt.Errorf("binding %d: unknown role %q", i, binding.Role)
+		}
+		for _, glob := range []string{binding.Region, binding.Environment} {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("binding %d: invalid glob %q", i, glob)
+			}
+		}
+	}
+	return nil
+}
+
+// role looks a role up among the declared roles, then the default ones.
+func (p *Policy) role(name string) (Role, bool) {
+	for _, roles := range [][]Role{p.Roles, DefaultRoles} {
+		for _, role := range roles {
+			if role.Name == name {
+				return role, true
+			}
+		}
+	}
+	return Role{}, false
+}
+
+// Allows reports whether any of subjects is bound to a role granting perm.
+// Like token scopes, reading is not limited to the bound regions, and admin
+// applies everywhere; write applies where the binding's globs match.
+func (p *Policy) Allows(subjects []string, perm data.Permission) bool {
+	if p == nil {
+		return false
+	}
+	for _, binding := range p.Bindings {
+		if !contains(subjects, binding.Subject) {
+			continue
+		}
+		role, _ := p.role(binding.Role)
+		for _, action := range role.Actions {
+			switch {
+			case action == data.ActionAdmin:
+				return true
+			case perm.Action == data.ActionRead && (action == data.ActionRead || action == data.ActionWrite):
+				return true
+			case perm.Action == data.ActionWrite && action == data.ActionWrite && binding.covers(perm):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// covers reports whether the globs of b match the region and environment of
+// perm. A write to a whole region, or to the list of regions, is only covered
+// by bindings that do not narrow it down further.
+func (b Binding) covers(perm data.Permission) bool {
+	return glob(b.Region, perm.Region) && glob(b.Environment, perm.Environment)
+}
+
+func glob(pattern, name string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	if name == "" {
+		return false
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+func validSubject(subject string) bool {
+	for _, prefix := range SubjectPrefixes {
+		if name, ok := strings.CutPrefix(subject, prefix); ok && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
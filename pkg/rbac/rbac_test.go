@@ -0,0 +1,114 @@
+package rbac
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"vhub/pkg/data"
+)
+
+func TestAllows(t *testing.T) {
+	policy := &Policy{
+		Roles: []Role{{Name: "deployer", Actions: []string{data.ActionRead, data.ActionWrite}}},
+		Bindings: []Binding{
+			{Subject: "token:ci", Role: "editor", Region: "amer", Environment: "dev-*"},
+			{Subject: "group:sre", Role: "deployer", Region: "*"},
+			{Subject: "oidc:alice", Role: "viewer"},
+			{Subject: "cert:ops", Role: "admin"},
+			{Subject: "token:eu", Role: "editor", Region: "eu-?"},
+		},
+	}
+	write := func(region, environment string) data.Permission {
+		return data.Permission{Action: data.ActionWrite, Region: region, Environment: environment}
+	}
+	read := data.Permission{Action: data.ActionRead}
+	admin := data.Permission{Action: data.ActionAdmin}
+
+	tests := []struct {
+		name     string
+		subjects []string
+		perm     data.Permission
+		want     bool
+	}{
+		{"glob on environment", []string{"token:ci"}, write("amer", "dev-1"), true},
+		{"environment outside glob", []string{"token:ci"}, write("amer", "prod"), false},
+		{"region outside binding", []string{"token:ci"}, write("emea", "dev-1"), false},
+		{"whole region under an environment binding", []string{"token:ci"}, write("amer", ""), false},
+		{"region list under a region binding", []string{"token:ci"}, write("", ""), false},
+		{"write binding reads everywhere", []string{"token:ci"}, read, true},
+		{"write binding is not admin", []string{"token:ci"}, admin, false},
+		{"group", []string{"oidc:bob", "group:sre"}, write("emea", "prod"), true},
+		{"group covers whole regions", []string{"group:sre"}, write("emea", ""), true},
+		{"other group", []string{"oidc:bob", "group:dev"}, write("emea", "prod"), false},
+		{"viewer reads", []string{"oidc:alice"}, read, true},
+		{"viewer does not write", []string{"oidc:alice"}, write("amer", "dev-1"), false},
+		{"admin writes anywhere", []string{"cert:ops"}, write("apac", "prod"), true},
+		{"admin", []string{"cert:ops"}, admin, true},
+		{"single character glob", []string{"token:eu"}, write("eu-1", "prod"), true},
+		{"single character glob too long", []string{"token:eu"}, write("eu-10", "prod"), false},
+		{"subject names are exact", []string{"token:ci2"}, read, false},
+		{"subject from another source", []string{"oidc:ci"}, read, false},
+		{"nobody", nil, read, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Allows(tt.subjects, tt.perm); got != tt.want {
+				t.Fatalf("Allows(%v, %+v) = %v, want %v", tt.subjects, tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilPolicyAllowsNothing(t *testing.T) {
+	var policy *Policy
+	if policy.Allows([]string{"token:ci"}, data.Permission{Action: data.ActionRead}) {
+		t.Fatal("nil policy allowed a read")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		valid  bool
+	}{
+		{"default role", Policy{Bindings: []Binding{{Subject: "token:ci", Role: "editor"}}}, true},
+		{"declared role", Policy{Roles: []Role{{Name: "ops", Actions: []string{"admin"}}}, Bindings: []Binding{{Subject: "group:ops", Role: "ops"}}}, true},
+		{"unknown role", Policy{Bindings: []Binding{{Subject: "token:ci", Role: "owner"}}}, false},
+		{"unknown action", Policy{Roles: []Role{{Name: "ops", Actions: []string{"delete"}}}}, false},
+		{"role without a name", Policy{Roles: []Role{{Actions: []string{"read"}}}}, false},
+		{"no subject", Policy{Bindings: []Binding{{Role: "viewer"}}}, false},
+		{"unprefixed subject", Policy{Bindings: []Binding{{Subject: "ci", Role: "viewer"}}}, false},
+		{"prefix only", Policy{Bindings: []Binding{{Subject: "group:", Role: "viewer"}}}, false},
+		{"invalid glob", Policy{Bindings: []Binding{{Subject: "token:ci", Role: "editor", Region: "[amer"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err == nil) != tt.valid {
+				t.Fatalf("Validate = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac.json")
+	config := `{"bindings":[{"subject":"token:ci","role":"editor","region":"amer"}]}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.Allows([]string{"token:ci"}, data.Permission{Action: data.ActionWrite, Region: "amer", Environment: "prod"}) {
+		t.Fatal("loaded policy does not allow the bound write")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"bindings":[{"subject":"ci","role":"editor"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("Load of an invalid policy succeeded")
+	}
+}
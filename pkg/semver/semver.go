@@ -0,0 +1,214 @@
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed Semantic Versioning 2.0.0 version.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	PreRelease []string
+	Build      []string
+}
+
+// Parse parses a SemVer 2.0.0 version such as 1.2.3, 1.2.3-rc.1 or
+// 1.2.3+build.5. A leading "v" is not accepted.
+func Parse(s string) (Version, error) {
+	var v Version
+
+	rest := s
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		build := rest[i+1:]
+		rest = rest[:i]
+		ids, err := parseIdentifiers(build, false)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid build metadata in %q: %w", s, err)
+		}
+		v.Build = ids
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		pre := rest[i+1:]
+		rest = rest[:i]
+		ids, err := parseIdentifiers(pre, true)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid pre-release in %q: %w", s, err)
+		}
+		v.PreRelease = ids
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("invalid version %q: expected MAJOR.MINOR.PATCH", s)
+	}
+	nums := make([]uint64, 3)
+	for i, part := range parts {
+		n, err := parseNumeric(part)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid version %q: %w", s, err)
+		}
+		nums[i] = n
+	}
+	v.Major, v.Minor, v.Patch = nums[0], nums[1], nums[2]
+	return v, nil
+}
+
+// Valid reports whether s is a valid SemVer 2.0.0 version.
+func Valid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+func parseNumeric(s string) (uint64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty numeric identifier")
+	}
+	if len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("numeric identifier %q has a leading zero", s)
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("numeric identifier %q is not a number", s)
+		}
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func parseIdentifiers(s string, preRelease bool) ([]string, error) {
+	ids := strings.Split(s, ".")
+	for _, id := range ids {
+		if id == "" {
+			return nil, fmt.Errorf("empty identifier")
+		}
+		numeric := true
+		for _, c := range id {
+			switch {
+			case c >= '0' && c <= '9':
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '-':
+				numeric = false
+			default:
+				return nil, fmt.Errorf("identifier %q contains %q", id, c)
+			}
+		}
+		if preRelease && numeric && len(id) > 1 && id[0] == '0' {
+			return nil, fmt.Errorf("numeric identifier %q has a leading zero", id)
+		}
+	}
+	return ids, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.PreRelease) > 0 {
+		s += "-" + strings.Join(v.PreRelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0 or +1 depending on whether v has lower, equal or
+// higher precedence than o. Build metadata is ignored.
+func (v Version) Compare(o Version) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePreRelease(v.PreRelease, o.PreRelease)
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func comparePreRelease(a, b []string) int {
+	// A version without a pre-release has higher precedence
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		an, aErr := strconv.ParseUint(a[i], 10, 64)
+		bn, bErr := strconv.ParseUint(b[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := compareUint(an, bn); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			// Numeric identifiers have lower precedence than alphanumeric
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareUint(uint64(len(a)), uint64(len(b)))
+}
+
+// Difference names the most significant part in which two versions differ.
+type Difference string
+
+const (
+	DiffNone       Difference = ""
+	DiffMajor      Difference = "major"
+	DiffMinor      Difference = "minor"
+	DiffPatch      Difference = "patch"
+	DiffPreRelease Difference = "prerelease"
+)
+
+// Diff returns the most significant part in which v and o differ. Build
+// metadata is ignored.
+func Diff(v, o Version) Difference {
+	switch {
+	case v.Major != o.Major:
+		return DiffMajor
+	case v.Minor != o.Minor:
+		return DiffMinor
+	case v.Patch != o.Patch:
+		return DiffPatch
+	case comparePreRelease(v.PreRelease, o.PreRelease) != 0:
+		return DiffPreRelease
+	}
+	return DiffNone
+}
+
+// CompareStrings compares two version strings by precedence. Strings that
+// are not valid versions have lower precedence than every valid version
+// and are compared lexically among themselves.
+func CompareStrings(a, b string) int {
+	av, aErr := Parse(a)
+	bv, bErr := Parse(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return av.Compare(bv)
+	case aErr == nil:
+		return 1
+	case bErr == nil:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
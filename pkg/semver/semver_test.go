@@ -0,0 +1,122 @@
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		valid bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"0.0.0", "0.0.0", true},
+		{"1.2.3-rc.1", "1.2.3-rc.1", true},
+		{"1.2.3+build.5", "1.2.3+build.5", true},
+		{"1.2.3-alpha-1.0a+001", "1.2.3-alpha-1.0a+001", true},
+		{"v1.2.3", "", false},
+		{"1.2", "", false},
+		{"1.2.3.4", "", false},
+		{"01.2.3", "", false},
+		{"1.2.3-01", "", false},
+		{"1.2.3-", "", false},
+		{"1.2.3-rc..1", "", false},
+		{"1.2.3+", "", false},
+		{"1.2.3-rc_1", "", false},
+		{"1.x.3", "", false},
+	}
+	for _, tt := range tests {
+		v, err := Parse(tt.in)
+		if (err == nil) != tt.valid {
+			t.Fatalf("Parse(%q) error = %v, want valid %v", tt.in, err, tt.valid)
+		}
+		if tt.valid && v.String() != tt.want {
+			t.Fatalf("Parse(%q) = %s, want %s", tt.in, v, tt.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// In order of precedence, from the SemVer 2.0.0 specification
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if got := mustParse(t, a).Compare(mustParse(t, b)); got != want {
+				t.Fatalf("%s.Compare(%s) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestCompareIgnoresBuild(t *testing.T) {
+	if got := mustParse(t, "1.0.0+a").Compare(mustParse(t, "1.0.0+b")); got != 0 {
+		t.Fatalf("Compare = %d, want 0", got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want Difference
+	}{
+		{"1.2.3", "1.2.3", DiffNone},
+		{"1.2.3+a", "1.2.3+b", DiffNone},
+		{"1.2.3", "2.2.3", DiffMajor},
+		{"1.2.3", "1.3.0", DiffMinor},
+		{"1.2.3", "1.2.4", DiffPatch},
+		{"1.2.3-rc.1", "1.2.3", DiffPreRelease},
+	}
+	for _, tt := range tests {
+		if got := Diff(mustParse(t, tt.a), mustParse(t, tt.b)); got != tt.want {
+			t.Fatalf("Diff(%s, %s) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) Version {
+	t.Helper()
+	v, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestCompareStrings(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0+build", 0},
+		{"latest", "0.0.1", -1},
+		{"0.0.1", "latest", 1},
+		{"abc", "abd", -1},
+		{"v2", "v2", 0},
+	}
+	for _, tt := range tests {
+		if got := CompareStrings(tt.a, tt.b); got != tt.want {
+			t.Fatalf("CompareStrings(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -10,10 +10,17 @@ import (
 
 type ViewData struct {
 	Regions map[string]data.Region `json:"regions"`
-	Health  []checker.HealthStatus `json:"health"` // Health status
+	Matrix  data.Matrix            `json:"matrix"` // Version matrix
+	Health  []checker.CheckHistory `json:"health"` // Health status and latest results
+	// Locks holds the locks in force, keyed by region for those on a whole
+	// region and by region/environment for the others.
+	Locks map[string][]data.Lock `json:"locks"`
+	// Versions holds the results of the version checks, keyed by
+	// data.HistoryKey.
+	Versions map[string]checker.VersionDrift `json:"versions"`
 }
 
-func RenderTemplate(w http.ResponseWriter, regionData map[string]data.Region, healthData []checker.HealthStatus) {
+func RenderTemplate(w http.ResponseWriter, regionData map[string]data.Region, matrix data.Matrix, healthData []checker.CheckHistory, locks map[string][]data.Lock, versions map[string]checker.VersionDrift) {
 	tmpl, err := template.ParseFiles("templates/template.html")
 	if err != nil {
 		log.Println("Template parse error: ", err)
@@ -22,8 +29,11 @@ func RenderTemplate(w http.ResponseWriter, regionData map[string]data.Region, he
 	}
 
 	viewData := ViewData{
-		Regions: regionData,
-		Health:  healthData,
+		Regions:  regionData,
+		Matrix:   matrix,
+		Health:   healthData,
+		Locks:    locks,
+		Versions: versions,
 	}
 
 	err = tmpl.Execute(w, viewData)
@@ -0,0 +1,260 @@
+// Package webhook delivers the changes committed to a store to the webhooks
+// registered in it.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+	"vhub/pkg/data"
+)
+
+const (
+	SignatureHeader = "X-Vhub-Signature-256"
+	EventHeader     = "X-Vhub-Event"
+	DeliveryHeader  = "X-Vhub-Delivery"
+)
+
+// Payload is the JSON body sent to a webhook.
+type Payload struct {
+	Delivery  string    `json:"delivery"`
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	data.Change
+}
+
+// Delivery records one attempt to deliver a payload to a webhook.
+type Delivery struct {
+	ID         string    `json:"id"`
+	Webhook    string    `json:"webhook"`
+	Event      string    `json:"event"`
+	Revision   uint64    `json:"revision"`
+	Attempt    int       `json:"attempt"`
+	Timestamp  time.Time `json:"timestamp"`
+	DurationMs int64     `json:"durationMs"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+}
+
+// Dispatcher sends the changes committed to a store to every matching
+// webhook, retrying failed deliveries with exponential backoff.
+type Dispatcher struct {
+	// MaxAttempts is the number of times a payload is sent before giving up.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry; it doubles with
+	// every further retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout limits each attempt.
+	Timeout time.Duration
+	// LogSize is the number of attempts kept per webhook.
+	LogSize int
+
+	store  data.Store
+	client *http.Client
+	queue  chan []data.Change
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	log map[string][]Delivery
+}
+
+func NewDispatcher(store data.Store) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		Timeout:        10 * time.Second,
+		LogSize:        100,
+		store:          store,
+		client:         &http.Client{},
+		queue:          make(chan []data.Change, 1024),
+		ctx:            ctx,
+		cancel:         cancel,
+		log:            make(map[string][]Delivery),
+	}
+}
+
+// Start subscribes d to the changes of its store and starts delivering them.
+func (d *Dispatcher) Start() {
+	d.store.OnChange(d.enqueue)
+
+	d.wg.Add(1)
+	go d.run()
+}
+
+// Stop abandons pending deliveries and waits for attempts in flight to end.
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// enqueue is called by the store with the store locked, so it must not
+// block.
+func (d *Dispatcher) enqueue(changes []data.Change) {
+	select {
+	case d.queue <- changes:
+	default:
+		data.Log.WithField("changes", len(changes)).Warn("Webhook queue is full; dropping changes")
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case changes := <-d.queue:
+			hooks, err := d.store.ListWebhooks()
+			if err != nil {
+				data.Log.WithField("error", err).Error("Failed to load webhooks")
+				continue
+			}
+			for _, change := range changes {
+				for _, hook := range hooks {
+					if hook.Matches(change) {
+						d.wg.Add(1)
+						go d.deliver(hook, change)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Sign returns the signature header value of body: the hex encoded
+// HMAC-SHA256 of body keyed with secret, prefixed with "sha256=".
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) deliver(hook data.Webhook, change data.Change) {
+	defer d.wg.Done()
+
+	payload := Payload{
+		Delivery:  data.NewID(),
+		Event:     data.EventType(change),
+		Timestamp: time.Now().UTC(),
+		Change:    change,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		data.Log.WithField("error", err).Error("Failed to encode webhook payload")
+		return
+	}
+
+	backoff := d.InitialBackoff
+	for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+		delivery := d.send(hook, payload, body)
+		delivery.Attempt = attempt
+		d.record(delivery)
+		if delivery.Success {
+			return
+		}
+
+		if attempt == d.MaxAttempts {
+			data.Log.WithField("webhook", hook.ID).WithField("delivery", payload.Delivery).Warn("Giving up on webhook delivery")
+			return
+		}
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+	}
+}
+
+func (d *Dispatcher) send(hook data.Webhook, payload Payload, body []byte) Delivery {
+	delivery := Delivery{
+		ID:        payload.Delivery,
+		Webhook:   hook.ID,
+		Event:     payload.Event,
+		Revision:  payload.Revision,
+		Timestamp: time.Now().UTC(),
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, d.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "vhub-webhook")
+	req.Header.Set(EventHeader, payload.Event)
+	req.Header.Set(DeliveryHeader, payload.Delivery)
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+	}
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = fmt.Sprintf("unexpected status %s", resp.Status)
+	}
+	return delivery
+}
+
+func (d *Dispatcher) record(delivery Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	log := append(d.log[delivery.Webhook], delivery)
+	if len(log) > d.LogSize {
+		log = log[len(log)-d.LogSize:]
+	}
+	d.log[delivery.Webhook] = log
+}
+
+// Deliveries returns the recent delivery attempts of a webhook, newest first.
+func (d *Dispatcher) Deliveries(hookID string) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	log := d.log[hookID]
+	deliveries := make([]Delivery, len(log))
+	for i, delivery := range log {
+		deliveries[len(log)-1-i] = delivery
+	}
+	return deliveries
+}
+
+// Forget drops the delivery log of a deleted webhook.
+func (d *Dispatcher) Forget(hookID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.log, hookID)
+}
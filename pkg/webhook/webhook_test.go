@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"vhub/pkg/data"
+)
+
+func TestSign(t *testing.T) {
+	// Test case 2 of RFC 4231
+	got := Sign("Jefe", []byte("what do ya want for nothing?"))
+	want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+}
+
+// hookServer answers the first failures requests with 500 and the rest with
+// 200, recording when each arrived and whether its signature was valid.
+type hookServer struct {
+	*httptest.Server
+	failures int
+
+	mu       sync.Mutex
+	times    []time.Time
+	badSig   bool
+	received chan struct{}
+}
+
+func newHookServer(t *testing.T, secret string, failures int) *hookServer {
+	s := &hookServer{failures: failures, received: make(chan struct{}, 100)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.times = append(s.times, time.Now())
+		if r.Header.Get(SignatureHeader) != Sign(secret, body) || r.Header.Get(EventHeader) != "app.created" {
+			s.badSig = true
+		}
+		failed := len(s.times) <= s.failures
+		s.mu.Unlock()
+
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		s.received <- struct{}{}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// gaps returns the time between consecutive requests.
+func (s *hookServer) gaps() []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var gaps []time.Duration
+	for i := 1; i < len(s.times); i++ {
+		gaps = append(gaps, s.times[i].Sub(s.times[i-1]))
+	}
+	return gaps
+}
+
+// startDispatcher registers a webhook for server in a new store and returns
+// a started dispatcher for it, along with the store.
+func startDispatcher(t *testing.T, server *hookServer, secret string) (*Dispatcher, data.Store) {
+	store := data.NewMemoryStore()
+	err := store.Update(func(tx data.Tx) error {
+		if err := tx.CreateWebhook(data.Webhook{ID: "hook", URL: server.URL, Secret: secret}); err != nil {
+			return err
+		}
+		if err := tx.CreateRegion(data.Region{Name: "amer"}); err != nil {
+			return err
+		}
+		return tx.CreateEnvironment("amer", data.Environment{Name: "dev"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(store)
+	d.MaxAttempts = 4
+	d.InitialBackoff = 20 * time.Millisecond
+	d.MaxBackoff = 30 * time.Millisecond
+	d.Start()
+	t.Cleanup(d.Stop)
+	return d, store
+}
+
+func createApp(t *testing.T, store data.Store) {
+	if err := store.CreateApp("amer", "dev", data.App{Name: "api", Version: "1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitForRequests waits until server has received n requests, and a little
+// longer to catch any beyond that.
+func waitForRequests(t *testing.T, server *hookServer, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-server.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("webhook received %d requests, want %d", i, n)
+		}
+	}
+	select {
+	case <-server.received:
+		t.Fatalf("webhook received more than %d requests", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	server := newHookServer(t, "secret", 2)
+	d, store := startDispatcher(t, server, "secret")
+
+	createApp(t, store)
+	waitForRequests(t, server, 3)
+
+	if server.badSig {
+		t.Fatal("webhook received a request with a wrong signature or event")
+	}
+	gaps := server.gaps()
+	if gaps[0] < 20*time.Millisecond || gaps[1] < 30*time.Millisecond {
+		t.Fatalf("gaps between attempts = %v, want at least 20ms, then 30ms", gaps)
+	}
+
+	deliveries := d.Deliveries("hook")
+	if len(deliveries) != 3 {
+		t.Fatalf("deliveries = %+v, want 3", deliveries)
+	}
+	for i, delivery := range deliveries {
+		attempt := 3 - i
+		success := attempt == 3
+		if delivery.Attempt != attempt || delivery.Success != success || delivery.Event != "app.created" || delivery.ID != deliveries[0].ID {
+			t.Errorf("delivery %d = %+v, want attempt %d with success %v", i, delivery, attempt, success)
+		}
+	}
+	if deliveries[1].StatusCode != http.StatusInternalServerError || deliveries[1].Error == "" {
+		t.Errorf("failed delivery = %+v", deliveries[1])
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	server := newHookServer(t, "", 100)
+	d, store := startDispatcher(t, server, "")
+
+	createApp(t, store)
+	waitForRequests(t, server, 4)
+
+	// The backoff doubles from 20ms but is capped at 30ms
+	gaps := server.gaps()
+	for i, want := range []time.Duration{20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond} {
+		if gaps[i] < want {
+			t.Fatalf("gaps between attempts = %v, want at least 20ms, 30ms, 30ms", gaps)
+		}
+	}
+	if gaps[2] > 500*time.Millisecond {
+		t.Fatalf("gaps between attempts = %v, want the backoff capped", gaps)
+	}
+	for _, delivery := range d.Deliveries("hook") {
+		if delivery.Success {
+			t.Fatalf("delivery %+v succeeded", delivery)
+		}
+	}
+}
+
+func TestDispatcherSkipsUnsubscribedEvents(t *testing.T) {
+	server := newHookServer(t, "", 0)
+	d, store := startDispatcher(t, server, "")
+	if err := store.UpdateWebhook("hook", data.Webhook{ID: "hook", URL: server.URL, Events: []string{"app.deleted"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	createApp(t, store)
+	waitForRequests(t, server, 0)
+	if deliveries := d.Deliveries("hook"); len(deliveries) != 0 {
+		t.Fatalf("deliveries = %+v, want none", deliveries)
+	}
+}
@@ -4,6 +4,16 @@ echo "INTEGRATION TESTS"
 echo "Please do not run this script in production"
 echo ""
 
+# The API needs an admin bearer token: start vhub with the same
+# VHUB_BOOTSTRAP_TOKEN. Without one, the server must run with -auth=false.
+auth_header=()
+if [ -n "$VHUB_BOOTSTRAP_TOKEN" ]; then
+  auth_header=(-H "Authorization: Bearer $VHUB_BOOTSTRAP_TOKEN")
+else
+  echo "VHUB_BOOTSTRAP_TOKEN is not set; assuming the server runs with -auth=false"
+  echo ""
+fi
+
 handle_failure() {
     echo "================================================================="
     echo "Integration test failed on $1 $2 status_code:$3"
@@ -17,7 +27,7 @@ run_test() {
   data=$3
   content_type=$4
 
-  status_code=$(curl -o /dev/null -s -w "%{http_code}\n" -X $method -H "Content-Type: $content_type" -d "$data" $url)
+  status_code=$(curl -o /dev/null -s -w "%{http_code}\n" -X $method "${auth_header[@]}" -H "Content-Type: $content_type" -d "$data" $url)
   if [ $status_code -ge 200 ] && [ $status_code -lt 300 ]; then
     echo "Test passed for $url"
   else